@@ -1,14 +1,24 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"scallop/internal/config"
 	"scallop/internal/database"
+	"scallop/internal/geoip"
+	"scallop/internal/models"
 	"scallop/internal/monitor"
+	"scallop/internal/registry"
+	"scallop/internal/throughput"
 	"scallop/internal/web"
 )
 
@@ -16,8 +26,44 @@ func main() {
 	// 解析命令行参数
 	configPath := flag.String("config", "config.json", "配置文件路径")
 	dataDir := flag.String("data", "", "数据目录路径（默认为当前目录）")
+	backend := flag.String("storage", "sqlite", "存储类型：sqlite、postgres、memory、segment，未指定时使用配置文件中的storage.backend（默认sqlite）")
+	dsn := flag.String("dsn", "", "PostgreSQL连接字符串，未指定时使用环境变量SCALLOP_DSN")
+	capacity := flag.Int("memory-capacity", database.DefaultMemoryCapacity, "内存存储中每个目标保留的结果数，事件、解析地址和每个附加指标同样各保留这么多条")
+	snapshot := flag.String("snapshot", "", "内存存储退出时保存快照的文件，启动时从该文件恢复")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "用法: %s [选项] [retired <命令> | migrate | vacuum | backfill | backup | restore]\n\n选项：\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintf(flag.CommandLine.Output(), "\n%s\n", commandsUsage())
+	}
 	flag.Parse()
 
+	// 确定数据库路径
+	storage := storageOptions{backend: *backend, dsn: *dsn, capacity: *capacity, snapshot: *snapshot}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "storage" {
+			storage.backendSet = true
+		}
+	})
+	if *dataDir != "" {
+		storage.dbPath = filepath.Join(*dataDir, "ping_data.db")
+		storage.segmentDir = filepath.Join(*dataDir, "segments")
+	} else {
+		storage.dbPath = "./ping_data.db"
+		storage.segmentDir = "./segments"
+	}
+	if storage.dsn == "" {
+		storage.dsn = os.Getenv("SCALLOP_DSN")
+	}
+
+	// 子命令直接操作配置文件和数据库，执行完成后退出
+	if flag.NArg() > 0 {
+		if err := runCommand(flag.Args(), *configPath, storage); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	fmt.Println("正在启动Scallop网络延迟监控器...")
 
 	// 初始化配置管理器
@@ -27,48 +73,155 @@ func main() {
 		log.Fatal("加载配置失败:", err)
 	}
 
-	// 确定数据库路径
-	var dbPath string
-	if *dataDir != "" {
-		dbPath = filepath.Join(*dataDir, "ping_data.db")
-	} else {
-		dbPath = "./ping_data.db"
-	}
-
 	// 初始化数据库
-	fmt.Printf("初始化数据库: %s\n", dbPath)
-	db, err := database.New(dbPath)
+	storage = storage.withConfig(configManager.Get())
+	fmt.Printf("初始化数据库: %s\n", storage)
+	db, err := openStore(storage)
 	if err != nil {
 		log.Fatal("初始化数据库失败:", err)
 	}
-	defer db.Close()
+	defer func() {
+		// 内存存储在关闭时保存快照
+		if err := db.Close(); err != nil {
+			fmt.Printf("关闭数据库失败: %v\n", err)
+		}
+	}()
 
 	// 初始化目标
 	cfg := configManager.Get()
-	if err := db.UpdateTargetsFromConfig(cfg.Targets); err != nil {
+	if w, ok := db.(interface{ StartWriter(*models.Writer) }); ok {
+		w.StartWriter(cfg.Writer)
+	}
+	synced, err := db.SyncTargets(cfg.Targets)
+	if err != nil {
 		log.Fatal("初始化目标失败:", err)
 	}
+	targets := registry.New()
+	targets.Update(synced)
 
 	// 显示加载的目标
-	targets := db.GetTargets()
-	fmt.Printf("已加载 %d 个监控目标\n", len(targets))
-	for _, target := range targets {
+	fmt.Printf("已加载 %d 个监控目标\n", len(synced))
+	for _, target := range synced {
 		// Console打印显示真实地址
 		fmt.Printf("- %s (%s)\n", target.Description, target.Addr)
+		if target.HeartbeatToken != "" {
+			fmt.Printf("  心跳上报地址: /api/heartbeat/%s\n", target.HeartbeatToken)
+		}
 	}
 	fmt.Printf("Ping间隔: %d秒\n", cfg.PingInterval)
 	fmt.Printf("Ping次数: %d次取平均\n", cfg.PingCount)
 	fmt.Printf("Web端口: %d\n", cfg.WebPort)
 
+	// 收到SIGINT/SIGTERM时取消根context，再次收到信号时直接退出
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
 	// 启动监控器
 	fmt.Println("启动ping监控...")
-	mon := monitor.NewMonitor(db, configManager)
-	mon.Start()
+	geo := geoip.NewResolver()
+	mon := monitor.NewMonitor(db, configManager, targets, geo)
+	mon.Start(ctx)
+
+	// 启动带宽测试响应端
+	if cfg.ThroughputListen != "" {
+		responder, err := throughput.NewResponder(cfg.ThroughputListen, cfg.ThroughputSecret, cfg.ThroughputAllow)
+		if err != nil {
+			fmt.Printf("带宽测试响应端配置无效: %v\n", err)
+		} else {
+			go func() {
+				if err := responder.ListenAndServe(ctx); err != nil {
+					fmt.Printf("带宽测试响应端退出: %v\n", err)
+				}
+			}()
+		}
+	}
 
-	// 启动Web服务器
+	// 启动Web服务器，阻塞直到收到退出信号
 	fmt.Println("启动Web服务器...")
-	server := web.NewServer(db, configManager)
-	if err := server.Start(); err != nil {
-		log.Fatal("启动Web服务器失败:", err)
+	server := web.NewServer(db, configManager, targets, geo)
+	serverErr := server.Start(ctx)
+	stop()
+
+	// 关闭Web服务器和等待探测共用一个期限，全部在shutdown_timeout秒内完成，之后关闭数据库
+	fmt.Println("正在关闭Web服务器，等待进行中的请求和探测完成...")
+	timeout := time.Duration(configManager.Get().ShutdownTimeout) * time.Second
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- server.Shutdown(shutdownCtx)
+	}()
+	if err := mon.Wait(shutdownCtx); err != nil {
+		fmt.Printf("等待探测完成超时，已取消剩余探测: %v\n", err)
+	}
+	if err := <-shutdownErr; err != nil {
+		fmt.Printf("等待Web请求完成超时: %v\n", err)
+	}
+
+	if serverErr != nil {
+		db.Close()
+		log.Fatal("Web服务器异常退出:", serverErr)
+	}
+	fmt.Println("已退出")
+}
+
+// runCommand 执行子命令
+func runCommand(args []string, configPath string, storage storageOptions) error {
+	switch args[0] {
+	case "retired", "vacuum", "backfill", "migrate", "backup", "restore":
+	default:
+		return fmt.Errorf("未知的子命令: %s\n\n%s", args[0], commandsUsage())
+	}
+
+	// 配置文件不存在时retired命令会创建默认配置，维护命令只在配置文件存在时读取其中的存储设置
+	configManager := config.NewManager(configPath)
+	if _, err := os.Stat(configPath); err == nil || args[0] == "retired" {
+		if err := configManager.Load(); err != nil {
+			return fmt.Errorf("加载配置失败: %v", err)
+		}
+		storage = storage.withConfig(configManager.Get())
 	}
+
+	switch args[0] {
+	case "vacuum", "migrate":
+		// 分段存储的目标、事件和汇总同样保存在SQLite数据库中
+		if storage.backend != "sqlite" && storage.backend != "segment" {
+			return fmt.Errorf("%s命令只适用于SQLite存储", args[0])
+		}
+		if args[0] == "vacuum" {
+			return runVacuum(storage.dbPath)
+		}
+		return runMigrate(args[1:], storage.dbPath)
+	case "backfill", "backup", "restore":
+		if storage.backend != "sqlite" {
+			return fmt.Errorf("%s命令只适用于SQLite存储", args[0])
+		}
+		switch args[0] {
+		case "backfill":
+			return runBackfill(args[1:], storage.dbPath)
+		case "backup":
+			return runBackup(args[1:], storage.dbPath)
+		default:
+			return runRestore(args[1:], storage.dbPath)
+		}
+	}
+
+	if storage.backend == "memory" && storage.snapshot == "" {
+		return fmt.Errorf("使用内存存储时retired命令需要通过-snapshot指定快照文件")
+	}
+	db, err := openStore(storage)
+	if err != nil {
+		return fmt.Errorf("打开数据库失败: %v", err)
+	}
+	defer db.Close()
+	return runRetired(args[1:], configManager, db)
+}
+
+// commandsUsage 所有子命令的用法
+func commandsUsage() string {
+	return strings.Join([]string{retiredUsage, migrateUsage, vacuumUsage, backfillUsage, backupUsage, restoreUsage}, "\n\n")
 }
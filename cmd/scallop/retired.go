@@ -0,0 +1,181 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"scallop/internal/config"
+	"scallop/internal/database"
+	"scallop/internal/models"
+)
+
+const retiredUsage = `用法: scallop [选项] retired <命令>
+
+命令：
+  list                       列出已从配置中移除、仍保留历史数据的目标
+  history [-days N] <id>     按天汇总目标最后N天（默认30天）的归档结果
+  reactivate <id>            将目标重新写入配置文件，运行中的Scallop会自动重新加载
+  purge -yes <id>            删除目标及其全部历史数据`
+
+// runRetired 管理已移除的目标
+func runRetired(args []string, configManager *config.Manager, db database.Store) error {
+	command := "list"
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	active := make(map[string]bool)
+	for _, spec := range configManager.Get().Targets {
+		active[database.TargetID(spec)] = true
+	}
+
+	fs := flag.NewFlagSet("retired "+command, flag.ContinueOnError)
+	days := fs.Int("days", 30, "汇总的天数")
+	yes := fs.Bool("yes", false, "确认删除")
+	// 选项可以写在目标ID之前或之后
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	id := fs.Arg(0)
+	if fs.NArg() > 1 {
+		if err := fs.Parse(fs.Args()[1:]); err != nil {
+			return err
+		}
+	}
+	if command != "list" && id == "" {
+		return errors.New(retiredUsage)
+	}
+
+	switch command {
+	case "list":
+		return listRetired(db, active)
+	case "history":
+		return printRetiredHistory(db, id, active, *days)
+	case "reactivate":
+		target, err := getRetired(db, id, active)
+		if err != nil {
+			return err
+		}
+		if err := configManager.AddTarget(database.ReactivationSpec(target.Target)); err != nil {
+			return fmt.Errorf("写入配置失败: %v", err)
+		}
+		fmt.Printf("已将 %s (%s) 写入配置文件 %s\n", target.Description, target.ID, configManager.GetConfigPath())
+		return nil
+	case "purge":
+		target, err := getRetired(db, id, active)
+		if err != nil {
+			return err
+		}
+		if !*yes {
+			return fmt.Errorf("将删除 %s (%s) 及其 %d 条结果，确认请添加 -yes", target.Description, target.ID, target.ResultCount)
+		}
+		if err := db.PurgeTarget(id); err != nil {
+			return err
+		}
+		fmt.Printf("已删除 %s (%s) 及其 %d 条结果\n", target.Description, target.ID, target.ResultCount)
+		return nil
+	default:
+		return errors.New(retiredUsage)
+	}
+}
+
+// getRetired 获取已移除的目标，目标仍在配置中时给出提示
+func getRetired(db database.Store, id string, active map[string]bool) (models.RetiredTarget, error) {
+	if active[id] {
+		return models.RetiredTarget{}, fmt.Errorf("目标 %s 仍在配置中", id)
+	}
+	target, err := db.GetRetiredTarget(id, active)
+	if err == sql.ErrNoRows {
+		return target, fmt.Errorf("没有已移除的目标 %s", id)
+	}
+	return target, err
+}
+
+// listRetired 列出已移除的目标
+func listRetired(db database.Store, active map[string]bool) error {
+	retired, err := db.GetRetiredTargets(active)
+	if err != nil {
+		return err
+	}
+	if len(retired) == 0 {
+		fmt.Println("没有已移除的目标")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\t描述\t地址\t类型\t结果数\t最后结果")
+	for _, target := range retired {
+		last := "-"
+		if target.ResultCount > 0 {
+			last = target.LastResult.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", target.ID, target.Description, target.Addr,
+			target.Spec.ProbeType(), target.ResultCount, last)
+	}
+	return w.Flush()
+}
+
+// printRetiredHistory 按天汇总目标最后days天的结果
+func printRetiredHistory(db database.Store, id string, active map[string]bool, days int) error {
+	target, err := getRetired(db, id, active)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s (%s) %s\n", target.Description, target.Addr, target.ID)
+	if target.ResultCount == 0 {
+		fmt.Println("没有保留的结果")
+		return nil
+	}
+	fmt.Printf("结果: %d 条，%s 至 %s\n\n", target.ResultCount,
+		target.FirstResult.Local().Format("2006-01-02 15:04"), target.LastResult.Local().Format("2006-01-02 15:04"))
+
+	// 结果时间以文本保存且带有单调时钟读数，上界放宽1秒以包含最后一条结果
+	since := target.LastResult.AddDate(0, 0, -days)
+	results, err := db.GetPingResults(id, since, target.LastResult.Add(time.Second))
+	if err != nil {
+		return err
+	}
+
+	type summary struct {
+		count, success int
+		sum, min, max  float64
+	}
+	var order []string
+	daily := make(map[string]*summary)
+	for _, result := range results {
+		day := result.Timestamp.Local().Format("2006-01-02")
+		item, ok := daily[day]
+		if !ok {
+			item = &summary{min: math.Inf(1), max: math.Inf(-1)}
+			daily[day] = item
+			order = append(order, day)
+		}
+		item.count++
+		if result.Success {
+			item.success++
+			item.sum += result.Latency
+			item.min = math.Min(item.min, result.Latency)
+			item.max = math.Max(item.max, result.Latency)
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "日期\t结果数\t丢包率\t平均(ms)\t最小(ms)\t最大(ms)")
+	for _, day := range order {
+		item := daily[day]
+		loss := float64(item.count-item.success) / float64(item.count) * 100
+		if item.success == 0 {
+			fmt.Fprintf(w, "%s\t%d\t%.1f%%\t-\t-\t-\n", day, item.count, loss)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d\t%.1f%%\t%.2f\t%.2f\t%.2f\n", day, item.count, loss,
+			item.sum/float64(item.success), item.min, item.max)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"scallop/internal/database"
+	"scallop/internal/models"
+)
+
+const migrateUsage = `用法: scallop [选项] migrate [-dry-run]
+  应用未执行的数据库迁移（启动服务时也会自动执行），-dry-run只检查迁移能否成功而不修改数据库`
+
+const vacuumUsage = `用法: scallop [选项] vacuum
+  重建数据库、归还空闲空间并启用增量VACUUM，期间会阻塞写入，建议停止服务后执行`
+
+const backfillUsage = `用法: scallop [选项] backfill [-days N]
+  由原始结果重新计算最近N天（默认全部）的1分钟、1小时和1天汇总，可以在服务运行时执行`
+
+const backupUsage = `用法: scallop [选项] backup <文件>
+  在服务运行时生成数据库的一致快照，文件已存在时不会覆盖`
+
+const restoreUsage = `用法: scallop [选项] restore [-yes] <文件>
+  校验备份的结构版本和完整性后用它替换数据库，原数据库改名保留，需先停止服务`
+
+// storageOptions 命令行选择的存储
+type storageOptions struct {
+	backend    string // sqlite、postgres、memory、segment
+	backendSet bool   // 命令行指定了-storage，优先于配置文件
+	dbPath     string // SQLite数据库文件
+	dsn        string // PostgreSQL连接字符串
+	capacity   int    // 内存存储中每个目标保留的结果数
+	snapshot   string // 内存存储退出时保存快照的文件
+	segmentDir string // 分段存储的块文件目录
+	segment    *models.Segment
+}
+
+// withConfig 应用配置文件中的存储设置
+func (o storageOptions) withConfig(cfg models.Config) storageOptions {
+	if cfg.Storage == nil {
+		return o
+	}
+	if !o.backendSet && cfg.Storage.Backend != "" {
+		o.backend = cfg.Storage.Backend
+	}
+	o.segment = cfg.Storage.Segment
+	return o
+}
+
+// String 用于日志，不显示可能包含密码的连接字符串
+func (o storageOptions) String() string {
+	switch o.backend {
+	case "postgres":
+		return "PostgreSQL"
+	case "memory":
+		return fmt.Sprintf("内存（每个目标保留 %d 条结果）", o.capacity)
+	case "segment":
+		return fmt.Sprintf("%s（块文件目录 %s）", o.dbPath, o.segmentDir)
+	}
+	return o.dbPath
+}
+
+// openStore 打开命令行选择的存储，并应用未执行的数据库迁移
+func openStore(storage storageOptions) (database.Store, error) {
+	switch storage.backend {
+	case "sqlite":
+		db, err := database.New(storage.dbPath)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	case "postgres":
+		if storage.dsn == "" {
+			return nil, errors.New("使用PostgreSQL存储时需要通过-dsn或环境变量SCALLOP_DSN提供连接字符串")
+		}
+		db, err := database.NewPostgres(storage.dsn)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	case "memory":
+		if storage.capacity <= 0 {
+			return nil, errors.New("内存存储的容量必须大于0")
+		}
+		db, err := database.NewMemory(storage.capacity, storage.snapshot)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	case "segment":
+		db, err := database.NewSegment(storage.dbPath, storage.segmentDir, storage.segment)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	default:
+		return nil, fmt.Errorf("未知的存储类型: %s（可选sqlite、postgres、memory、segment）", storage.backend)
+	}
+}
+
+// runMigrate 显示数据库结构版本并应用未执行的迁移
+func runMigrate(args []string, dbPath string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "只检查迁移能否成功，不修改数据库")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := database.Open(dbPath)
+	if err != nil {
+		return fmt.Errorf("打开数据库失败: %v", err)
+	}
+	defer db.Close()
+
+	migrations, err := database.Migrations()
+	if err != nil {
+		return err
+	}
+	current, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("数据库 %s 的结构版本: %d，程序支持的版本: %d\n", dbPath, current, len(migrations))
+
+	applied, err := db.Migrate(*dryRun)
+	for _, migration := range applied {
+		if *dryRun {
+			fmt.Printf("- %s 可以应用\n", migration)
+		} else {
+			fmt.Printf("- 已应用 %s\n", migration)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(applied) == 0:
+		fmt.Println("没有需要执行的迁移")
+	case *dryRun:
+		fmt.Println("预演完成，数据库未被修改")
+	}
+	return nil
+}
+
+// runVacuum 重建数据库
+func runVacuum(dbPath string) error {
+	db, err := database.New(dbPath)
+	if err != nil {
+		return fmt.Errorf("打开数据库失败: %v", err)
+	}
+	defer db.Close()
+
+	before, err := db.Stats()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("正在重建数据库 %s (%d 字节，其中空闲 %d 字节)...\n", dbPath, before.SizeBytes, before.FreeBytes)
+	if err := db.Vacuum(); err != nil {
+		return fmt.Errorf("VACUUM失败: %v", err)
+	}
+	after, err := db.Stats()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("完成，数据库大小 %d 字节，auto_vacuum: %s\n", after.SizeBytes, after.AutoVacuum)
+	return nil
+}
+
+// runBackfill 为已有的原始结果补充汇总
+func runBackfill(args []string, dbPath string) error {
+	fs := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	days := fs.Int("days", 0, "补充最近多少天的汇总，0表示全部")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := database.New(dbPath)
+	if err != nil {
+		return fmt.Errorf("打开数据库失败: %v", err)
+	}
+	defer db.Close()
+
+	// 中断后已完成的日期保持有效，再次执行会重新计算
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var since time.Time
+	if *days > 0 {
+		since = time.Now().AddDate(0, 0, -*days)
+	}
+	started := time.Now()
+	err = db.BackfillRollups(ctx, since, func(targetID string, days, results int) {
+		fmt.Printf("- %s: %d 天，%d 条结果\n", targetID, days, results)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("汇总补充完成，耗时 %s\n", time.Since(started).Round(time.Second))
+	return nil
+}
+
+// runBackup 在线备份数据库
+func runBackup(args []string, dbPath string) error {
+	if len(args) != 1 {
+		return errors.New(backupUsage)
+	}
+	// 数据库不存在时Open会创建空数据库
+	if _, err := os.Stat(dbPath); err != nil {
+		return fmt.Errorf("数据库 %s 不存在", dbPath)
+	}
+
+	db, err := database.Open(dbPath)
+	if err != nil {
+		return fmt.Errorf("打开数据库失败: %v", err)
+	}
+	defer db.Close()
+
+	started := time.Now()
+	if err := db.Backup(context.Background(), args[0]); err != nil {
+		return err
+	}
+	info, err := database.InspectBackup(args[0])
+	if err != nil {
+		return fmt.Errorf("备份校验失败: %v", err)
+	}
+	fmt.Printf("已备份到 %s：结构版本 %d，%d 个目标，%d 条结果，耗时 %s\n",
+		args[0], info.Version, info.Targets, info.Results, time.Since(started).Round(time.Millisecond))
+	return nil
+}
+
+// runRestore 用备份替换数据库
+func runRestore(args []string, dbPath string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	yes := fs.Bool("yes", false, "确认替换数据库")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New(restoreUsage)
+	}
+	backup := fs.Arg(0)
+
+	info, err := database.InspectBackup(backup)
+	if err != nil {
+		return err
+	}
+	newest := "无"
+	if !info.Newest.IsZero() {
+		newest = info.Newest.Local().Format("2006-01-02 15:04")
+	}
+	fmt.Printf("备份 %s：结构版本 %d，%d 个目标，%d 条结果，最新结果 %s\n", backup, info.Version, info.Targets, info.Results, newest)
+	if !*yes {
+		return fmt.Errorf("将用该备份替换 %s，确认请添加 -yes", dbPath)
+	}
+
+	previous, err := database.Restore(backup, dbPath)
+	if err != nil {
+		return err
+	}
+	if previous != "" {
+		fmt.Printf("原数据库已保存为 %s\n", previous)
+	}
+	fmt.Printf("已恢复 %s，下次启动时自动应用未执行的迁移\n", dbPath)
+	return nil
+}
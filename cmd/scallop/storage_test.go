@@ -0,0 +1,75 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"scallop/internal/database"
+	"scallop/internal/models"
+)
+
+func TestRunBackfill(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "scallop.db")
+	db, err := database.New(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SyncTargets([]models.IPTarget{{ID: "a", Addr: "1.1.1.1"}}); err != nil {
+		t.Fatal(err)
+	}
+	// 三天前和一小时前各一条结果
+	now := time.Now()
+	for _, timestamp := range []time.Time{now.AddDate(0, 0, -3), now.Add(-time.Hour)} {
+		if err := db.SavePingResult(models.PingResult{TargetID: "a", Latency: 10, Success: true, Timestamp: timestamp}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	// 模拟升级前只有原始结果的数据库
+	conn, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec("DELETE FROM ping_rollups"); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	daily := func() int64 {
+		db, err := database.Open(dbPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		rollups, err := db.GetRollups("a", now.AddDate(0, 0, -5), now, 24*time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var count int64
+		for _, rollup := range rollups {
+			count += rollup.Count
+		}
+		return count
+	}
+
+	// -days只补充最近的结果
+	if err := runBackfill([]string{"-days", "1"}, dbPath); err != nil {
+		t.Fatal(err)
+	}
+	if count := daily(); count != 1 {
+		t.Fatalf("-days 1 补充了%d条结果，应为1条", count)
+	}
+	if err := runBackfill(nil, dbPath); err != nil {
+		t.Fatal(err)
+	}
+	if count := daily(); count != 2 {
+		t.Fatalf("补充了%d条结果，应为2条", count)
+	}
+
+	if err := runBackfill([]string{"-days", "x"}, dbPath); err == nil {
+		t.Fatal("无效参数应返回错误")
+	}
+}
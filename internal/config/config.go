@@ -1,20 +1,48 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
 	"sync"
-	"time"
 
 	"scallop/internal/models"
+	"scallop/internal/throughput"
 )
 
+// maxReloadHistory 保留的配置重载记录数量
+const maxReloadHistory = 20
+
+// restartRequired 无法在运行中生效、需要重启才能应用的配置项
+var restartRequired = map[string]bool{
+	"throughput_listen": true,
+	"throughput_secret": true,
+	"throughput_allow":  true,
+	"trusted_proxies":   true,
+	"writer":            true,
+	"storage":           true,
+}
+
+// targetIDPattern 显式目标ID的格式
+var targetIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// Applier 在运行中应用配置变化，返回错误表示变化未能生效、原设置继续有效
+type Applier func(old, new models.Config) error
+
 // Manager 配置管理器
 type Manager struct {
-	config      models.Config
-	mutex       sync.RWMutex
-	configPath  string
-	lastModTime time.Time
+	config     models.Config
+	mutex      sync.RWMutex
+	configPath string
+	reloads    []models.ReloadResult // 最近的重载记录，最新的在前
+	appliers   []Applier
 }
 
 // NewManager 创建配置管理器
@@ -24,31 +52,47 @@ func NewManager(configPath string) *Manager {
 	}
 }
 
-// Load 加载配置
+// Load 加载配置，配置文件不存在时创建默认配置，旧格式的配置会被转换并写回
 func (m *Manager) Load() error {
-	// 记录文件修改时间
-	if stat, err := os.Stat(m.configPath); err == nil {
-		m.lastModTime = stat.ModTime()
-	}
-
 	// 检查配置文件是否存在
 	if _, err := os.Stat(m.configPath); os.IsNotExist(err) {
 		return m.createDefaultConfig()
 	}
 
-	// 读取配置文件
-	data, err := os.ReadFile(m.configPath)
+	config, legacy, err := m.parse()
 	if err != nil {
 		return err
 	}
+	if legacy {
+		// 保存新格式的配置
+		data, _ := json.MarshalIndent(config, "", "  ")
+		os.WriteFile(m.configPath, data, 0644)
+	}
+
+	return m.Apply(config)
+}
+
+// Parse 读取并校验配置文件，不修改当前配置；校验失败时返回错误
+func (m *Manager) Parse() (models.Config, error) {
+	config, _, err := m.parse()
+	return config, err
+}
+
+// parse 读取配置文件，兼容只有targets数组的旧格式，返回的legacy表示是否为旧格式
+func (m *Manager) parse() (models.Config, bool, error) {
+	data, err := os.ReadFile(m.configPath)
+	if err != nil {
+		return models.Config{}, false, err
+	}
 
 	// 尝试解析新格式的配置
 	var config models.Config
+	legacy := false
 	if err := json.Unmarshal(data, &config); err != nil {
 		// 如果解析失败，尝试解析旧格式（只有targets数组）
 		var targets []models.IPTarget
-		if err := json.Unmarshal(data, &targets); err != nil {
-			return err
+		if json.Unmarshal(data, &targets) != nil {
+			return models.Config{}, false, fmt.Errorf("解析配置文件失败: %v", err)
 		}
 		// 转换为新格式
 		config = models.Config{
@@ -58,18 +102,83 @@ func (m *Manager) Load() error {
 			WebPort:      8081,
 			DefaultDNS:   "",
 		}
-		// 保存新格式的配置
-		data, _ := json.MarshalIndent(config, "", "  ")
-		os.WriteFile(m.configPath, data, 0644)
+		legacy = true
 	}
 
 	// 验证配置值
 	m.validateConfig(&config)
+	if err := checkConfig(&config); err != nil {
+		return models.Config{}, false, err
+	}
+	return config, legacy, nil
+}
 
+// Apply 替换当前配置，并依次调用已注册的applier使变化在运行中生效
+// 返回的错误汇总了未能生效的变化，此时新配置仍然被采用
+func (m *Manager) Apply(config models.Config) error {
 	m.mutex.Lock()
+	old := m.config
 	m.config = config
+	appliers := append([]Applier{}, m.appliers...)
 	m.mutex.Unlock()
-	return nil
+
+	var errs []error
+	for _, apply := range appliers {
+		if err := apply(old, config); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// OnChange 注册applier，此后每次Apply都会调用
+func (m *Manager) OnChange(apply Applier) {
+	m.mutex.Lock()
+	m.appliers = append(m.appliers, apply)
+	m.mutex.Unlock()
+}
+
+// Changes 返回两份配置中取值不同的配置项名称（JSON字段名）
+func Changes(old, new models.Config) []string {
+	oldValue, newValue := reflect.ValueOf(old), reflect.ValueOf(new)
+	var changes []string
+	for i := 0; i < oldValue.NumField(); i++ {
+		if reflect.DeepEqual(oldValue.Field(i).Interface(), newValue.Field(i).Interface()) {
+			continue
+		}
+		name, _, _ := strings.Cut(oldValue.Type().Field(i).Tag.Get("json"), ",")
+		changes = append(changes, name)
+	}
+	return changes
+}
+
+// RestartRequired 从变化的配置项中挑出需要重启才能生效的
+func RestartRequired(changes []string) []string {
+	var names []string
+	for _, name := range changes {
+		if restartRequired[name] {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// RecordReload 记录一次配置重载的结果
+func (m *Manager) RecordReload(result models.ReloadResult) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.reloads = append([]models.ReloadResult{result}, m.reloads...)
+	if len(m.reloads) > maxReloadHistory {
+		m.reloads = m.reloads[:maxReloadHistory]
+	}
+}
+
+// ReloadHistory 获取最近的配置重载记录，最新的在前
+func (m *Manager) ReloadHistory() []models.ReloadResult {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return append([]models.ReloadResult{}, m.reloads...)
 }
 
 // createDefaultConfig 创建默认配置
@@ -112,6 +221,340 @@ func (m *Manager) validateConfig(config *models.Config) {
 	if config.WebPort <= 0 || config.WebPort > 65535 {
 		config.WebPort = 8081
 	}
+	if config.ShutdownTimeout <= 0 {
+		config.ShutdownTimeout = 30
+	}
+	if config.Retention != nil {
+		if config.Retention.PurgeInterval <= 0 {
+			config.Retention.PurgeInterval = 3600
+		}
+		if config.Retention.BatchSize <= 0 {
+			config.Retention.BatchSize = 1000
+		}
+	}
+	if config.Backup != nil {
+		if config.Backup.Interval <= 0 {
+			config.Backup.Interval = 24
+		}
+		if config.Backup.Keep <= 0 {
+			config.Backup.Keep = 7
+		}
+	}
+	for i := range config.Targets {
+		target := &config.Targets[i]
+		if target.ProbeType() == models.ProbeHTTP && target.HTTP == nil {
+			target.HTTP = &models.HTTPOptions{}
+		}
+		if target.HTTP != nil {
+			if target.HTTP.MaxBytes <= 0 {
+				target.HTTP.MaxBytes = 1 << 20
+			}
+			if target.HTTP.MaxBytes > 100<<20 {
+				target.HTTP.MaxBytes = 100 << 20
+			}
+			if target.HTTP.Timeout <= 0 {
+				target.HTTP.Timeout = 30
+			}
+		}
+		if target.ProbeType() == models.ProbeTLS && target.TLS == nil {
+			target.TLS = &models.TLSOptions{}
+		}
+		if target.TLS != nil {
+			if target.TLS.WarnDays <= 0 {
+				target.TLS.WarnDays = 30
+			}
+			if target.TLS.CriticalDays <= 0 {
+				target.TLS.CriticalDays = 7
+			}
+		}
+		if target.ProbeType() == models.ProbeHeartbeat && target.Heartbeat == nil {
+			target.Heartbeat = &models.HeartbeatOptions{}
+		}
+		if target.Heartbeat != nil && target.Heartbeat.Grace <= 0 {
+			target.Heartbeat.Grace = 600
+		}
+		if target.Exec != nil && target.Exec.Timeout <= 0 {
+			target.Exec.Timeout = 10
+		}
+		if target.Throughput != nil {
+			throughput.Normalize(target.Throughput)
+		}
+		if target.Proxy != nil && target.Proxy.Type == "" {
+			target.Proxy.Type = "socks5"
+		}
+	}
+}
+
+// checkConfig 检查无法自动修正的配置错误
+func checkConfig(config *models.Config) error {
+	for _, proxy := range config.TrustedProxies {
+		if _, err := netip.ParsePrefix(proxy); err != nil {
+			if _, err := netip.ParseAddr(proxy); err != nil {
+				return fmt.Errorf("trusted_proxies中的地址无效: %s", proxy)
+			}
+		}
+	}
+	if _, err := throughput.ParseSources(config.ThroughputAllow); err != nil {
+		return fmt.Errorf("throughput_allow中的%v", err)
+	}
+	if writer := config.Writer; writer != nil {
+		if writer.QueueSize < 0 || writer.BatchSize < 0 || writer.FlushInterval < 0 {
+			return fmt.Errorf("writer的参数不能为负数")
+		}
+		if writer.FlushInterval > 60000 {
+			return fmt.Errorf("writer.flush_interval不能大于60000毫秒")
+		}
+	}
+	if backup := config.Backup; backup != nil {
+		if backup.Dir == "" {
+			return fmt.Errorf("backup.dir不能为空")
+		}
+	}
+	if storage := config.Storage; storage != nil {
+		switch storage.Backend {
+		case "", "sqlite", "postgres", "memory", "segment":
+		default:
+			return fmt.Errorf("未知的存储类型: %s（可选sqlite、postgres、memory、segment）", storage.Backend)
+		}
+		if segment := storage.Segment; segment != nil {
+			if segment.ChunkPoints < 0 || segment.ChunkDuration < 0 || segment.FlushInterval < 0 || segment.CompactInterval < 0 {
+				return fmt.Errorf("storage.segment的参数不能为负数")
+			}
+		}
+	}
+	if retention := config.Retention; retention != nil {
+		if retention.RawDays < 0 {
+			return fmt.Errorf("retention.raw_days不能为负数")
+		}
+		if retention.PurgeInterval < 60 {
+			return fmt.Errorf("retention.purge_interval不能小于60秒")
+		}
+		if retention.BatchSize > 100000 {
+			return fmt.Errorf("retention.batch_size不能大于100000")
+		}
+		for name, days := range retention.RollupDays {
+			if name != "1m" && name != "1h" && name != "1d" {
+				return fmt.Errorf("retention.rollup_days的层级未知: %s（可选1m、1h、1d）", name)
+			}
+			if days < 0 {
+				return fmt.Errorf("retention.rollup_days.%s不能为负数", name)
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	ids := make(map[string]bool)
+	for _, target := range config.Targets {
+		if target.ID != "" {
+			ids[target.ID] = true
+		}
+	}
+
+	previous := make(map[string]bool)
+	for i, target := range config.Targets {
+		name := fmt.Sprintf("第%d个目标", i+1)
+		if target.Addr == "" {
+			return fmt.Errorf("%s缺少addr", name)
+		}
+		name = fmt.Sprintf("目标 %s", target.Addr)
+
+		switch target.ProbeType() {
+		case models.ProbeICMP, models.ProbeTCP, models.ProbeHTTP, models.ProbeTLS, models.ProbeNTP,
+			models.ProbeExec, models.ProbeHeartbeat, models.ProbeHappyEyeballs:
+		default:
+			return fmt.Errorf("%s的探测类型未知: %s", name, target.Type)
+		}
+		if target.ProbeType() == models.ProbeExec && (target.Exec == nil || target.Exec.Command == "") {
+			return fmt.Errorf("%s缺少exec.command", name)
+		}
+		if target.RetentionDays < -1 {
+			return fmt.Errorf("%s的retention_days无效: %d（-1表示永久保留）", name, target.RetentionDays)
+		}
+		if target.Proxy != nil {
+			if target.Proxy.Addr == "" {
+				return fmt.Errorf("%s缺少proxy.addr", name)
+			}
+			if target.Proxy.Type != "socks5" && target.Proxy.Type != "http" {
+				return fmt.Errorf("%s的代理类型未知: %s", name, target.Proxy.Type)
+			}
+		}
+
+		// 未配置id时，与生成目标ID相同的字段组合视为同一目标
+		key := fmt.Sprintf("%s|%s|%v|%s", target.Addr, target.Description, target.HideAddr, target.DNSServer)
+		if target.ID != "" {
+			if !targetIDPattern.MatchString(target.ID) {
+				return fmt.Errorf("%s的id无效: %s（只能包含字母、数字、下划线、点和连字符，最长64个字符）", name, target.ID)
+			}
+			key = "id:" + target.ID
+		}
+		if seen[key] {
+			return fmt.Errorf("%s重复配置", name)
+		}
+		seen[key] = true
+
+		for _, id := range target.PreviousIDs {
+			if id == "" || id == target.ID {
+				return fmt.Errorf("%s的previous_ids包含无效的ID: %q", name, id)
+			}
+			if ids[id] {
+				return fmt.Errorf("%s的previous_ids中的%s仍是其他目标的id", name, id)
+			}
+			if previous[id] {
+				return fmt.Errorf("%s的previous_ids中的%s已被其他目标使用", name, id)
+			}
+			previous[id] = true
+		}
+	}
+	return nil
+}
+
+// AddTarget 将目标追加到配置文件，写入后由配置文件监视触发重新加载
+// 只修改targets数组，其余内容（包括程序不认识的配置项和原有格式）保持不变；
+// 追加后的配置无法通过校验（如与现有目标重复）时不修改文件
+func (m *Manager) AddTarget(target models.IPTarget) error {
+	data, err := os.ReadFile(m.configPath)
+	if err != nil {
+		return err
+	}
+	output, err := appendTarget(data, target)
+	if err != nil {
+		return err
+	}
+
+	// 在副本上校验，validateConfig会填充默认值，不应写入文件
+	var check models.Config
+	if err := json.Unmarshal(output, &check); err != nil {
+		return err
+	}
+	m.validateConfig(&check)
+	if err := checkConfig(&check); err != nil {
+		return err
+	}
+	return writeFileAtomic(m.configPath, output)
+}
+
+// appendTarget 在配置文件内容的targets数组末尾插入目标，沿用数组原有的缩进
+func appendTarget(data []byte, target models.IPTarget) ([]byte, error) {
+	start, end, err := findTargets(data)
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 {
+		return nil, errors.New("配置文件中没有targets")
+	}
+	array := data[start:end]
+
+	// 右括号与targets所在行对齐，元素沿用第一个元素的缩进，数组为空时多缩进一级
+	var inner []byte
+	if array[0] == '[' {
+		inner = bytes.TrimSpace(array[1 : len(array)-1])
+	}
+	closing := lineIndent(data, start)
+	indent := closing + "  "
+	if len(inner) > 0 {
+		indent = lineIndent(data, start+1+bytes.Index(array[1:], inner[:1]))
+	}
+	unit := strings.TrimPrefix(indent, closing)
+	if unit == "" || unit == indent {
+		unit = "  "
+	}
+	multiline := len(inner) == 0 || bytes.ContainsRune(array, '\n')
+
+	var element []byte
+	if multiline {
+		element, err = json.MarshalIndent(target, indent, unit)
+	} else {
+		element, err = json.Marshal(target)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(data[:start])
+	buf.WriteByte('[')
+	switch {
+	case len(inner) == 0:
+		buf.WriteString("\n" + indent)
+		buf.Write(element)
+		buf.WriteString("\n" + closing)
+	case multiline:
+		last := start + 1 + bytes.LastIndex(array[1:], inner[len(inner)-1:]) + 1
+		buf.Write(data[start+1 : last])
+		buf.WriteString(",\n" + indent)
+		buf.Write(element)
+		buf.WriteString("\n" + closing)
+	default:
+		buf.Write(inner)
+		buf.WriteString(", ")
+		buf.Write(element)
+	}
+	buf.WriteByte(']')
+	buf.Write(data[end:])
+	return buf.Bytes(), nil
+}
+
+// findTargets 返回顶层targets的值在data中的起止位置，没有该项时start为-1
+func findTargets(data []byte) (start, end int, err error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return 0, 0, errors.New("解析配置文件失败: 顶层不是JSON对象")
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return 0, 0, fmt.Errorf("解析配置文件失败: %v", err)
+		}
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return 0, 0, fmt.Errorf("解析配置文件失败: %v", err)
+		}
+		if token != "targets" {
+			continue
+		}
+		end := int(decoder.InputOffset())
+		start := end - len(value)
+		if value[0] != '[' && string(value) != "null" {
+			return 0, 0, errors.New("配置文件中的targets不是数组")
+		}
+		return start, end, nil
+	}
+	return -1, -1, nil
+}
+
+// lineIndent 返回位置pos所在行行首的空白
+func lineIndent(data []byte, pos int) string {
+	lineStart := bytes.LastIndexByte(data[:pos], '\n') + 1
+	line := data[lineStart:pos]
+	return string(line[:len(line)-len(bytes.TrimLeft(line, " \t"))])
+}
+
+// writeFileAtomic 先写入同目录下的临时文件再重命名，避免读取到写了一半的配置
+// 新文件沿用原文件的权限，原文件不存在时为0644
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
 }
 
 // Get 获取配置
@@ -121,11 +564,6 @@ func (m *Manager) Get() models.Config {
 	return m.config
 }
 
-// GetLastModTime 获取最后修改时间
-func (m *Manager) GetLastModTime() time.Time {
-	return m.lastModTime
-}
-
 // GetConfigPath 获取配置文件路径
 func (m *Manager) GetConfigPath() string {
 	return m.configPath
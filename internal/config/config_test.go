@@ -0,0 +1,123 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"scallop/internal/models"
+)
+
+func TestAppendTarget(t *testing.T) {
+	target := models.IPTarget{Addr: "9.9.9.9", Description: "Quad9"}
+	tests := []struct {
+		name, input, want string
+	}{
+		{
+			name: "保留其他配置项和格式",
+			input: `{
+  "web_port": 8081,
+  "custom": {"keep": true},
+  "targets": [
+    {
+      "addr": "1.1.1.1",
+      "description": "Cloudflare"
+    }
+  ],
+  "ping_interval": 300
+}
+`,
+			want: `{
+  "web_port": 8081,
+  "custom": {"keep": true},
+  "targets": [
+    {
+      "addr": "1.1.1.1",
+      "description": "Cloudflare"
+    },
+    {
+      "addr": "9.9.9.9",
+      "description": "Quad9"
+    }
+  ],
+  "ping_interval": 300
+}
+`,
+		},
+		{
+			name: "空数组",
+			input: `{
+	"targets": [],
+	"web_port": 8081
+}`,
+			want: `{
+	"targets": [
+	  {
+	    "addr": "9.9.9.9",
+	    "description": "Quad9"
+	  }
+	],
+	"web_port": 8081
+}`,
+		},
+		{
+			name:  "单行数组",
+			input: `{"targets": [{"addr": "1.1.1.1", "description": "Cloudflare"}], "web_port": 8081}`,
+			want:  `{"targets": [{"addr": "1.1.1.1", "description": "Cloudflare"}, {"addr":"9.9.9.9","description":"Quad9"}], "web_port": 8081}`,
+		},
+		{
+			name:  "键中包含targets的嵌套对象",
+			input: "{\"extra\": {\"targets\": 1},\n \"targets\": null}",
+			want:  "{\"extra\": {\"targets\": 1},\n \"targets\": [\n   {\n     \"addr\": \"9.9.9.9\",\n     \"description\": \"Quad9\"\n   }\n ]}",
+		},
+	}
+	for _, test := range tests {
+		got, err := appendTarget([]byte(test.input), target)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("%s:\n得到\n%s\n应为\n%s", test.name, got, test.want)
+		}
+		if !json.Valid(got) {
+			t.Errorf("%s: 结果不是有效的JSON", test.name)
+		}
+	}
+
+	for _, input := range []string{`[]`, `{"web_port": 8081}`, `{"targets": {}}`} {
+		if _, err := appendTarget([]byte(input), target); err == nil {
+			t.Errorf("%s: 应返回错误", input)
+		}
+	}
+}
+
+func TestAddTargetKeepsFileMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	input := `{"targets": [{"addr": "1.1.1.1", "description": "Cloudflare"}], "web_port": 8081, "unknown": 1}`
+	if err := os.WriteFile(path, []byte(input), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewManager(path)
+	if err := m.AddTarget(models.IPTarget{Addr: "9.9.9.9", Description: "Quad9"}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("文件权限为%v，应保持0600", info.Mode().Perm())
+	}
+
+	// 与现有目标重复时不修改文件
+	data, _ := os.ReadFile(path)
+	if err := m.AddTarget(models.IPTarget{Addr: "9.9.9.9", Description: "Quad9"}); err == nil {
+		t.Fatal("添加重复目标应返回错误")
+	}
+	if after, _ := os.ReadFile(path); string(after) != string(data) {
+		t.Fatal("添加失败时修改了配置文件")
+	}
+}
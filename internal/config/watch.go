@@ -0,0 +1,92 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	// debounceDelay 配置文件变化后等待的静默时间，合并编辑器保存时产生的连续事件
+	debounceDelay = 500 * time.Millisecond
+	// pollInterval 无法使用文件系统通知时检查配置文件的间隔
+	pollInterval = 2 * time.Second
+)
+
+// Watch 监视配置文件所在目录，配置文件被写入或替换（包括编辑器的原子重命名）后发出通知
+// 连续的变化在静默debounceDelay后合并为一次通知；ctx取消后关闭返回的通道
+func (m *Manager) Watch(ctx context.Context) <-chan struct{} {
+	raw := make(chan struct{}, 1)
+	go func() {
+		if err := watchFile(ctx, m.configPath, raw); err != nil && ctx.Err() == nil {
+			fmt.Printf("无法监视配置目录，改为定期检查: %v\n", err)
+			pollFile(ctx, m.configPath, raw)
+		}
+	}()
+
+	changes := make(chan struct{}, 1)
+	go debounce(ctx, raw, changes)
+	return changes
+}
+
+// debounce 收到变化后等待静默debounceDelay再发出一次通知
+func debounce(ctx context.Context, in <-chan struct{}, out chan<- struct{}) {
+	defer close(out)
+
+	timer := time.NewTimer(debounceDelay)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-in:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(debounceDelay)
+		case <-timer.C:
+			notify(out)
+		}
+	}
+}
+
+// pollFile 定期比较配置文件的修改时间和大小
+func pollFile(ctx context.Context, path string, changed chan<- struct{}) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	var lastMod time.Time
+	var lastSize int64
+	if stat, err := os.Stat(path); err == nil {
+		lastMod, lastSize = stat.ModTime(), stat.Size()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		stat, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if !stat.ModTime().Equal(lastMod) || stat.Size() != lastSize {
+			lastMod, lastSize = stat.ModTime(), stat.Size()
+			notify(changed)
+		}
+	}
+}
+
+// notify 非阻塞地发送通知，已有未处理的通知时直接丢弃
+func notify(ch chan<- struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
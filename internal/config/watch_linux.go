@@ -0,0 +1,67 @@
+//go:build linux
+
+package config
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// watchFile 使用inotify监视配置文件所在目录
+// 监视目录而非文件本身，这样编辑器先写临时文件再重命名覆盖时也能收到通知；
+// 以".."开头的名称对应Kubernetes ConfigMap挂载时通过符号链接切换的数据目录
+func watchFile(ctx context.Context, path string, changed chan<- struct{}) error {
+	dir, name := filepath.Dir(path), filepath.Base(path)
+
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	mask := uint32(unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF)
+	if _, err := unix.InotifyAddWatch(fd, dir, mask); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 64*1024)
+	for ctx.Err() == nil {
+		// 带超时的poll，以便及时响应ctx取消
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+		n, err := unix.Poll(fds, 500)
+		if err == unix.EINTR || n == 0 {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		n, err = unix.Read(fd, buf)
+		if err == unix.EAGAIN || err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + unix.SizeofInotifyEvent
+			offset = nameStart + int(event.Len)
+			if event.Mask&(unix.IN_DELETE_SELF|unix.IN_MOVE_SELF) != 0 {
+				return errors.New("配置目录已被删除或移动")
+			}
+
+			eventName := strings.TrimRight(string(buf[nameStart:offset]), "\x00")
+			if eventName == name || strings.HasPrefix(eventName, "..") {
+				notify(changed)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package config
+
+import "context"
+
+// watchFile 非Linux平台定期检查配置文件
+func watchFile(ctx context.Context, path string, changed chan<- struct{}) error {
+	pollFile(ctx, path, changed)
+	return nil
+}
@@ -0,0 +1,181 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ErrBackupUnsupported 当前存储不支持在线备份
+var ErrBackupUnsupported = errors.New("当前存储不支持在线备份")
+
+// Backuper 支持在线备份的存储
+type Backuper interface {
+	Backup(ctx context.Context, path string) error
+}
+
+var _ Backuper = (*DB)(nil)
+
+// sqliteHeader SQLite数据库文件开头的魔数
+const sqliteHeader = "SQLite format 3\x00"
+
+// Backup 在服务运行时生成数据库的一致快照（VACUUM INTO），期间探测结果照常写入
+// 先写入临时文件再改名，中途失败不会留下不完整的备份；path已存在时返回错误
+func (db *DB) Backup(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("备份文件 %s 已存在", path)
+	}
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	if _, err := db.conn.ExecContext(ctx, "VACUUM INTO ?", tmp); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("备份数据库失败: %v", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// Backup 块文件不在SQLite数据库中，需要停止服务后复制整个数据目录
+func (s *SegmentStore) Backup(ctx context.Context, path string) error {
+	return ErrBackupUnsupported
+}
+
+// BackupInfo 备份文件的结构版本和内容
+type BackupInfo struct {
+	Version int       // 数据库结构版本
+	Targets int       // 目标数量
+	Results int64     // Ping结果数量
+	Newest  time.Time // 最新的Ping结果时间
+}
+
+// InspectBackup 检查备份文件是否为完整的Scallop数据库，且结构版本不高于程序支持的版本
+func InspectBackup(path string) (BackupInfo, error) {
+	var info BackupInfo
+	file, err := os.Open(path)
+	if err != nil {
+		return info, err
+	}
+	header := make([]byte, len(sqliteHeader))
+	_, err = io.ReadFull(file, header)
+	file.Close()
+	if err != nil || string(header) != sqliteHeader {
+		return info, fmt.Errorf("%s 不是SQLite数据库", path)
+	}
+
+	// 只读打开，不会修改备份文件
+	conn, err := sql.Open("sqlite", "file:"+path+"?mode=ro&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return info, err
+	}
+	defer conn.Close()
+
+	var check string
+	if err := conn.QueryRow("PRAGMA quick_check").Scan(&check); err != nil {
+		return info, fmt.Errorf("检查备份完整性失败: %v", err)
+	}
+	if check != "ok" {
+		return info, fmt.Errorf("备份文件已损坏: %s", check)
+	}
+
+	migrations, err := Migrations()
+	if err != nil {
+		return info, err
+	}
+	if info.Version, err = schemaVersion(conn); err != nil {
+		return info, fmt.Errorf("读取结构版本失败: %v", err)
+	}
+	if info.Version == 0 {
+		return info, errors.New("备份中没有结构版本记录，不是Scallop数据库")
+	}
+	if info.Version > len(migrations) {
+		return info, fmt.Errorf("备份的结构版本(%d)高于当前程序支持的版本(%d)，请升级Scallop后再恢复", info.Version, len(migrations))
+	}
+
+	if err := conn.QueryRow("SELECT COUNT(*) FROM targets").Scan(&info.Targets); err != nil {
+		return info, err
+	}
+	if err := conn.QueryRow("SELECT COUNT(*) FROM ping_results").Scan(&info.Results); err != nil {
+		return info, err
+	}
+	// MAX会丢失列的时间类型，改为按时间排序取第一条
+	err = conn.QueryRow("SELECT timestamp FROM ping_results ORDER BY timestamp DESC LIMIT 1").Scan(&info.Newest)
+	if err != nil && err != sql.ErrNoRows {
+		return info, err
+	}
+	return info, nil
+}
+
+// Restore 用备份替换dbPath处的数据库，返回原数据库改名后的路径（原数据库不存在时为空）
+// 备份先复制到数据库所在目录再改名替换；原数据库先合并WAL再改名保留，仍被其他进程使用时拒绝恢复
+func Restore(backup, dbPath string) (string, error) {
+	if _, err := InspectBackup(backup); err != nil {
+		return "", err
+	}
+
+	tmp := dbPath + ".restore.tmp"
+	if err := copyFile(backup, tmp); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("复制备份失败: %v", err)
+	}
+
+	var previous string
+	if _, err := os.Stat(dbPath); err == nil {
+		if err := checkpoint(dbPath); err != nil {
+			os.Remove(tmp)
+			return "", err
+		}
+		previous = dbPath + ".before-restore-" + time.Now().Format("20060102-150405")
+		if err := os.Rename(dbPath, previous); err != nil {
+			os.Remove(tmp)
+			return "", err
+		}
+	}
+	if err := os.Rename(tmp, dbPath); err != nil {
+		return previous, err
+	}
+	return previous, nil
+}
+
+// checkpoint 将WAL中的内容合并到数据库文件，之后数据库文件可以单独移动
+// 其他进程仍打开数据库时WAL不会被删除，此时返回错误
+func checkpoint(dbPath string) error {
+	db, err := Open(dbPath)
+	if err != nil {
+		return err
+	}
+	_, err = db.conn.Exec("PRAGMA wal_checkpoint(TRUNCATE)")
+	db.Close()
+	if err != nil {
+		return fmt.Errorf("合并WAL失败: %v", err)
+	}
+	if _, err := os.Stat(dbPath + "-wal"); err == nil {
+		return fmt.Errorf("数据库 %s 正在被使用，请先停止Scallop服务", dbPath)
+	}
+	return nil
+}
+
+// copyFile 复制文件并同步到磁盘
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,225 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"scallop/internal/models"
+)
+
+// countResults 只读打开数据库文件并统计Ping结果数量
+func countResults(t *testing.T, path string) int64 {
+	t.Helper()
+	info, err := InspectBackup(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Results
+}
+
+func TestBackupWhileWriting(t *testing.T) {
+	dir := t.TempDir()
+	db, err := New(filepath.Join(dir, "scallop.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.SyncTargets([]models.IPTarget{{ID: "a", Addr: "1.1.1.1"}}); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().Add(-time.Hour).Truncate(time.Minute)
+	saveResults(t, db, "a", start, 1)
+	db.StartWriter(&models.Writer{QueueSize: 100, FlushInterval: 10, BatchSize: 50})
+
+	// 备份期间探测结果持续写入
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ctx.Err() == nil; i++ {
+			db.SavePingResult(models.PingResult{TargetID: "a", Latency: 10, Success: true, Timestamp: start.Add(time.Minute + time.Duration(i)*time.Millisecond)})
+			if i%100 == 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+
+	backups := []string{filepath.Join(dir, "backup-1.db"), filepath.Join(dir, "backup-2.db")}
+	var written int64
+	for _, path := range backups {
+		// 等到上次备份之后写入队列又提交了一批结果
+		deadline := time.Now().Add(5 * time.Second)
+		for db.writer.snapshot().Written == written && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if err := db.Backup(context.Background(), path); err != nil {
+			t.Fatal(err)
+		}
+		written = db.writer.snapshot().Written
+	}
+	cancel()
+	wg.Wait()
+
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := int64(6)
+	for _, path := range backups {
+		info, err := InspectBackup(path)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if info.Version != len(migrations) || info.Targets != 1 || info.Results <= previous || info.Newest.IsZero() {
+			t.Fatalf("%s: 备份内容不正确: %+v", path, info)
+		}
+		previous = info.Results
+	}
+	if _, err := os.Stat(backups[0] + ".tmp"); !os.IsNotExist(err) {
+		t.Fatal("备份完成后留下了临时文件")
+	}
+
+	// 不覆盖已有的文件
+	if err := db.Backup(context.Background(), backups[0]); err == nil || !strings.Contains(err.Error(), "已存在") {
+		t.Fatalf("备份到已有文件时返回%v", err)
+	}
+}
+
+func TestInspectBackupRejects(t *testing.T) {
+	dir := t.TempDir()
+	db, err := New(filepath.Join(dir, "scallop.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup := filepath.Join(dir, "backup.db")
+	if err := db.Backup(context.Background(), backup); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 由备份复制出一个文件并执行SQL修改
+	variant := func(name, statement string) string {
+		path := filepath.Join(dir, name)
+		if err := copyFile(backup, path); err != nil {
+			t.Fatal(err)
+		}
+		conn, err := sql.Open("sqlite", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if _, err := conn.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	newer := variant("newer.db", "INSERT INTO schema_version (version, name, applied_at) VALUES (1000, 'future', CURRENT_TIMESTAMP)")
+	unversioned := variant("unversioned.db", "DROP TABLE schema_version")
+
+	plain := filepath.Join(dir, "plain.db")
+	conn, err := sql.Open("sqlite", plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec("CREATE TABLE notes (text TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	text := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(text, []byte(`{"targets": []}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	truncated := filepath.Join(dir, "truncated.db")
+	if err := os.WriteFile(truncated, []byte(sqliteHeader[:8]), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path    string
+		message string
+	}{
+		{newer, "高于当前程序支持的版本"},
+		{unversioned, "没有结构版本记录"},
+		{plain, "没有结构版本记录"},
+		{text, "不是SQLite数据库"},
+		{truncated, "不是SQLite数据库"},
+		{filepath.Join(dir, "missing.db"), "no such file"},
+	}
+	for _, test := range tests {
+		if _, err := InspectBackup(test.path); err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("%s: 错误为%v，应包含%q", filepath.Base(test.path), err, test.message)
+		}
+	}
+	if info, err := InspectBackup(backup); err != nil || info.Version != len(migrations) {
+		t.Fatalf("检查有效备份失败: %+v %v", info, err)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "scallop.db")
+	db, err := New(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SyncTargets([]models.IPTarget{{ID: "a", Addr: "1.1.1.1"}}); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().Add(-time.Hour).Truncate(time.Minute)
+	saveResults(t, db, "a", start, 1)
+	backup := filepath.Join(dir, "backup.db")
+	if err := db.Backup(context.Background(), backup); err != nil {
+		t.Fatal(err)
+	}
+	saveResults(t, db, "a", start.Add(time.Minute), 1)
+
+	// 数据库仍被使用时拒绝恢复
+	if _, err := Restore(backup, dbPath); err == nil || !strings.Contains(err.Error(), "正在被使用") {
+		t.Fatalf("数据库使用中时恢复返回%v", err)
+	}
+	db.Close()
+
+	previous, err := Restore(backup, dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(previous, dbPath+".before-restore-") {
+		t.Fatalf("原数据库改名为%s", previous)
+	}
+	if got := countResults(t, previous); got != 12 {
+		t.Fatalf("保留的原数据库有%d条结果，应为12条", got)
+	}
+	if got := countResults(t, dbPath); got != 6 {
+		t.Fatalf("恢复后有%d条结果，应为6条", got)
+	}
+	if _, err := os.Stat(dbPath + ".restore.tmp"); !os.IsNotExist(err) {
+		t.Fatal("恢复后留下了临时文件")
+	}
+
+	// 恢复后的数据库可以正常打开和写入
+	db, err = New(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.SavePingResult(models.PingResult{TargetID: "a", Latency: 1, Success: true, Timestamp: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 无效的备份不会替换数据库
+	if _, err := Restore(filepath.Join(dir, "missing.db"), dbPath); err == nil {
+		t.Fatal("备份不存在时应返回错误")
+	}
+}
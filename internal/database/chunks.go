@@ -0,0 +1,848 @@
+package database
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"scallop/internal/models"
+)
+
+const (
+	defaultChunkPoints     = 7200
+	defaultChunkDuration   = 2 * time.Hour
+	defaultSegmentFlush    = time.Minute
+	defaultCompactInterval = time.Hour
+)
+
+// 每个序列一个目录，Ping结果的目录为ping，附加指标的目录为m-加上十六进制编码的指标名称；
+// 写满的块保存为<最早时间>-<最晚时间>-<序号>.seg，未写满的块定期保存为head.seg
+const (
+	pingSeries = "ping"
+	headFile   = "head.seg"
+)
+
+// seriesKey 时间序列：一个目标的Ping结果或一个附加指标
+type seriesKey struct {
+	target string
+	metric string // 附加指标名称，Ping结果为空
+}
+
+// dir 序列的目录
+func (k seriesKey) dir(root string) string {
+	name := pingSeries
+	if k.metric != "" {
+		name = "m-" + hex.EncodeToString([]byte(k.metric))
+	}
+	return filepath.Join(root, k.target, name)
+}
+
+// parseSeriesDir 由目录名还原序列
+func parseSeriesDir(target, name string) (seriesKey, bool) {
+	if name == pingSeries {
+		return seriesKey{target: target}, true
+	}
+	if encoded, ok := strings.CutPrefix(name, "m-"); ok {
+		if metric, err := hex.DecodeString(encoded); err == nil && len(metric) > 0 {
+			return seriesKey{target: target, metric: string(metric)}, true
+		}
+	}
+	return seriesKey{}, false
+}
+
+// chunkFile 磁盘上一个写满的块，块的时间范围同时作为范围查询的索引
+type chunkFile struct {
+	path     string
+	count    int
+	min, max int64
+	size     int64
+}
+
+// series 一个时间序列的块
+type series struct {
+	chunks []chunkFile   // 按最早时间排序
+	head   *chunkEncoder // 正在写入的块，为nil表示尚无数据
+	dirty  bool          // 正在写入的块在上次保存后有新数据
+}
+
+// chunkStore 按序列保存压缩块的存储引擎
+// 新数据先写入内存中正在写入的块，写满数据点数或覆盖的时间后保存为块文件；
+// 块文件只会被整个替换，合并小块、清理过期数据时先写入新块再删除旧块
+type chunkStore struct {
+	root            string
+	chunkPoints     int
+	chunkSpan       int64 // 毫秒
+	flushInterval   time.Duration
+	compactInterval time.Duration
+
+	mutex  sync.RWMutex // 保护series、各序列的块列表和seq
+	series map[seriesKey]*series
+	seq    int64
+
+	files       sync.RWMutex // 读取块文件时持有读锁，删除块文件时持有写锁，先于mutex获取
+	maintenance sync.Mutex   // 合并小块、清理过期数据、合并和删除目标依次执行
+
+	statsMutex     sync.Mutex
+	lastCompaction *time.Time
+	compacted      int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// openChunkStore 打开块存储目录，加载块索引和上次保存的未写满的块，并启动后台保存和合并
+func openChunkStore(root string, options *models.Segment) (*chunkStore, error) {
+	c := &chunkStore{
+		root:            root,
+		chunkPoints:     defaultChunkPoints,
+		chunkSpan:       defaultChunkDuration.Milliseconds(),
+		flushInterval:   defaultSegmentFlush,
+		compactInterval: defaultCompactInterval,
+		series:          make(map[seriesKey]*series),
+		stop:            make(chan struct{}),
+		done:            make(chan struct{}),
+	}
+	if options != nil {
+		if options.ChunkPoints > 0 {
+			c.chunkPoints = options.ChunkPoints
+		}
+		if options.ChunkDuration > 0 {
+			c.chunkSpan = int64(options.ChunkDuration) * 1000
+		}
+		if options.FlushInterval > 0 {
+			c.flushInterval = time.Duration(options.FlushInterval) * time.Second
+		}
+		if options.CompactInterval > 0 {
+			c.compactInterval = time.Duration(options.CompactInterval) * time.Second
+		}
+	}
+
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+
+	go c.run()
+	return c, nil
+}
+
+// load 扫描目录，只读取块文件头建立索引
+func (c *chunkStore) load() error {
+	targets, err := os.ReadDir(c.root)
+	if err != nil {
+		return err
+	}
+	for _, target := range targets {
+		if !target.IsDir() {
+			continue
+		}
+		dirs, err := os.ReadDir(filepath.Join(c.root, target.Name()))
+		if err != nil {
+			return err
+		}
+		for _, dir := range dirs {
+			key, ok := parseSeriesDir(target.Name(), dir.Name())
+			if !dir.IsDir() || !ok {
+				continue
+			}
+			if err := c.loadSeries(key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadSeries 加载一个序列的块索引和未写满的块
+func (c *chunkStore) loadSeries(key seriesKey) error {
+	dir := key.dir(c.root)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	s := &series{}
+	var head []point
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		switch {
+		case strings.HasSuffix(entry.Name(), ".tmp"):
+			// 写入到一半时退出留下的临时文件
+			os.Remove(path)
+		case entry.Name() == headFile:
+			data, err := os.ReadFile(path)
+			if err == nil {
+				head, err = decodeChunk(data)
+			}
+			if err != nil {
+				fmt.Printf("未写满的块 %s 已损坏，已忽略: %v\n", path, err)
+				head = nil
+			}
+		case strings.HasSuffix(entry.Name(), ".seg"):
+			chunk, err := readChunkFile(path)
+			if err != nil {
+				fmt.Printf("块文件 %s 无效，已忽略: %v\n", path, err)
+				continue
+			}
+			s.chunks = append(s.chunks, chunk)
+			parts := strings.Split(strings.TrimSuffix(entry.Name(), ".seg"), "-")
+			if seq, err := strconv.ParseInt(parts[len(parts)-1], 10, 64); err == nil && seq > c.seq {
+				c.seq = seq
+			}
+		}
+	}
+	sortChunks(s.chunks)
+
+	// 写满的块保存后、删除head.seg前退出时，两者包含相同的数据
+	if len(head) > 0 {
+		encoder := &chunkEncoder{}
+		for _, p := range head {
+			encoder.append(p)
+		}
+		duplicate := false
+		for _, chunk := range s.chunks {
+			if chunk.min == encoder.min && chunk.max >= encoder.max && chunk.count >= encoder.count {
+				duplicate = true
+			}
+		}
+		if !duplicate {
+			s.head = encoder
+		}
+	}
+
+	if len(s.chunks) > 0 || s.head != nil {
+		c.series[key] = s
+	}
+	return nil
+}
+
+// readChunkFile 读取块文件头
+func readChunkFile(path string) (chunkFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return chunkFile{}, err
+	}
+	defer file.Close()
+
+	buf := make([]byte, chunkHeaderSize)
+	if _, err := io.ReadFull(file, buf); err != nil {
+		return chunkFile{}, err
+	}
+	header, err := parseChunkHeader(buf)
+	if err != nil {
+		return chunkFile{}, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return chunkFile{}, err
+	}
+	return chunkFile{path: path, count: header.count, min: header.min, max: header.max, size: info.Size()}, nil
+}
+
+// readChunk 读取并解压块文件
+func readChunk(path string) ([]point, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	points, err := decodeChunk(data)
+	if err != nil {
+		return nil, fmt.Errorf("读取块文件%s失败: %v", path, err)
+	}
+	return points, nil
+}
+
+// sortChunks 按最早时间排序
+func sortChunks(chunks []chunkFile) {
+	sort.Slice(chunks, func(i, j int) bool {
+		if chunks[i].min != chunks[j].min {
+			return chunks[i].min < chunks[j].min
+		}
+		return chunks[i].path < chunks[j].path
+	})
+}
+
+// run 定期保存未写满的块并合并小块
+func (c *chunkStore) run() {
+	defer close(c.done)
+
+	flush := time.NewTicker(c.flushInterval)
+	defer flush.Stop()
+	compact := time.NewTicker(c.compactInterval)
+	defer compact.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-flush.C:
+			if err := c.flushHeads(); err != nil {
+				fmt.Printf("保存未写满的块失败: %v\n", err)
+			}
+		case <-compact.C:
+			if _, err := c.compact(); err != nil {
+				fmt.Printf("合并块失败: %v\n", err)
+			}
+		}
+	}
+}
+
+// close 停止后台任务，将未写满的块保存为块文件
+func (c *chunkStore) close() error {
+	close(c.stop)
+	<-c.done
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var errs []error
+	for key, s := range c.series {
+		if err := c.sealLocked(key, s); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// append 追加一个数据点，正在写入的块写满时先保存为块文件
+func (c *chunkStore) append(key seriesKey, p point) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	s, ok := c.series[key]
+	if !ok {
+		s = &series{}
+		c.series[key] = s
+	}
+	if s.head != nil && (s.head.count >= c.chunkPoints || p.t-s.head.min >= c.chunkSpan || s.head.min-p.t >= c.chunkSpan) {
+		if err := c.sealLocked(key, s); err != nil {
+			return err
+		}
+	}
+	if s.head == nil {
+		s.head = &chunkEncoder{}
+	}
+	s.head.append(p)
+	s.dirty = true
+	return nil
+}
+
+// sealLocked 将正在写入的块保存为块文件，调用方需持有mutex
+func (c *chunkStore) sealLocked(key seriesKey, s *series) error {
+	if s.head == nil {
+		return nil
+	}
+	chunk, err := c.writeChunk(key, s.head)
+	if err != nil {
+		return fmt.Errorf("保存块失败: %v", err)
+	}
+	s.chunks = append(s.chunks, chunk)
+	sortChunks(s.chunks)
+	s.head = nil
+	s.dirty = false
+
+	if err := os.Remove(filepath.Join(key.dir(c.root), headFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// writeChunk 将块写入序列目录中的新文件，先写临时文件再改名，不会留下写到一半的块
+func (c *chunkStore) writeChunk(key seriesKey, encoder *chunkEncoder) (chunkFile, error) {
+	dir := key.dir(c.root)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return chunkFile{}, err
+	}
+	c.seq++
+	path := filepath.Join(dir, fmt.Sprintf("%d-%d-%d.seg", encoder.min, encoder.max, c.seq))
+	data := encoder.encode()
+	if err := writeFileAtomic(path, data); err != nil {
+		return chunkFile{}, err
+	}
+	return chunkFile{path: path, count: encoder.count, min: encoder.min, max: encoder.max, size: int64(len(data))}, nil
+}
+
+// writeFileAtomic 先写临时文件再改名
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// flushHeads 保存有新数据的未写满的块，进程异常退出时最多丢失一个保存间隔内的数据
+func (c *chunkStore) flushHeads() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for key, s := range c.series {
+		if !s.dirty || s.head == nil {
+			continue
+		}
+		dir := key.dir(c.root)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(filepath.Join(dir, headFile), s.head.encode()); err != nil {
+			return err
+		}
+		s.dirty = false
+	}
+	return nil
+}
+
+// sources 序列中与时间范围重叠的块文件，以及正在写入的块的内容
+func (c *chunkStore) sources(key seriesKey, since, until int64) ([]chunkFile, []byte) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	s, ok := c.series[key]
+	if !ok {
+		return nil, nil
+	}
+	// 块按最早时间排序，最早时间晚于until的块不必检查
+	end := sort.Search(len(s.chunks), func(i int) bool { return s.chunks[i].min > until })
+	var files []chunkFile
+	for _, chunk := range s.chunks[:end] {
+		if chunk.max >= since {
+			files = append(files, chunk)
+		}
+	}
+	var head []byte
+	if s.head != nil && s.head.min <= until && s.head.max >= since {
+		head = s.head.encode()
+	}
+	return files, head
+}
+
+// query 查询序列在时间范围内的数据点，按时间排序
+func (c *chunkStore) query(key seriesKey, since, until int64) ([]point, error) {
+	c.files.RLock()
+	defer c.files.RUnlock()
+
+	files, head := c.sources(key, since, until)
+	var points []point
+	add := func(decoded []point) {
+		for _, p := range decoded {
+			if p.t >= since && p.t <= until {
+				points = append(points, p)
+			}
+		}
+	}
+	for _, file := range files {
+		decoded, err := readChunk(file.path)
+		if err != nil {
+			return nil, err
+		}
+		add(decoded)
+	}
+	if head != nil {
+		decoded, err := decodeChunk(head)
+		if err != nil {
+			return nil, err
+		}
+		add(decoded)
+	}
+
+	// 只有合并目标后尚未合并块时才会乱序
+	less := func(i, j int) bool { return points[i].t < points[j].t }
+	if !sort.SliceIsSorted(points, less) {
+		sort.SliceStable(points, less)
+	}
+	return points, nil
+}
+
+// last 序列中满足条件的最晚的数据点，从最新的块开始向前查找
+func (c *chunkStore) last(key seriesKey, match func(point) bool) (point, bool, error) {
+	c.files.RLock()
+	defer c.files.RUnlock()
+
+	files, head := c.sources(key, 0, 1<<62)
+	sort.Slice(files, func(i, j int) bool { return files[i].max > files[j].max })
+
+	var best point
+	found := false
+	check := func(decoded []point) {
+		for _, p := range decoded {
+			if match(p) && (!found || p.t >= best.t) {
+				best, found = p, true
+			}
+		}
+	}
+	if head != nil {
+		decoded, err := decodeChunk(head)
+		if err != nil {
+			return point{}, false, err
+		}
+		check(decoded)
+	}
+	for _, file := range files {
+		// 其余的块都早于已找到的数据点
+		if found && file.max < best.t {
+			break
+		}
+		decoded, err := readChunk(file.path)
+		if err != nil {
+			return point{}, false, err
+		}
+		check(decoded)
+	}
+	return best, found, nil
+}
+
+// span 序列的数据点数和最早、最晚的时间，只使用块索引
+func (c *chunkStore) span(key seriesKey) (count int, first, last int64) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	s, ok := c.series[key]
+	if !ok {
+		return 0, 0, 0
+	}
+	ranges := s.chunks
+	if s.head != nil {
+		ranges = append(ranges[:len(ranges):len(ranges)], chunkFile{count: s.head.count, min: s.head.min, max: s.head.max})
+	}
+	for i, chunk := range ranges {
+		if i == 0 || chunk.min < first {
+			first = chunk.min
+		}
+		if i == 0 || chunk.max > last {
+			last = chunk.max
+		}
+		count += chunk.count
+	}
+	return count, first, last
+}
+
+// keys 全部序列
+func (c *chunkStore) keys() []seriesKey {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	keys := make([]seriesKey, 0, len(c.series))
+	for key := range c.series {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].target != keys[j].target {
+			return keys[i].target < keys[j].target
+		}
+		return keys[i].metric < keys[j].metric
+	})
+	return keys
+}
+
+// replace 用新写入的块替换序列中的旧块，并删除旧块文件
+func (c *chunkStore) replace(key seriesKey, removed, added []chunkFile) error {
+	c.files.Lock()
+	defer c.files.Unlock()
+
+	c.mutex.Lock()
+	s, ok := c.series[key]
+	if !ok {
+		s = &series{}
+		c.series[key] = s
+	}
+	drop := make(map[string]bool, len(removed))
+	for _, chunk := range removed {
+		drop[chunk.path] = true
+	}
+	chunks := make([]chunkFile, 0, len(s.chunks)+len(added))
+	for _, chunk := range s.chunks {
+		if !drop[chunk.path] {
+			chunks = append(chunks, chunk)
+		}
+	}
+	s.chunks = append(chunks, added...)
+	sortChunks(s.chunks)
+	c.mutex.Unlock()
+
+	var errs []error
+	for _, chunk := range removed {
+		if err := os.Remove(chunk.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// rewrite 将按时间排序的数据点写为不超过chunkPoints的新块
+func (c *chunkStore) rewrite(key seriesKey, points []point) ([]chunkFile, error) {
+	var added []chunkFile
+	for len(points) > 0 {
+		n := min(len(points), c.chunkPoints)
+		encoder := &chunkEncoder{}
+		for _, p := range points[:n] {
+			encoder.append(p)
+		}
+		c.mutex.Lock()
+		chunk, err := c.writeChunk(key, encoder)
+		c.mutex.Unlock()
+		if err != nil {
+			for _, chunk := range added {
+				os.Remove(chunk.path)
+			}
+			return nil, err
+		}
+		added = append(added, chunk)
+		points = points[n:]
+	}
+	return added, nil
+}
+
+// compact 合并小块和时间范围重叠的块，返回被合并的块数量
+// 每次重启和合并目标都会留下未写满或重叠的块，合并后查询需要读取的文件更少
+func (c *chunkStore) compact() (int, error) {
+	c.maintenance.Lock()
+	defer c.maintenance.Unlock()
+
+	compacted := 0
+	var err error
+	for _, key := range c.keys() {
+		var n int
+		n, err = c.compactSeries(key)
+		compacted += n
+		if err != nil {
+			break
+		}
+	}
+
+	now := time.Now()
+	c.statsMutex.Lock()
+	c.lastCompaction = &now
+	c.compacted = compacted
+	c.statsMutex.Unlock()
+	return compacted, err
+}
+
+// compactSeries 合并一个序列中相邻的小块和重叠的块
+func (c *chunkStore) compactSeries(key seriesKey) (int, error) {
+	c.mutex.RLock()
+	var chunks []chunkFile
+	if s, ok := c.series[key]; ok {
+		chunks = append(chunks, s.chunks...)
+	}
+	c.mutex.RUnlock()
+
+	// 按时间顺序分组：与组内的块重叠，或合并后不超过块的数据点数和时间跨度时放入同一组
+	var groups [][]chunkFile
+	var group []chunkFile
+	var count int
+	var first, last int64
+	for _, chunk := range chunks {
+		if len(group) > 0 && (chunk.min <= last ||
+			(count+chunk.count <= c.chunkPoints && chunk.max-first < c.chunkSpan)) {
+			group = append(group, chunk)
+			count += chunk.count
+			last = max(last, chunk.max)
+			continue
+		}
+		if len(group) > 1 {
+			groups = append(groups, group)
+		}
+		group = []chunkFile{chunk}
+		count, first, last = chunk.count, chunk.min, chunk.max
+	}
+	if len(group) > 1 {
+		groups = append(groups, group)
+	}
+
+	compacted := 0
+	for _, group := range groups {
+		var points []point
+		for _, chunk := range group {
+			decoded, err := readChunk(chunk.path)
+			if err != nil {
+				return compacted, err
+			}
+			points = append(points, decoded...)
+		}
+		sort.SliceStable(points, func(i, j int) bool { return points[i].t < points[j].t })
+
+		added, err := c.rewrite(key, points)
+		if err != nil {
+			return compacted, err
+		}
+		if err := c.replace(key, group, added); err != nil {
+			return compacted, err
+		}
+		compacted += len(group)
+	}
+	return compacted, nil
+}
+
+// purge 删除序列中早于cutoff的数据点，返回删除的数量
+// 整个早于cutoff的块直接删除，跨过cutoff的块重写为只包含之后数据点的新块
+func (c *chunkStore) purge(key seriesKey, cutoff int64) (int64, error) {
+	c.maintenance.Lock()
+	defer c.maintenance.Unlock()
+
+	var deleted int64
+	c.mutex.Lock()
+	s, ok := c.series[key]
+	if ok && s.head != nil && s.head.min < cutoff {
+		points, err := decodeChunk(s.head.encode())
+		if err != nil {
+			c.mutex.Unlock()
+			return 0, err
+		}
+		encoder := &chunkEncoder{}
+		for _, p := range points {
+			if p.t >= cutoff {
+				encoder.append(p)
+			}
+		}
+		deleted += int64(len(points) - encoder.count)
+		if encoder.count > 0 {
+			s.head, s.dirty = encoder, true
+		} else {
+			// flushHeads不会处理空的块，需要立即删除head.seg，否则重启后过期的数据会重新出现
+			s.head, s.dirty = nil, false
+			err := os.Remove(filepath.Join(key.dir(c.root), headFile))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				c.mutex.Unlock()
+				return deleted, err
+			}
+		}
+	}
+	var chunks []chunkFile
+	if ok {
+		chunks = append(chunks, s.chunks...)
+	}
+	c.mutex.Unlock()
+
+	var removed, added []chunkFile
+	for _, chunk := range chunks {
+		if chunk.min >= cutoff {
+			continue
+		}
+		removed = append(removed, chunk)
+		if chunk.max < cutoff {
+			deleted += int64(chunk.count)
+			continue
+		}
+
+		points, err := readChunk(chunk.path)
+		if err != nil {
+			return deleted, err
+		}
+		kept := points[:0]
+		for _, p := range points {
+			if p.t >= cutoff {
+				kept = append(kept, p)
+			}
+		}
+		deleted += int64(len(points) - len(kept))
+		sort.SliceStable(kept, func(i, j int) bool { return kept[i].t < kept[j].t })
+		rewritten, err := c.rewrite(key, kept)
+		if err != nil {
+			return deleted, err
+		}
+		added = append(added, rewritten...)
+	}
+	if len(removed) == 0 {
+		return deleted, nil
+	}
+	return deleted, c.replace(key, removed, added)
+}
+
+// merge 将目标from的全部序列移动到目标to，重叠的块在下次合并小块时整理
+func (c *chunkStore) merge(from, to string) error {
+	c.maintenance.Lock()
+	defer c.maintenance.Unlock()
+	c.files.Lock()
+	defer c.files.Unlock()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for key, s := range c.series {
+		if key.target != from {
+			continue
+		}
+		if err := c.sealLocked(key, s); err != nil {
+			return err
+		}
+		destKey := seriesKey{target: to, metric: key.metric}
+		dest, ok := c.series[destKey]
+		if !ok {
+			dest = &series{}
+			c.series[destKey] = dest
+		}
+		dir := destKey.dir(c.root)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		for len(s.chunks) > 0 {
+			chunk := s.chunks[0]
+			c.seq++
+			path := filepath.Join(dir, fmt.Sprintf("%d-%d-%d.seg", chunk.min, chunk.max, c.seq))
+			if err := os.Rename(chunk.path, path); err != nil {
+				return err
+			}
+			chunk.path = path
+			dest.chunks = append(dest.chunks, chunk)
+			s.chunks = s.chunks[1:]
+		}
+		sortChunks(dest.chunks)
+		delete(c.series, key)
+	}
+	return os.RemoveAll(filepath.Join(c.root, from))
+}
+
+// purgeTarget 删除目标的全部序列
+func (c *chunkStore) purgeTarget(target string) error {
+	c.maintenance.Lock()
+	defer c.maintenance.Unlock()
+	c.files.Lock()
+	defer c.files.Unlock()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for key := range c.series {
+		if key.target == target {
+			delete(c.series, key)
+		}
+	}
+	return os.RemoveAll(filepath.Join(c.root, target))
+}
+
+// stats 块数量、数据点数和压缩效果
+func (c *chunkStore) stats() *models.SegmentStats {
+	stats := &models.SegmentStats{}
+
+	c.mutex.RLock()
+	stats.Series = len(c.series)
+	for _, s := range c.series {
+		for _, chunk := range s.chunks {
+			stats.Chunks++
+			stats.Points += int64(chunk.count)
+			stats.Bytes += chunk.size
+		}
+		if s.head != nil {
+			stats.Points += int64(s.head.count)
+			stats.Bytes += int64(chunkHeaderSize + len(s.head.bits.buf))
+		}
+	}
+	c.mutex.RUnlock()
+
+	if stats.Points > 0 {
+		stats.BytesPerPoint = float64(stats.Bytes) / float64(stats.Points)
+	}
+	c.statsMutex.Lock()
+	stats.LastCompaction = c.lastCompaction
+	stats.Compacted = c.compacted
+	c.statsMutex.Unlock()
+	return stats
+}
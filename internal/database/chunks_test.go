@@ -0,0 +1,191 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"scallop/internal/models"
+)
+
+// testSegment 每块最多10个数据点，后台保存和合并的间隔足够长，测试中手动触发
+var testSegment = &models.Segment{ChunkPoints: 10, ChunkDuration: 3600, FlushInterval: 3600, CompactInterval: 3600}
+
+func openTestChunks(t *testing.T, dir string) *chunkStore {
+	t.Helper()
+	c, err := openChunkStore(dir, testSegment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// appendPoints 追加时间为base加上各偏移秒数的数据点，数值为偏移秒数
+func appendPoints(t *testing.T, c *chunkStore, key seriesKey, base int64, offsets ...int64) {
+	t.Helper()
+	for _, offset := range offsets {
+		if err := c.append(key, point{t: base + offset*1000, v: float64(offset), ok: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// segFiles 序列目录中的块文件和是否存在head.seg
+func segFiles(t *testing.T, c *chunkStore, key seriesKey) (chunks int, head bool) {
+	t.Helper()
+	entries, err := os.ReadDir(key.dir(c.root))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		switch {
+		case entry.Name() == headFile:
+			head = true
+		case strings.HasSuffix(entry.Name(), ".seg"):
+			chunks++
+		}
+	}
+	return chunks, head
+}
+
+// offsets 查询序列的全部数据点，返回各点的偏移秒数
+func offsets(t *testing.T, c *chunkStore, key seriesKey, base int64) []int64 {
+	t.Helper()
+	points, err := c.query(key, 0, base*2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result []int64
+	for _, p := range points {
+		result = append(result, (p.t-base)/1000)
+	}
+	return result
+}
+
+func equalOffsets(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestChunkStoreCompactAndPurge(t *testing.T) {
+	dir := t.TempDir()
+	key := seriesKey{target: "t1"}
+	base := int64(1_700_000_000_000)
+
+	// 每次关闭都会把未写满的块保存为块文件，留下4个小块和1个与第一个块重叠的块
+	for _, batch := range [][]int64{{0, 1, 2}, {3, 4, 5}, {6, 7, 8}, {9, 10, 11}, {0, 1}} {
+		c := openTestChunks(t, dir)
+		if batch[0] == 0 && len(batch) == 2 {
+			// 与第一个块时间相同的数据点偏移0.5秒
+			for _, offset := range batch {
+				if err := c.append(key, point{t: base + offset*1000 + 500, v: 0.5, ok: true}); err != nil {
+					t.Fatal(err)
+				}
+			}
+		} else {
+			appendPoints(t, c, key, base, batch...)
+		}
+		if err := c.close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := openTestChunks(t, dir)
+	defer func() { c.close() }()
+	if chunks, head := segFiles(t, c, key); chunks != 5 || head {
+		t.Fatalf("合并前有%d个块文件、head.seg=%v，应为5个块文件", chunks, head)
+	}
+	before := offsets(t, c, key, base)
+
+	// 重叠的块与之后不超过10个数据点的块合并为一组，其余两个小块合并为另一组
+	compacted, err := c.compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compacted != 5 {
+		t.Fatalf("合并了%d个块，应为5个", compacted)
+	}
+	if chunks, _ := segFiles(t, c, key); chunks != 2 {
+		t.Fatalf("合并后有%d个块文件，应为2个", chunks)
+	}
+	if after := offsets(t, c, key, base); !equalOffsets(before, after) || len(after) != 14 {
+		t.Fatalf("合并前后的数据点不同: %v -> %v", before, after)
+	}
+	if count, first, last := c.span(key); count != 14 || first != base || last != base+11000 {
+		t.Fatalf("合并后的索引为%d个数据点 %d-%d", count, first, last)
+	}
+
+	// 正在写入的块在清理时间之后，只清理块文件：整块过期的直接删除，跨过清理时间的重写
+	appendPoints(t, c, key, base, 20, 21, 22)
+	if err := c.flushHeads(); err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := c.purge(key, base+7000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 9 {
+		t.Fatalf("删除了%d个数据点，应为9个", deleted)
+	}
+	if got, want := offsets(t, c, key, base), []int64{7, 8, 9, 10, 11, 20, 21, 22}; !equalOffsets(got, want) {
+		t.Fatalf("清理后的数据点为%v，应为%v", got, want)
+	}
+
+	// 正在写入的块全部过期时删除head.seg，重启后过期的数据不会重新出现
+	if _, head := segFiles(t, c, key); !head {
+		t.Fatal("保存后应存在head.seg")
+	}
+	deleted, err = c.purge(key, base+100_000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 8 {
+		t.Fatalf("删除了%d个数据点，应为8个", deleted)
+	}
+	if chunks, head := segFiles(t, c, key); chunks != 0 || head {
+		t.Fatalf("全部过期后仍有%d个块文件、head.seg=%v", chunks, head)
+	}
+	if err := c.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	c = openTestChunks(t, dir)
+	if got := offsets(t, c, key, base); len(got) != 0 {
+		t.Fatalf("重新打开后仍有数据点: %v", got)
+	}
+}
+
+func TestChunkStoreRecoversHead(t *testing.T) {
+	dir := t.TempDir()
+	key := seriesKey{target: "t1", metric: "dns_ms"}
+	base := int64(1_700_000_000_000)
+
+	c := openTestChunks(t, dir)
+	appendPoints(t, c, key, base, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11)
+	if err := c.flushHeads(); err != nil {
+		t.Fatal(err)
+	}
+	// 模拟进程异常退出：不关闭，直接从磁盘重新打开
+	close(c.stop)
+	<-c.done
+
+	c = openTestChunks(t, dir)
+	defer func() { c.close() }()
+	if keys := c.keys(); len(keys) != 1 || keys[0] != key {
+		t.Fatalf("重新打开后的序列为%v", keys)
+	}
+	if got := offsets(t, c, key, base); len(got) != 12 {
+		t.Fatalf("重新打开后有%d个数据点，应为12个", len(got))
+	}
+	if _, err := os.Stat(filepath.Join(key.dir(dir), headFile)); err != nil {
+		t.Fatalf("未写满的块应保存为head.seg: %v", err)
+	}
+}
@@ -1,10 +1,14 @@
 package database
 
 import (
+	"context"
 	"crypto/md5"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"scallop/internal/models"
@@ -14,72 +18,66 @@ import (
 
 // DB 数据库管理器
 type DB struct {
-	conn    *sql.DB
-	mutex   sync.Mutex
-	targets map[string]*models.Target // 当前活跃目标，key为目标ID
+	conn      *sql.DB
+	mutex     sync.Mutex
+	lastPurge atomic.Pointer[models.PurgeResult] // 最近一次过期数据清理的结果
+	writer    *writer                            // 后台批量写入，未启动时为nil
 }
 
-// New 创建数据库管理器
+var _ Store = (*DB)(nil)
+
+// New 创建数据库管理器，并应用未执行的数据库迁移
 func New(dbPath string) (*DB, error) {
-	conn, err := sql.Open("sqlite", dbPath)
+	db, err := Open(dbPath)
 	if err != nil {
 		return nil, err
 	}
 
-	db := &DB{
-		conn:    conn,
-		targets: make(map[string]*models.Target),
-	}
-
-	if err := db.init(); err != nil {
+	applied, err := db.Migrate(false)
+	if err != nil {
+		db.Close()
 		return nil, err
 	}
+	for _, migration := range applied {
+		fmt.Printf("已应用数据库迁移 %s\n", migration)
+	}
 
 	return db, nil
 }
 
-// init 初始化数据库表
-func (db *DB) init() error {
-	// 创建目标表
-	createTargetsSQL := `
-	CREATE TABLE IF NOT EXISTS targets (
-		id TEXT PRIMARY KEY,
-		addr TEXT NOT NULL,
-		description TEXT NOT NULL,
-		hide_addr BOOLEAN DEFAULT FALSE,
-		dns_server TEXT DEFAULT '',
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);`
-
-	// 创建ping结果表
-	createResultsSQL := `
-	CREATE TABLE IF NOT EXISTS ping_results (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		target_id TEXT NOT NULL,
-		latency REAL NOT NULL,
-		success BOOLEAN NOT NULL,
-		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (target_id) REFERENCES targets(id)
-	);
-	CREATE INDEX IF NOT EXISTS idx_target_timestamp ON ping_results(target_id, timestamp);
-	CREATE INDEX IF NOT EXISTS idx_timestamp ON ping_results(timestamp);
-	`
+// Open 打开数据库但不执行迁移，用于检查数据库结构版本或预演迁移
+func Open(dbPath string) (*DB, error) {
+	// 其他连接正在读写时等待最多5秒，避免后台清理与读取同时进行时直接返回SQLITE_BUSY
+	// WAL模式下读取不会被写入阻塞，synchronous=NORMAL在WAL模式下断电时只可能丢失最近提交的事务
+	pragmas := "?_pragma=busy_timeout(5000)&_pragma=journal_mode(wal)&_pragma=synchronous(normal)"
 
-	// 执行创建表语句
-	if _, err := db.conn.Exec(createTargetsSQL); err != nil {
-		return fmt.Errorf("创建targets表失败: %v", err)
+	// 新建的数据库启用增量VACUUM，已有的数据库需执行一次VACUUM后才会生效
+	// 设置auto_vacuum每次都会开启写事务，只在创建文件时设置，以免连接池新建连接时与写入争用
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		create, err := sql.Open("sqlite", dbPath+"?_pragma=auto_vacuum(incremental)&_pragma=journal_mode(wal)")
+		if err != nil {
+			return nil, err
+		}
+		err = create.Ping()
+		create.Close()
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	if _, err := db.conn.Exec(createResultsSQL); err != nil {
-		return fmt.Errorf("创建ping_results表失败: %v", err)
+	conn, err := sql.Open("sqlite", dbPath+pragmas)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	return &DB{
+		conn: conn,
+	}, nil
 }
 
-// Close 关闭数据库连接
+// Close 写入队列中剩余的结果后关闭数据库连接
 func (db *DB) Close() error {
+	db.writer.close()
 	return db.conn.Close()
 }
 
@@ -95,19 +93,67 @@ func GenerateTargetID(addr, description string, hideAddr bool, dnsServer string)
 	return fmt.Sprintf("%x", hash)[:16] // 使用前16位作为ID
 }
 
+// TargetID 配置项对应的目标ID：配置了id时直接使用，否则由地址、描述、是否隐藏地址和DNS服务器生成
+func TargetID(spec models.IPTarget) string {
+	if spec.ID != "" {
+		return spec.ID
+	}
+	return GenerateTargetID(spec.Addr, spec.Description, spec.HideAddr, spec.DNSServer)
+}
+
+// targetTables 以target_id关联目标的历史数据表
+var targetTables = []string{"ping_results", "probe_metrics", "events", "resolved_addresses"}
+
+// MergeTarget 将目标from的历史数据合并到目标to并删除目标from，用于目标改名或合并
+// target_info保存的是最新状态，两个目标都有同类记录时保留to的；
+// 两个目标在同一时间段都有汇总时同样保留to的，需要时可以用BackfillRollups由原始结果重新计算
+func (db *DB) MergeTarget(from, to string) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range targetTables {
+		if _, err := tx.Exec("UPDATE "+table+" SET target_id = ? WHERE target_id = ?", to, from); err != nil {
+			return fmt.Errorf("合并%s失败: %v", table, err)
+		}
+	}
+	for _, table := range []string{"target_info", "ping_rollups"} {
+		if _, err := tx.Exec("UPDATE OR IGNORE "+table+" SET target_id = ? WHERE target_id = ?", to, from); err != nil {
+			return fmt.Errorf("合并%s失败: %v", table, err)
+		}
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE target_id = ?", from); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM targets WHERE id = ?", from); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 // SaveTarget 保存目标到数据库
 func (db *DB) SaveTarget(target *models.Target) error {
-	query := `INSERT OR REPLACE INTO targets (id, addr, description, hide_addr, dns_server, created_at, updated_at) 
-			  VALUES (?, ?, ?, ?, ?, ?, ?)`
+	spec, err := json.Marshal(target.Spec)
+	if err != nil {
+		return err
+	}
 
-	_, err := db.conn.Exec(query, target.ID, target.Addr, target.Description,
-		target.HideAddr, target.DNSServer, target.CreatedAt, target.UpdatedAt)
+	query := `INSERT OR REPLACE INTO targets (id, addr, description, hide_addr, dns_server, spec, created_at, updated_at) 
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	_, err = db.conn.Exec(query, target.ID, target.Addr, target.Description,
+		target.HideAddr, target.DNSServer, string(spec), target.CreatedAt, target.UpdatedAt)
 	return err
 }
 
 // LoadTargets 从数据库加载目标
 func (db *DB) LoadTargets() (map[string]*models.Target, error) {
-	rows, err := db.conn.Query("SELECT id, addr, description, hide_addr, dns_server, created_at, updated_at FROM targets")
+	rows, err := db.conn.Query("SELECT id, addr, description, hide_addr, dns_server, spec, created_at, updated_at FROM targets")
 	if err != nil {
 		return nil, err
 	}
@@ -115,9 +161,7 @@ func (db *DB) LoadTargets() (map[string]*models.Target, error) {
 
 	targets := make(map[string]*models.Target)
 	for rows.Next() {
-		target := &models.Target{}
-		err := rows.Scan(&target.ID, &target.Addr, &target.Description,
-			&target.HideAddr, &target.DNSServer, &target.CreatedAt, &target.UpdatedAt)
+		target, err := scanTarget(rows)
 		if err != nil {
 			continue
 		}
@@ -127,68 +171,325 @@ func (db *DB) LoadTargets() (map[string]*models.Target, error) {
 	return targets, nil
 }
 
-// SavePingResult 保存Ping结果
+// GetTarget 获取单个目标，不存在时返回sql.ErrNoRows
+func (db *DB) GetTarget(id string) (*models.Target, error) {
+	query := "SELECT id, addr, description, hide_addr, dns_server, spec, created_at, updated_at FROM targets WHERE id = ?"
+	return scanTarget(db.conn.QueryRow(query, id))
+}
+
+// scanTarget 读取一行目标记录，旧版本保存的目标没有配置项时由地址等字段还原
+func scanTarget(row interface{ Scan(...interface{}) error }) (*models.Target, error) {
+	target := &models.Target{}
+	var spec string
+	err := row.Scan(&target.ID, &target.Addr, &target.Description,
+		&target.HideAddr, &target.DNSServer, &spec, &target.CreatedAt, &target.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if spec == "" || json.Unmarshal([]byte(spec), &target.Spec) != nil {
+		target.Spec = models.IPTarget{
+			Addr:        target.Addr,
+			Description: target.Description,
+			HideAddr:    target.HideAddr,
+			DNSServer:   target.DNSServer,
+		}
+	}
+	return target, nil
+}
+
+// SavePingResult 保存Ping结果，并在同一事务中计入各层级的汇总
+// 批量写入已启动时结果在下一次提交后才能查询到
 func (db *DB) SavePingResult(result models.PingResult) error {
+	return db.write(func(ctx context.Context, tx execer) error {
+		query := `INSERT INTO ping_results (target_id, latency, success, timestamp) 
+			  VALUES (?, ?, ?, ?)`
+
+		if _, err := tx.ExecContext(ctx, query, result.TargetID, result.Latency, result.Success, result.Timestamp); err != nil {
+			return err
+		}
+		if err := addToRollups(ctx, tx, sqliteRollupSQL, result); err != nil {
+			return fmt.Errorf("更新汇总失败: %v", err)
+		}
+		return nil
+	})
+}
+
+// SaveMetrics 保存一次探测产生的附加指标
+func (db *DB) SaveMetrics(targetID string, timestamp time.Time, metrics map[string]float64) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	// 写入前调用方可能继续修改map，入队时复制一份
+	values := make(map[string]float64, len(metrics))
+	for name, value := range metrics {
+		values[name] = value
+	}
+
+	return db.write(func(ctx context.Context, tx execer) error {
+		query := `INSERT INTO probe_metrics (target_id, name, value, timestamp) VALUES (?, ?, ?, ?)`
+		for name, value := range values {
+			if _, err := tx.ExecContext(ctx, query, targetID, name, value, timestamp); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetMetrics 查询指定目标在时间范围内的附加指标，name为空时返回全部指标
+func (db *DB) GetMetrics(targetID, name string, since, until time.Time) ([]models.Metric, error) {
+	query := `SELECT target_id, name, value, timestamp FROM probe_metrics
+			  WHERE target_id = ? AND timestamp >= ? AND timestamp <= ?`
+	args := []interface{}{targetID, since, until}
+	if name != "" {
+		query += " AND name = ?"
+		args = append(args, name)
+	}
+	query += " ORDER BY timestamp ASC"
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	metrics := []models.Metric{}
+	for rows.Next() {
+		var metric models.Metric
+		if err := rows.Scan(&metric.TargetID, &metric.Name, &metric.Value, &metric.Timestamp); err != nil {
+			continue
+		}
+		metrics = append(metrics, metric)
+	}
+
+	return metrics, rows.Err()
+}
+
+// GetPingResults 查询指定目标在时间范围内的Ping结果
+func (db *DB) GetPingResults(targetID string, since, until time.Time) ([]models.PingResult, error) {
+	query := `SELECT id, target_id, latency, success, timestamp FROM ping_results
+			  WHERE target_id = ? AND timestamp >= ? AND timestamp <= ?
+			  ORDER BY timestamp ASC`
+	rows, err := db.conn.Query(query, targetID, since, until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanPingResults(rows), rows.Err()
+}
+
+// GetLatestResults 获取每个目标最新的一条Ping结果
+func (db *DB) GetLatestResults() ([]models.PingResult, error) {
+	query := `SELECT id, target_id, latency, success, timestamp FROM ping_results
+			  WHERE (target_id, timestamp) IN (
+				  SELECT target_id, MAX(timestamp)
+				  FROM ping_results
+				  GROUP BY target_id
+			  )`
+	rows, err := db.conn.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanPingResults(rows), rows.Err()
+}
+
+// scanPingResults 扫描Ping结果记录
+func scanPingResults(rows *sql.Rows) []models.PingResult {
+	results := []models.PingResult{}
+	for rows.Next() {
+		var result models.PingResult
+		if err := rows.Scan(&result.ID, &result.TargetID, &result.Latency, &result.Success, &result.Timestamp); err != nil {
+			continue
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// SaveEvent 保存目标事件
+func (db *DB) SaveEvent(event models.Event) error {
 	db.mutex.Lock()
 	defer db.mutex.Unlock()
 
-	query := `INSERT INTO ping_results (target_id, latency, success, timestamp) 
-			  VALUES (?, ?, ?, ?)`
+	query := `INSERT INTO events (target_id, kind, level, message, timestamp) VALUES (?, ?, ?, ?, ?)`
+	_, err := db.conn.Exec(query, event.TargetID, event.Kind, event.Level, event.Message, event.Timestamp)
+	return err
+}
+
+// GetEvents 查询时间范围内的事件，targetID为空时返回所有目标的事件
+func (db *DB) GetEvents(targetID string, since, until time.Time) ([]models.Event, error) {
+	query := `SELECT id, target_id, kind, level, message, timestamp FROM events
+			  WHERE timestamp >= ? AND timestamp <= ?`
+	args := []interface{}{since, until}
+	if targetID != "" {
+		query += " AND target_id = ?"
+		args = append(args, targetID)
+	}
+	query += " ORDER BY timestamp ASC"
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.Event{}
+	for rows.Next() {
+		var event models.Event
+		if err := rows.Scan(&event.ID, &event.TargetID, &event.Kind, &event.Level, &event.Message, &event.Timestamp); err != nil {
+			continue
+		}
+		events = append(events, event)
+	}
 
-	_, err := db.conn.Exec(query, result.TargetID, result.Latency, result.Success, result.Timestamp)
+	return events, rows.Err()
+}
+
+// SaveTargetInfo 保存目标的最新探测详情（如证书信息），同一类别只保留最新一份
+func (db *DB) SaveTargetInfo(targetID, kind string, data interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	query := `INSERT OR REPLACE INTO target_info (target_id, kind, data, updated_at) VALUES (?, ?, ?, ?)`
+	_, err = db.conn.Exec(query, targetID, kind, string(encoded), time.Now())
 	return err
 }
 
-// GetTargets 获取当前目标列表
-func (db *DB) GetTargets() map[string]*models.Target {
-	return db.targets
+// GetTargetInfo 获取目标的最新探测详情，不存在时返回sql.ErrNoRows
+func (db *DB) GetTargetInfo(targetID, kind string) (json.RawMessage, time.Time, error) {
+	var data string
+	var updatedAt time.Time
+	query := `SELECT data, updated_at FROM target_info WHERE target_id = ? AND kind = ?`
+	if err := db.conn.QueryRow(query, targetID, kind).Scan(&data, &updatedAt); err != nil {
+		return nil, time.Time{}, err
+	}
+	return json.RawMessage(data), updatedAt, nil
 }
 
-// SetTargets 设置目标列表
-func (db *DB) SetTargets(targets map[string]*models.Target) {
-	db.targets = targets
+// SaveResolvedAddress 保存目标解析到的新地址
+func (db *DB) SaveResolvedAddress(addr models.ResolvedAddress) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	query := `INSERT INTO resolved_addresses (target_id, ip, asn, org, country, country_name, city, timestamp)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := db.conn.Exec(query, addr.TargetID, addr.IP, addr.Geo.ASN, addr.Geo.Org,
+		addr.Geo.Country, addr.Geo.CountryName, addr.Geo.City, addr.Timestamp)
+	return err
 }
 
-// UpdateTargetsFromConfig 根据配置更新目标
-func (db *DB) UpdateTargetsFromConfig(configTargets []models.IPTarget) error {
-	// 先从数据库加载现有目标
-	existingTargets, err := db.LoadTargets()
+// GetLatestAddresses 获取每个目标最近一次解析到的地址，key为目标ID
+func (db *DB) GetLatestAddresses() (map[string]models.ResolvedAddress, error) {
+	query := `SELECT target_id, ip, asn, org, country, country_name, city, timestamp
+			  FROM resolved_addresses
+			  WHERE id IN (SELECT MAX(id) FROM resolved_addresses GROUP BY target_id)`
+
+	rows, err := db.conn.Query(query)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer rows.Close()
 
-	// 创建新的目标映射
-	newTargets := make(map[string]*models.Target)
-
-	for _, configTarget := range configTargets {
-		targetID := GenerateTargetID(configTarget.Addr, configTarget.Description,
-			configTarget.HideAddr, configTarget.DNSServer)
-
-		// 检查是否已存在
-		if existingTarget, exists := existingTargets[targetID]; exists {
-			existingTarget.UpdatedAt = time.Now()
-			newTargets[targetID] = existingTarget
-		} else {
-			// 创建新目标
-			target := &models.Target{
-				ID:          targetID,
-				Addr:        configTarget.Addr,
-				Description: configTarget.Description,
-				HideAddr:    configTarget.HideAddr,
-				DNSServer:   configTarget.DNSServer,
-				CreatedAt:   time.Now(),
-				UpdatedAt:   time.Now(),
-			}
+	addresses := make(map[string]models.ResolvedAddress)
+	for _, addr := range scanAddresses(rows) {
+		addresses[addr.TargetID] = addr
+	}
+	return addresses, rows.Err()
+}
 
-			if err := db.SaveTarget(target); err != nil {
-				return err
-			}
+// GetAddressHistory 获取目标在时间范围内的地址变化记录
+func (db *DB) GetAddressHistory(targetID string, since, until time.Time) ([]models.ResolvedAddress, error) {
+	query := `SELECT target_id, ip, asn, org, country, country_name, city, timestamp
+			  FROM resolved_addresses
+			  WHERE target_id = ? AND timestamp >= ? AND timestamp <= ?
+			  ORDER BY timestamp ASC`
 
-			newTargets[targetID] = target
-			fmt.Printf("添加新目标: %s (%s)\n", target.Description, target.Addr)
+	rows, err := db.conn.Query(query, targetID, since, until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanAddresses(rows), rows.Err()
+}
+
+// scanAddresses 扫描解析地址记录
+func scanAddresses(rows *sql.Rows) []models.ResolvedAddress {
+	addresses := []models.ResolvedAddress{}
+	for rows.Next() {
+		var addr models.ResolvedAddress
+		err := rows.Scan(&addr.TargetID, &addr.IP, &addr.Geo.ASN, &addr.Geo.Org,
+			&addr.Geo.Country, &addr.Geo.CountryName, &addr.Geo.City, &addr.Timestamp)
+		if err != nil {
+			continue
 		}
+		addresses = append(addresses, addr)
 	}
+	return addresses
+}
+
+// SyncTargets 将配置中的目标同步到数据库，按配置顺序返回对应的目标
+// 返回的目标都是新创建的对象，可直接放入目标注册表
+func (db *DB) SyncTargets(configTargets []models.IPTarget) ([]*models.Target, error) {
+	return syncTargets(db, configTargets)
+}
 
-	db.targets = newTargets
-	return nil
+// GetLastSuccess 获取目标最近一次成功结果的时间，没有结果时返回零值
+func (db *DB) GetLastSuccess(targetID string) (time.Time, error) {
+	var timestamp time.Time
+	query := `SELECT timestamp FROM ping_results WHERE target_id = ? AND success = 1
+			  ORDER BY timestamp DESC LIMIT 1`
+	err := db.conn.QueryRow(query, targetID).Scan(&timestamp)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return timestamp, err
+}
+
+// SaveRUMSample 保存一条访客延迟样本
+func (db *DB) SaveRUMSample(sample models.RUMSample) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	query := `INSERT INTO rum_samples (prefix, asn, org, country, country_name, city, latency, samples, timestamp)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := db.conn.Exec(query, sample.Prefix, sample.Geo.ASN, sample.Geo.Org, sample.Geo.Country,
+		sample.Geo.CountryName, sample.Geo.City, sample.Latency, sample.Samples, sample.Timestamp)
+	return err
+}
+
+// GetRUMSamples 查询时间范围内的访客延迟样本
+func (db *DB) GetRUMSamples(since, until time.Time) ([]models.RUMSample, error) {
+	query := `SELECT id, prefix, asn, org, country, country_name, city, latency, samples, timestamp
+			  FROM rum_samples
+			  WHERE timestamp >= ? AND timestamp <= ?
+			  ORDER BY timestamp ASC`
+
+	rows, err := db.conn.Query(query, since, until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	samples := []models.RUMSample{}
+	for rows.Next() {
+		var sample models.RUMSample
+		err := rows.Scan(&sample.ID, &sample.Prefix, &sample.Geo.ASN, &sample.Geo.Org, &sample.Geo.Country,
+			&sample.Geo.CountryName, &sample.Geo.City, &sample.Latency, &sample.Samples, &sample.Timestamp)
+		if err != nil {
+			continue
+		}
+		samples = append(samples, sample)
+	}
+	return samples, rows.Err()
 }
@@ -0,0 +1,318 @@
+package database
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"math/bits"
+)
+
+// 块文件的格式：魔数、数据点数、最早和最晚的时间戳（毫秒）、数据的CRC32，之后是压缩的数据
+const (
+	chunkMagic      = "SCG1"
+	chunkHeaderSize = 4 + 4 + 8 + 8 + 4
+)
+
+var errChunkTruncated = errors.New("块数据不完整")
+
+// point 时间序列中的一个数据点，ok对Ping结果表示是否成功
+type point struct {
+	t  int64 // 毫秒时间戳
+	v  float64
+	ok bool
+}
+
+// bitWriter 按位追加写入的缓冲区
+type bitWriter struct {
+	buf  []byte
+	free int // 最后一个字节中尚未使用的位数
+}
+
+func (w *bitWriter) writeBit(bit bool) {
+	if w.free == 0 {
+		w.buf = append(w.buf, 0)
+		w.free = 8
+	}
+	if bit {
+		w.buf[len(w.buf)-1] |= 1 << (w.free - 1)
+	}
+	w.free--
+}
+
+// writeBits 写入value的低n位，高位在前
+func (w *bitWriter) writeBits(value uint64, n int) {
+	for n > 0 {
+		if w.free == 0 {
+			w.buf = append(w.buf, 0)
+			w.free = 8
+		}
+		take := min(n, w.free)
+		chunk := byte(value>>(n-take)) & byte(1<<take-1)
+		w.buf[len(w.buf)-1] |= chunk << (w.free - take)
+		w.free -= take
+		n -= take
+	}
+}
+
+// bitReader 按位读取
+type bitReader struct {
+	buf []byte
+	pos int // 下一个要读取的位
+}
+
+func (r *bitReader) readBit() (bool, error) {
+	if r.pos >= len(r.buf)*8 {
+		return false, errChunkTruncated
+	}
+	bit := r.buf[r.pos/8] >> (7 - r.pos%8) & 1
+	r.pos++
+	return bit == 1, nil
+}
+
+// readBits 读取n位，高位在前
+func (r *bitReader) readBits(n int) (uint64, error) {
+	var value uint64
+	for n > 0 {
+		if r.pos >= len(r.buf)*8 {
+			return 0, errChunkTruncated
+		}
+		avail := 8 - r.pos%8
+		take := min(n, avail)
+		chunk := r.buf[r.pos/8] >> (avail - take) & byte(1<<take-1)
+		value = value<<take | uint64(chunk)
+		r.pos += take
+		n -= take
+	}
+	return value, nil
+}
+
+// 时间戳二阶差分的编码：前缀中1的个数决定其后数值的位数
+var dodWidths = [...]int{0, 7, 9, 12, 64}
+
+// chunkEncoder 以Gorilla方式压缩一个块：时间戳记录二阶差分，按固定间隔采样时大多只占1位；
+// 数值记录与上一个值的异或，延迟变化不大时只需保存少数有效位
+type chunkEncoder struct {
+	bits     bitWriter
+	count    int
+	min, max int64 // 块中最早和最晚的时间戳
+
+	last     int64  // 上一个数据点的时间戳
+	delta    int64  // 上一个数据点与再上一个的时间差
+	value    uint64 // 上一个数值的位表示
+	leading  int    // 上一个异或值的前导零位数，-1表示尚无
+	trailing int    // 上一个异或值的末尾零位数
+}
+
+// append 追加一个数据点，时间戳可以小于上一个数据点
+func (e *chunkEncoder) append(p point) {
+	raw := math.Float64bits(p.v)
+	if e.count == 0 {
+		e.bits.writeBits(uint64(p.t), 64)
+		e.bits.writeBits(raw, 64)
+		e.min, e.max = p.t, p.t
+		e.leading = -1
+	} else {
+		delta := p.t - e.last
+		e.writeDoD(delta - e.delta)
+		e.delta = delta
+		e.writeValue(raw)
+		e.min = min(e.min, p.t)
+		e.max = max(e.max, p.t)
+	}
+	e.bits.writeBit(p.ok)
+	e.last = p.t
+	e.value = raw
+	e.count++
+}
+
+// writeDoD 写入时间戳的二阶差分
+func (e *chunkEncoder) writeDoD(dod int64) {
+	if dod == 0 {
+		e.bits.writeBit(false)
+		return
+	}
+	for class := 1; class < len(dodWidths); class++ {
+		width := dodWidths[class]
+		if class < len(dodWidths)-1 && (dod < -(1<<(width-1))+1 || dod > 1<<(width-1)) {
+			continue
+		}
+		// 前缀为class个1，不足4个时以0结束
+		e.bits.writeBits(1<<class-1, class)
+		if class < len(dodWidths)-1 {
+			e.bits.writeBit(false)
+		}
+		e.bits.writeBits(uint64(dod), width)
+		return
+	}
+}
+
+// writeValue 写入数值与上一个值的异或：相同时只写1位，有效位落在上一个异或值的范围内时沿用其位置
+func (e *chunkEncoder) writeValue(raw uint64) {
+	xor := raw ^ e.value
+	if xor == 0 {
+		e.bits.writeBit(false)
+		return
+	}
+	e.bits.writeBit(true)
+
+	leading := min(bits.LeadingZeros64(xor), 31)
+	trailing := bits.TrailingZeros64(xor)
+	if e.leading >= 0 && leading >= e.leading && trailing >= e.trailing {
+		e.bits.writeBit(false)
+		e.bits.writeBits(xor>>e.trailing, 64-e.leading-e.trailing)
+		return
+	}
+
+	// 有效位数为64时写作0
+	meaningful := 64 - leading - trailing
+	e.bits.writeBit(true)
+	e.bits.writeBits(uint64(leading), 5)
+	e.bits.writeBits(uint64(meaningful), 6)
+	e.bits.writeBits(xor>>trailing, meaningful)
+	e.leading, e.trailing = leading, trailing
+}
+
+// encode 生成块文件的内容
+func (e *chunkEncoder) encode() []byte {
+	data := make([]byte, chunkHeaderSize, chunkHeaderSize+len(e.bits.buf))
+	copy(data, chunkMagic)
+	binary.BigEndian.PutUint32(data[4:], uint32(e.count))
+	binary.BigEndian.PutUint64(data[8:], uint64(e.min))
+	binary.BigEndian.PutUint64(data[16:], uint64(e.max))
+	binary.BigEndian.PutUint32(data[24:], crc32.ChecksumIEEE(e.bits.buf))
+	return append(data, e.bits.buf...)
+}
+
+// chunkHeader 块文件头中的数据点数和时间范围
+type chunkHeader struct {
+	count    int
+	min, max int64
+	checksum uint32
+}
+
+// parseChunkHeader 解析块文件头
+func parseChunkHeader(data []byte) (chunkHeader, error) {
+	if len(data) < chunkHeaderSize || string(data[:4]) != chunkMagic {
+		return chunkHeader{}, errors.New("不是有效的块文件")
+	}
+	return chunkHeader{
+		count:    int(binary.BigEndian.Uint32(data[4:])),
+		min:      int64(binary.BigEndian.Uint64(data[8:])),
+		max:      int64(binary.BigEndian.Uint64(data[16:])),
+		checksum: binary.BigEndian.Uint32(data[24:]),
+	}, nil
+}
+
+// decodeChunk 解压块文件中的全部数据点，按写入顺序返回
+func decodeChunk(data []byte) ([]point, error) {
+	header, err := parseChunkHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	payload := data[chunkHeaderSize:]
+	if crc32.ChecksumIEEE(payload) != header.checksum {
+		return nil, errors.New("块数据校验失败")
+	}
+
+	r := &bitReader{buf: payload}
+	points := make([]point, 0, header.count)
+	var last, delta int64
+	var value uint64
+	leading, trailing := 0, 0
+	for i := 0; i < header.count; i++ {
+		if i == 0 {
+			t, err := r.readBits(64)
+			if err != nil {
+				return nil, err
+			}
+			if value, err = r.readBits(64); err != nil {
+				return nil, err
+			}
+			last = int64(t)
+		} else {
+			dod, err := readDoD(r)
+			if err != nil {
+				return nil, err
+			}
+			delta += dod
+			last += delta
+			if value, leading, trailing, err = readValue(r, value, leading, trailing); err != nil {
+				return nil, err
+			}
+		}
+		ok, err := r.readBit()
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, point{t: last, v: math.Float64frombits(value), ok: ok})
+	}
+	return points, nil
+}
+
+// readDoD 读取时间戳的二阶差分
+func readDoD(r *bitReader) (int64, error) {
+	class := 0
+	for class < len(dodWidths)-1 {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if !bit {
+			break
+		}
+		class++
+	}
+	if class == 0 {
+		return 0, nil
+	}
+	width := dodWidths[class]
+	raw, err := r.readBits(width)
+	if err != nil {
+		return 0, err
+	}
+	if width == 64 {
+		return int64(raw), nil
+	}
+	// 按width位的补码还原负数
+	if raw > 1<<(width-1) {
+		return int64(raw) - 1<<width, nil
+	}
+	return int64(raw), nil
+}
+
+// readValue 读取数值，返回新的位表示和异或值的前导零、末尾零位数
+func readValue(r *bitReader, value uint64, leading, trailing int) (uint64, int, int, error) {
+	changed, err := r.readBit()
+	if err != nil || !changed {
+		return value, leading, trailing, err
+	}
+	newWindow, err := r.readBit()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if newWindow {
+		l, err := r.readBits(5)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		m, err := r.readBits(6)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		if m == 0 {
+			m = 64
+		}
+		leading = int(l)
+		trailing = 64 - leading - int(m)
+		if trailing < 0 {
+			return 0, 0, 0, fmt.Errorf("块数据损坏: 有效位数%d", m)
+		}
+	}
+	xor, err := r.readBits(64 - leading - trailing)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return value ^ xor<<trailing, leading, trailing, nil
+}
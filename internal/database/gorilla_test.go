@@ -0,0 +1,133 @@
+package database
+
+import (
+	"math"
+	"testing"
+)
+
+// roundTrip 压缩后解压，检查每个数据点（数值按位比较）
+func roundTrip(t *testing.T, name string, points []point) []byte {
+	t.Helper()
+	encoder := &chunkEncoder{}
+	for _, p := range points {
+		encoder.append(p)
+	}
+	data := encoder.encode()
+
+	decoded, err := decodeChunk(data)
+	if err != nil {
+		t.Fatalf("%s: 解压失败: %v", name, err)
+	}
+	if len(decoded) != len(points) {
+		t.Fatalf("%s: 解压得到%d个数据点，应为%d个", name, len(decoded), len(points))
+	}
+	for i, p := range points {
+		got := decoded[i]
+		if got.t != p.t || math.Float64bits(got.v) != math.Float64bits(p.v) || got.ok != p.ok {
+			t.Fatalf("%s: 第%d个数据点为%+v，应为%+v", name, i, got, p)
+		}
+	}
+
+	header, err := parseChunkHeader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.count != len(points) || header.min != encoder.min || header.max != encoder.max {
+		t.Fatalf("%s: 块文件头%+v与数据不符", name, header)
+	}
+	return data
+}
+
+func TestGorillaDoDBoundaries(t *testing.T) {
+	// 各宽度可表示的范围为[-2^(w-1)+1, 2^(w-1)]，边界两侧的值分别落在本级和下一级
+	var dods []int64
+	for _, width := range dodWidths[1 : len(dodWidths)-1] {
+		half := int64(1) << (width - 1)
+		dods = append(dods, half, half+1, -half+1, -half, -half-1)
+	}
+	dods = append(dods, 0, 1, -1, math.MaxInt32, math.MinInt32, 1<<40, -(1 << 40))
+
+	for _, dod := range dods {
+		// 前两个点确定间隔，第三个点的二阶差分为dod
+		base := int64(1_700_000_000_000)
+		points := []point{
+			{t: base, v: 1, ok: true},
+			{t: base + 1000, v: 1, ok: true},
+			{t: base + 2000 + dod, v: 1, ok: false},
+			{t: base + 3000 + dod, v: 1, ok: true},
+		}
+		roundTrip(t, "dod", points)
+	}
+}
+
+func TestGorillaTimestamps(t *testing.T) {
+	base := int64(1_700_000_000_000)
+
+	// 固定间隔采样时二阶差分为0，每个时间戳只占1位
+	var regular []point
+	for i := 0; i < 1000; i++ {
+		regular = append(regular, point{t: base + int64(i)*1000, v: 12.5, ok: true})
+	}
+	data := roundTrip(t, "固定间隔", regular)
+	if size := len(data) - chunkHeaderSize; size > 1000*3/8+32 {
+		t.Errorf("固定间隔、数值不变的1000个数据点占用%d字节", size)
+	}
+
+	// 乱序写入（合并目标、时钟回拨）时间戳可以小于上一个数据点
+	outOfOrder := []point{
+		{t: base, v: 1, ok: true},
+		{t: base - 5000, v: 2, ok: true},
+		{t: base + 60_000, v: 3, ok: false},
+		{t: base + 60_000, v: 3, ok: true},
+		{t: base - 3_600_000, v: 4, ok: true},
+		{t: 0, v: 5, ok: true},
+		{t: math.MaxInt64 / 2, v: 6, ok: true},
+	}
+	roundTrip(t, "乱序", outOfOrder)
+}
+
+func TestGorillaValues(t *testing.T) {
+	base := int64(1_700_000_000_000)
+	values := []float64{
+		// 有效位落在上一个异或值的范围内，沿用其位置
+		10.0, 10.5, 10.25, 10.75, 10.5,
+		// 有效位为64位：最高位和最低位都不同
+		math.Float64frombits(0x8000000000000001), 0,
+		// 前导零超过31位时按31位记录
+		math.Float64frombits(1), math.Float64frombits(3), math.Float64frombits(2),
+		// 特殊值
+		math.Inf(1), math.Inf(-1), math.NaN(), math.Copysign(0, -1), math.MaxFloat64, math.SmallestNonzeroFloat64,
+		0.1, 0.2, 0.30000000000000004, 1e-300, 1e300,
+	}
+	var points []point
+	for i, v := range values {
+		points = append(points, point{t: base + int64(i)*1000, v: v, ok: i%3 != 0})
+	}
+	roundTrip(t, "数值", points)
+
+	// 第一个数据点保存原始的时间戳和数值，加上成功标志共129位
+	single := roundTrip(t, "单个数据点", points[:1])
+	if len(single) != chunkHeaderSize+17 {
+		t.Errorf("单个数据点的块为%d字节，应为%d字节", len(single), chunkHeaderSize+17)
+	}
+}
+
+func TestGorillaCorruption(t *testing.T) {
+	encoder := &chunkEncoder{}
+	for i := 0; i < 10; i++ {
+		encoder.append(point{t: int64(i) * 1000, v: float64(i), ok: true})
+	}
+	data := encoder.encode()
+
+	corrupted := append([]byte(nil), data...)
+	corrupted[len(corrupted)-1] ^= 0xff
+	if _, err := decodeChunk(corrupted); err == nil {
+		t.Error("数据被修改时应校验失败")
+	}
+	if _, err := decodeChunk(data[:chunkHeaderSize-1]); err == nil {
+		t.Error("文件头不完整时应返回错误")
+	}
+	if _, err := decodeChunk(append([]byte("XXXX"), data[4:]...)); err == nil {
+		t.Error("魔数不符时应返回错误")
+	}
+}
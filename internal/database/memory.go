@@ -0,0 +1,765 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"scallop/internal/models"
+)
+
+// DefaultMemoryCapacity 内存存储中每个目标默认保留的结果数
+const DefaultMemoryCapacity = 10000
+
+// ringMinGrowth 环形缓冲区第一次分配的元素数
+const ringMinGrowth = 16
+
+// ring 固定容量的环形缓冲区，写满后覆盖最早的元素
+// 存储空间随元素增加按倍数分配，直到容量上限，数据很少的目标不会占用整个容量的内存
+type ring[T any] struct {
+	items    []T
+	start    int // 最早元素的位置
+	size     int
+	capacity int
+}
+
+func newRing[T any](capacity int) *ring[T] {
+	return &ring[T]{capacity: capacity}
+}
+
+// push 追加一个元素，缓冲区已满时覆盖最早的元素
+func (r *ring[T]) push(item T) {
+	if r.size < r.capacity {
+		if r.size == len(r.items) {
+			r.grow()
+		}
+		r.items[(r.start+r.size)%len(r.items)] = item
+		r.size++
+		return
+	}
+	r.items[r.start] = item
+	r.start = (r.start + 1) % len(r.items)
+}
+
+// grow 扩大存储空间，元素按写入顺序移到开头
+func (r *ring[T]) grow() {
+	items := make([]T, min(max(2*len(r.items), ringMinGrowth), r.capacity))
+	for i := 0; i < r.size; i++ {
+		items[i] = r.items[(r.start+i)%len(r.items)]
+	}
+	r.items, r.start = items, 0
+}
+
+// list 按写入顺序返回全部元素
+func (r *ring[T]) list() []T {
+	items := make([]T, 0, r.size)
+	for i := 0; i < r.size; i++ {
+		items = append(items, r.items[(r.start+i)%len(r.items)])
+	}
+	return items
+}
+
+// dropWhile 从最早的元素开始删除满足条件的元素，遇到第一个不满足的停止，返回删除的数量
+func (r *ring[T]) dropWhile(match func(T) bool) int64 {
+	var zero T
+	var dropped int64
+	for r.size > 0 && match(r.items[r.start]) {
+		r.items[r.start] = zero
+		r.start = (r.start + 1) % len(r.items)
+		r.size--
+		dropped++
+	}
+	return dropped
+}
+
+// memoryInfo 目标的一份探测详情
+type memoryInfo struct {
+	Data      json.RawMessage `json:"data"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// memoryTarget 目标在内存中的全部数据，每类数据各用一个环形缓冲区，附加指标每个名称一个
+// 各指标分别保留capacity条，产生多个指标的探测不会挤占其他指标；内存占用随目标的指标数增加，
+// 而每种探测类型产生的指标数是固定的少数几个
+type memoryTarget struct {
+	target    *models.Target
+	results   *ring[models.PingResult]
+	metrics   map[string]*ring[models.Metric]
+	events    *ring[models.Event]
+	addresses *ring[models.ResolvedAddress]
+	info      map[string]memoryInfo
+}
+
+// MemoryStore 内存存储，不写磁盘，用于临时诊断和CI中的网络检查
+// 每个目标的结果、每个附加指标、事件和解析地址各保留最近capacity条，汇总在查询时由原始结果计算
+type MemoryStore struct {
+	mutex     sync.RWMutex
+	capacity  int
+	targets   map[string]*memoryTarget
+	rum       *ring[models.RUMSample]
+	nextID    int
+	snapshot  string                             // 退出时保存快照的文件，为空时不保存
+	lastPurge atomic.Pointer[models.PurgeResult] // 最近一次过期数据清理的结果
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemory 创建内存存储，snapshot不为空时从该文件恢复上次退出时保存的数据，并在关闭时写回
+func NewMemory(capacity int, snapshot string) (*MemoryStore, error) {
+	if capacity <= 0 {
+		capacity = DefaultMemoryCapacity
+	}
+	store := &MemoryStore{
+		capacity: capacity,
+		targets:  make(map[string]*memoryTarget),
+		rum:      newRing[models.RUMSample](capacity),
+		snapshot: snapshot,
+	}
+	if snapshot != "" {
+		if err := store.loadSnapshot(); err != nil {
+			return nil, fmt.Errorf("读取快照失败: %v", err)
+		}
+	}
+	return store, nil
+}
+
+// memorySnapshot 快照文件的内容
+type memorySnapshot struct {
+	SavedAt   time.Time                           `json:"saved_at"`
+	Targets   []snapshotTarget                    `json:"targets"`
+	Results   map[string][]models.PingResult      `json:"results"`
+	Metrics   map[string][]models.Metric          `json:"metrics"`
+	Events    map[string][]models.Event           `json:"events"`
+	Addresses map[string][]models.ResolvedAddress `json:"addresses"`
+	Info      map[string]map[string]memoryInfo    `json:"info"`
+	RUM       []models.RUMSample                  `json:"rum"`
+}
+
+// snapshotTarget 快照中的目标，同时保存对应的配置项
+type snapshotTarget struct {
+	models.Target
+	Spec models.IPTarget `json:"spec"`
+}
+
+// loadSnapshot 从快照恢复数据，文件不存在时不做处理
+func (s *MemoryStore) loadSnapshot() error {
+	data, err := os.ReadFile(s.snapshot)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var snapshot memorySnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return err
+	}
+
+	for _, item := range snapshot.Targets {
+		target := item.Target
+		target.Spec = item.Spec
+		entry := s.entry(&target)
+		for _, result := range snapshot.Results[target.ID] {
+			if result.ID > s.nextID {
+				s.nextID = result.ID
+			}
+			entry.results.push(result)
+		}
+		for _, metric := range snapshot.Metrics[target.ID] {
+			entry.metric(metric.Name, s.capacity).push(metric)
+		}
+		for _, event := range snapshot.Events[target.ID] {
+			if event.ID > s.nextID {
+				s.nextID = event.ID
+			}
+			entry.events.push(event)
+		}
+		for _, addr := range snapshot.Addresses[target.ID] {
+			entry.addresses.push(addr)
+		}
+		for kind, info := range snapshot.Info[target.ID] {
+			entry.info[kind] = info
+		}
+	}
+	for _, sample := range snapshot.RUM {
+		if sample.ID > s.nextID {
+			s.nextID = sample.ID
+		}
+		s.rum.push(sample)
+	}
+	fmt.Printf("已从快照 %s 恢复 %d 个目标的数据（保存于 %s）\n", s.snapshot, len(snapshot.Targets),
+		snapshot.SavedAt.Local().Format("2006-01-02 15:04:05"))
+	return nil
+}
+
+// saveSnapshot 将全部数据写入快照，先写临时文件再替换，避免写到一半时留下损坏的快照
+func (s *MemoryStore) saveSnapshot() error {
+	s.mutex.RLock()
+	snapshot := memorySnapshot{
+		SavedAt:   time.Now(),
+		Results:   make(map[string][]models.PingResult),
+		Metrics:   make(map[string][]models.Metric),
+		Events:    make(map[string][]models.Event),
+		Addresses: make(map[string][]models.ResolvedAddress),
+		Info:      make(map[string]map[string]memoryInfo),
+		RUM:       s.rum.list(),
+	}
+	for id, entry := range s.targets {
+		snapshot.Targets = append(snapshot.Targets, snapshotTarget{Target: *entry.target, Spec: entry.target.Spec})
+		snapshot.Results[id] = entry.results.list()
+		snapshot.Metrics[id] = entry.metricList("")
+		snapshot.Events[id] = entry.events.list()
+		snapshot.Addresses[id] = entry.addresses.list()
+		snapshot.Info[id] = entry.info
+	}
+	data, err := json.Marshal(snapshot)
+	s.mutex.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.snapshot), filepath.Base(s.snapshot)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.snapshot); err != nil {
+		return err
+	}
+	fmt.Printf("已将 %d 个目标的数据保存到快照 %s\n", len(snapshot.Targets), s.snapshot)
+	return nil
+}
+
+// Close 配置了快照文件时保存快照
+func (s *MemoryStore) Close() error {
+	if s.snapshot == "" {
+		return nil
+	}
+	return s.saveSnapshot()
+}
+
+// entry 获取目标的数据，不存在时创建，调用方需持有写锁
+func (s *MemoryStore) entry(target *models.Target) *memoryTarget {
+	entry, ok := s.targets[target.ID]
+	if !ok {
+		entry = &memoryTarget{
+			results:   newRing[models.PingResult](s.capacity),
+			metrics:   make(map[string]*ring[models.Metric]),
+			events:    newRing[models.Event](s.capacity),
+			addresses: newRing[models.ResolvedAddress](s.capacity),
+			info:      make(map[string]memoryInfo),
+		}
+		s.targets[target.ID] = entry
+	}
+	entry.target = target
+	return entry
+}
+
+// metric 获取附加指标的缓冲区，不存在时创建，调用方需持有写锁
+func (e *memoryTarget) metric(name string, capacity int) *ring[models.Metric] {
+	metrics, ok := e.metrics[name]
+	if !ok {
+		metrics = newRing[models.Metric](capacity)
+		e.metrics[name] = metrics
+	}
+	return metrics
+}
+
+// metricList 按时间顺序返回附加指标，name为空时返回全部指标
+func (e *memoryTarget) metricList(name string) []models.Metric {
+	metrics := []models.Metric{}
+	for metricName, buffer := range e.metrics {
+		if name == "" || metricName == name {
+			metrics = append(metrics, buffer.list()...)
+		}
+	}
+	sort.SliceStable(metrics, func(i, j int) bool {
+		if !metrics[i].Timestamp.Equal(metrics[j].Timestamp) {
+			return metrics[i].Timestamp.Before(metrics[j].Timestamp)
+		}
+		return metrics[i].Name < metrics[j].Name
+	})
+	return metrics
+}
+
+// SyncTargets 将配置中的目标同步到内存，按配置顺序返回对应的目标
+func (s *MemoryStore) SyncTargets(configTargets []models.IPTarget) ([]*models.Target, error) {
+	return syncTargets(s, configTargets)
+}
+
+// SaveTarget 保存目标
+func (s *MemoryStore) SaveTarget(target *models.Target) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	copied := *target
+	s.entry(&copied)
+	return nil
+}
+
+// LoadTargets 加载全部目标，返回的目标是副本
+func (s *MemoryStore) LoadTargets() (map[string]*models.Target, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	targets := make(map[string]*models.Target, len(s.targets))
+	for id, entry := range s.targets {
+		target := *entry.target
+		targets[id] = &target
+	}
+	return targets, nil
+}
+
+// GetTarget 获取单个目标，不存在时返回sql.ErrNoRows
+func (s *MemoryStore) GetTarget(id string) (*models.Target, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	entry, ok := s.targets[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	target := *entry.target
+	return &target, nil
+}
+
+// MergeTarget 将目标from的数据合并到目标to并删除目标from，合并后每类数据仍只保留最近capacity条
+// 两个目标都有同类详情时保留to的
+func (s *MemoryStore) MergeTarget(from, to string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	source, ok := s.targets[from]
+	if !ok {
+		return nil
+	}
+	dest, ok := s.targets[to]
+	if !ok {
+		return fmt.Errorf("目标 %s 不存在", to)
+	}
+
+	dest.results = mergeRings(s.capacity, source.results, dest.results, func(r *models.PingResult) *time.Time {
+		r.TargetID = to
+		return &r.Timestamp
+	})
+	for name, metrics := range source.metrics {
+		dest.metrics[name] = mergeRings(s.capacity, metrics, dest.metric(name, s.capacity), func(m *models.Metric) *time.Time {
+			m.TargetID = to
+			return &m.Timestamp
+		})
+	}
+	dest.events = mergeRings(s.capacity, source.events, dest.events, func(e *models.Event) *time.Time {
+		e.TargetID = to
+		return &e.Timestamp
+	})
+	dest.addresses = mergeRings(s.capacity, source.addresses, dest.addresses, func(a *models.ResolvedAddress) *time.Time {
+		a.TargetID = to
+		return &a.Timestamp
+	})
+	for kind, info := range source.info {
+		if _, ok := dest.info[kind]; !ok {
+			dest.info[kind] = info
+		}
+	}
+	delete(s.targets, from)
+	return nil
+}
+
+// mergeRings 按时间合并两个缓冲区，retarget修改记录的目标ID并返回记录的时间
+func mergeRings[T any](capacity int, from, to *ring[T], retarget func(*T) *time.Time) *ring[T] {
+	items := append(from.list(), to.list()...)
+	for i := range items {
+		retarget(&items[i])
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return retarget(&items[i]).Before(*retarget(&items[j]))
+	})
+
+	merged := newRing[T](capacity)
+	for _, item := range items {
+		merged.push(item)
+	}
+	return merged
+}
+
+// GetRetiredTargets 获取已从配置中移除、但仍保留在内存中的目标，最近仍有结果的在前
+func (s *MemoryStore) GetRetiredTargets(active map[string]bool) ([]models.RetiredTarget, error) {
+	return retiredTargets(s, active)
+}
+
+// GetRetiredTarget 获取单个已移除的目标，目标仍在配置中或不存在时返回sql.ErrNoRows
+func (s *MemoryStore) GetRetiredTarget(id string, active map[string]bool) (models.RetiredTarget, error) {
+	return getRetiredTarget(s, id, active)
+}
+
+// resultSpan 统计目标的结果数量和首末结果时间
+func (s *MemoryStore) resultSpan(targetID string) (count int, first, last time.Time, err error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	entry, ok := s.targets[targetID]
+	if !ok {
+		return 0, first, last, nil
+	}
+	for i, result := range entry.results.list() {
+		if i == 0 || result.Timestamp.Before(first) {
+			first = result.Timestamp
+		}
+		if i == 0 || result.Timestamp.After(last) {
+			last = result.Timestamp
+		}
+		count++
+	}
+	return count, first, last, nil
+}
+
+// PurgeTarget 删除目标及其全部数据
+func (s *MemoryStore) PurgeTarget(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.targets[id]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(s.targets, id)
+	return nil
+}
+
+// SavePingResult 保存Ping结果，目标不存在时忽略
+func (s *MemoryStore) SavePingResult(result models.PingResult) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry, ok := s.targets[result.TargetID]
+	if !ok {
+		return nil
+	}
+	s.nextID++
+	result.ID = s.nextID
+	entry.results.push(result)
+	return nil
+}
+
+// GetPingResults 查询指定目标在时间范围内的Ping结果
+func (s *MemoryStore) GetPingResults(targetID string, since, until time.Time) ([]models.PingResult, error) {
+	results := []models.PingResult{}
+	for _, result := range s.results(targetID) {
+		if !result.Timestamp.Before(since) && !result.Timestamp.After(until) {
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+// results 目标的全部结果，按时间排序
+func (s *MemoryStore) results(targetID string) []models.PingResult {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	entry, ok := s.targets[targetID]
+	if !ok {
+		return nil
+	}
+	// 并发的探测可能以与时间不同的顺序写入
+	results := entry.results.list()
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Timestamp.Before(results[j].Timestamp)
+	})
+	return results
+}
+
+// GetLatestResults 获取每个目标最新的一条Ping结果
+func (s *MemoryStore) GetLatestResults() ([]models.PingResult, error) {
+	s.mutex.RLock()
+	ids := make([]string, 0, len(s.targets))
+	for id := range s.targets {
+		ids = append(ids, id)
+	}
+	s.mutex.RUnlock()
+
+	results := []models.PingResult{}
+	for _, id := range ids {
+		if items := s.results(id); len(items) > 0 {
+			results = append(results, items[len(items)-1])
+		}
+	}
+	return results, nil
+}
+
+// GetLastSuccess 获取目标最近一次成功结果的时间，没有结果时返回零值
+func (s *MemoryStore) GetLastSuccess(targetID string) (time.Time, error) {
+	results := s.results(targetID)
+	for i := len(results) - 1; i >= 0; i-- {
+		if results[i].Success {
+			return results[i].Timestamp, nil
+		}
+	}
+	return time.Time{}, nil
+}
+
+// GetRollups 由内存中的原始结果计算目标在时间范围内按step汇总的结果，时间段的划分与数据库存储相同
+func (s *MemoryStore) GetRollups(targetID string, since, until time.Time, step time.Duration) ([]models.Rollup, error) {
+	tier := RollupTier(step)
+	if tier == 0 {
+		return nil, fmt.Errorf("时间分辨率不能小于%d秒", RollupMinute)
+	}
+	width := int64(RollupWidth(step))
+	origin := bucketStart(since, tier).Unix()
+
+	var starts []int64
+	groups := make(map[int64]*rollup)
+	for _, result := range s.results(targetID) {
+		bucket := bucketStart(result.Timestamp, tier).Unix()
+		if bucket < origin || bucket > until.Unix() {
+			continue
+		}
+		start := origin + (bucket-origin)/width*width
+		group, ok := groups[start]
+		if !ok {
+			group = newRollup()
+			groups[start] = group
+			starts = append(starts, start)
+		}
+		group.add(result.Latency, result.Success)
+	}
+
+	results := make([]models.Rollup, 0, len(starts))
+	for _, start := range starts {
+		results = append(results, groups[start].result(targetID, time.Unix(start, 0), int(width)))
+	}
+	return results, nil
+}
+
+// SaveMetrics 保存一次探测产生的附加指标
+func (s *MemoryStore) SaveMetrics(targetID string, timestamp time.Time, metrics map[string]float64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry, ok := s.targets[targetID]
+	if !ok {
+		return nil
+	}
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		entry.metric(name, s.capacity).push(models.Metric{TargetID: targetID, Name: name, Value: metrics[name], Timestamp: timestamp})
+	}
+	return nil
+}
+
+// GetMetrics 查询指定目标在时间范围内的附加指标，name为空时返回全部指标
+func (s *MemoryStore) GetMetrics(targetID, name string, since, until time.Time) ([]models.Metric, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	metrics := []models.Metric{}
+	entry, ok := s.targets[targetID]
+	if !ok {
+		return metrics, nil
+	}
+	for _, metric := range entry.metricList(name) {
+		if !metric.Timestamp.Before(since) && !metric.Timestamp.After(until) {
+			metrics = append(metrics, metric)
+		}
+	}
+	return metrics, nil
+}
+
+// SaveEvent 保存目标事件
+func (s *MemoryStore) SaveEvent(event models.Event) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry, ok := s.targets[event.TargetID]
+	if !ok {
+		return nil
+	}
+	s.nextID++
+	event.ID = s.nextID
+	entry.events.push(event)
+	return nil
+}
+
+// GetEvents 查询时间范围内的事件，targetID为空时返回所有目标的事件
+func (s *MemoryStore) GetEvents(targetID string, since, until time.Time) ([]models.Event, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	events := []models.Event{}
+	for id, entry := range s.targets {
+		if targetID != "" && id != targetID {
+			continue
+		}
+		for _, event := range entry.events.list() {
+			if !event.Timestamp.Before(since) && !event.Timestamp.After(until) {
+				events = append(events, event)
+			}
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Timestamp.Before(events[j].Timestamp)
+	})
+	return events, nil
+}
+
+// SaveTargetInfo 保存目标的最新探测详情，同一类别只保留最新一份
+func (s *MemoryStore) SaveTargetInfo(targetID, kind string, data interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry, ok := s.targets[targetID]
+	if !ok {
+		return nil
+	}
+	entry.info[kind] = memoryInfo{Data: encoded, UpdatedAt: time.Now()}
+	return nil
+}
+
+// GetTargetInfo 获取目标的最新探测详情，不存在时返回sql.ErrNoRows
+func (s *MemoryStore) GetTargetInfo(targetID, kind string) (json.RawMessage, time.Time, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	entry, ok := s.targets[targetID]
+	if !ok {
+		return nil, time.Time{}, sql.ErrNoRows
+	}
+	info, ok := entry.info[kind]
+	if !ok {
+		return nil, time.Time{}, sql.ErrNoRows
+	}
+	return info.Data, info.UpdatedAt, nil
+}
+
+// SaveResolvedAddress 保存目标解析到的新地址
+func (s *MemoryStore) SaveResolvedAddress(addr models.ResolvedAddress) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if entry, ok := s.targets[addr.TargetID]; ok {
+		entry.addresses.push(addr)
+	}
+	return nil
+}
+
+// GetLatestAddresses 获取每个目标最近一次解析到的地址，key为目标ID
+func (s *MemoryStore) GetLatestAddresses() (map[string]models.ResolvedAddress, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	addresses := make(map[string]models.ResolvedAddress)
+	for id, entry := range s.targets {
+		if list := entry.addresses.list(); len(list) > 0 {
+			addresses[id] = list[len(list)-1]
+		}
+	}
+	return addresses, nil
+}
+
+// GetAddressHistory 获取目标在时间范围内的地址变化记录
+func (s *MemoryStore) GetAddressHistory(targetID string, since, until time.Time) ([]models.ResolvedAddress, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	addresses := []models.ResolvedAddress{}
+	entry, ok := s.targets[targetID]
+	if !ok {
+		return addresses, nil
+	}
+	for _, addr := range entry.addresses.list() {
+		if !addr.Timestamp.Before(since) && !addr.Timestamp.After(until) {
+			addresses = append(addresses, addr)
+		}
+	}
+	return addresses, nil
+}
+
+// SaveRUMSample 保存一条访客延迟样本
+func (s *MemoryStore) SaveRUMSample(sample models.RUMSample) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.nextID++
+	sample.ID = s.nextID
+	s.rum.push(sample)
+	return nil
+}
+
+// GetRUMSamples 查询时间范围内的访客延迟样本
+func (s *MemoryStore) GetRUMSamples(since, until time.Time) ([]models.RUMSample, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	samples := []models.RUMSample{}
+	for _, sample := range s.rum.list() {
+		if !sample.Timestamp.Before(since) && !sample.Timestamp.After(until) {
+			samples = append(samples, sample)
+		}
+	}
+	return samples, nil
+}
+
+// PurgeExpired 按保留策略删除过期的Ping结果和附加指标；汇总在查询时计算，不需要清理
+func (s *MemoryStore) PurgeExpired(ctx context.Context, policy RetentionPolicy) (models.PurgeResult, error) {
+	result := models.PurgeResult{StartedAt: time.Now()}
+
+	s.mutex.Lock()
+	for id, entry := range s.targets {
+		retention, ok := policy.Targets[id]
+		if !ok {
+			retention = policy.Default
+		}
+		if retention <= 0 {
+			continue
+		}
+		cutoff := result.StartedAt.Add(-retention)
+		result.ResultsDeleted += entry.results.dropWhile(func(r models.PingResult) bool { return r.Timestamp.Before(cutoff) })
+		for _, metrics := range entry.metrics {
+			result.MetricsDeleted += metrics.dropWhile(func(m models.Metric) bool { return m.Timestamp.Before(cutoff) })
+		}
+	}
+	s.mutex.Unlock()
+
+	result.Duration = float64(time.Since(result.StartedAt).Microseconds()) / 1000
+	s.lastPurge.Store(&result)
+	return result, nil
+}
+
+// Stats 内存中最早的结果时间和最近一次清理的结果
+func (s *MemoryStore) Stats() (models.DatabaseStats, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	stats := models.DatabaseStats{Backend: "memory", LastPurge: s.lastPurge.Load()}
+	for _, entry := range s.targets {
+		for _, result := range entry.results.list() {
+			if stats.OldestResult.IsZero() || result.Timestamp.Before(stats.OldestResult) {
+				stats.OldestResult = result.Timestamp
+			}
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"scallop/internal/models"
+)
+
+func TestRing(t *testing.T) {
+	r := newRing[int](40)
+	if len(r.items) != 0 {
+		t.Fatalf("新建的缓冲区已分配%d个元素", len(r.items))
+	}
+
+	// 存储空间按倍数增加，不超过容量
+	for i := 0; i < 20; i++ {
+		r.push(i)
+	}
+	if len(r.items) != 32 || r.size != 20 {
+		t.Fatalf("写入20个元素后分配了%d个、保存了%d个", len(r.items), r.size)
+	}
+
+	// 删除开头的元素后再写入，扩大存储空间时保持写入顺序
+	if dropped := r.dropWhile(func(i int) bool { return i < 10 }); dropped != 10 {
+		t.Fatalf("删除了%d个元素，应为10个", dropped)
+	}
+	for i := 20; i < 50; i++ {
+		r.push(i)
+	}
+	if len(r.items) != 40 || r.size != 40 {
+		t.Fatalf("写入后分配了%d个、保存了%d个，应都为40个", len(r.items), r.size)
+	}
+	items := r.list()
+	for i, item := range items {
+		if item != i+10 {
+			t.Fatalf("第%d个元素为%d，应为%d: %v", i, item, i+10, items)
+		}
+	}
+
+	// 写满后覆盖最早的元素
+	for i := 50; i < 55; i++ {
+		r.push(i)
+	}
+	items = r.list()
+	if len(items) != 40 || items[0] != 15 || items[39] != 54 {
+		t.Fatalf("写满后的元素不正确: %v", items)
+	}
+}
+
+func TestMemoryMetricCapacity(t *testing.T) {
+	store, err := NewMemory(5, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.SyncTargets([]models.IPTarget{{ID: "t", Addr: "1.1.1.1", Description: "T"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 频繁产生的指标不会挤掉其他指标
+	start := time.Now().Add(-time.Hour)
+	store.SaveMetrics("t", start, map[string]float64{"rare": 1})
+	for i := 0; i < 20; i++ {
+		store.SaveMetrics("t", start.Add(time.Duration(i+1)*time.Second), map[string]float64{"noisy": float64(i)})
+	}
+
+	metrics, err := store.GetMetrics("t", "", start, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics) != 6 || metrics[0].Name != "rare" || metrics[1].Value != 15 {
+		t.Fatalf("附加指标不正确: %+v", metrics)
+	}
+	rare, err := store.GetMetrics("t", "rare", start, time.Now())
+	if err != nil || len(rare) != 1 {
+		t.Fatalf("rare指标: %+v %v", rare, err)
+	}
+}
+
+func TestMemoryPurgeStats(t *testing.T) {
+	store, err := NewMemory(100, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := RetentionPolicy{Default: time.Hour}
+
+	// 清理和读取统计同时进行，-race下不应报告数据竞争
+	start, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		<-start
+		for i := 0; i < 1000; i++ {
+			store.PurgeExpired(context.Background(), policy)
+		}
+	}()
+	close(start)
+	for i := 0; i < 1000; i++ {
+		stats, err := store.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := json.Marshal(stats); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+
+	// 统计中的结果与PurgeExpired返回的相同，包括耗时
+	result, err := store.PurgeExpired(context.Background(), policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, _ := store.Stats()
+	if stats.LastPurge == nil || *stats.LastPurge != result {
+		t.Fatalf("最近一次清理为%+v，应为%+v", stats.LastPurge, result)
+	}
+}
@@ -0,0 +1,192 @@
+package database
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationFiles 按版本号命名的迁移脚本，如 0002_add_column.sql，只增不改
+// PostgreSQL的迁移单独放在migrations/postgres中
+//
+//go:embed migrations/*.sql migrations/postgres/*.sql
+var migrationFiles embed.FS
+
+// createVersionSQL 记录已应用迁移的表，与第一个迁移在同一事务中创建
+const createVersionSQL = `
+CREATE TABLE IF NOT EXISTS schema_version (
+	version INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	applied_at DATETIME NOT NULL
+);`
+
+// Migration 一个数据库迁移
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// Migrations 程序内置的全部SQLite迁移，按版本号排列
+func Migrations() ([]Migration, error) {
+	return loadMigrations(migrationFiles, "migrations")
+}
+
+// loadMigrations 读取files中dir目录下的迁移，按版本号排列
+func loadMigrations(files fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(files, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]Migration, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		number, title, ok := strings.Cut(name, "_")
+		version, err := strconv.Atoi(number)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("迁移文件名无效: %s", entry.Name())
+		}
+		data, err := fs.ReadFile(files, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: title, SQL: string(data)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			return nil, fmt.Errorf("迁移版本号不连续: %s", migration)
+		}
+	}
+	return migrations, nil
+}
+
+// SchemaVersion 数据库当前的结构版本，未执行过迁移时为0
+func (db *DB) SchemaVersion() (int, error) {
+	return schemaVersion(db.conn)
+}
+
+// queryer 数据库连接和事务共有的查询方法
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func schemaVersion(q queryer) (int, error) {
+	var version int
+	err := q.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	if err != nil && strings.Contains(err.Error(), "no such table") {
+		return 0, nil
+	}
+	return version, err
+}
+
+// Migrate 依次应用未执行的迁移，返回应用的迁移
+// 每个迁移和版本记录在同一事务中提交，失败时数据库保持在上一个版本；
+// dryRun为true时在一个事务中执行全部迁移后回滚，用于提前发现迁移失败
+// 数据库的结构版本高于程序内置的迁移时（被更新版本的Scallop使用过）返回错误，避免旧程序写坏数据
+func (db *DB) Migrate(dryRun bool) ([]Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	current, err := schemaVersion(db.conn)
+	if err != nil {
+		return nil, fmt.Errorf("读取数据库结构版本失败: %v", err)
+	}
+	if current > len(migrations) {
+		return nil, fmt.Errorf("数据库结构版本(%d)高于当前程序支持的版本(%d)，请升级Scallop后再使用该数据库", current, len(migrations))
+	}
+	pending := migrations[current:]
+	if len(pending) == 0 {
+		return nil, nil
+	}
+
+	var tx *sql.Tx
+	applied := []Migration{}
+	for _, migration := range pending {
+		if tx == nil {
+			if tx, err = db.conn.Begin(); err != nil {
+				return applied, err
+			}
+		}
+		if err := applyMigration(tx, migration); err != nil {
+			tx.Rollback()
+			return applied, fmt.Errorf("数据库迁移 %s 失败: %v", migration, err)
+		}
+		applied = append(applied, migration)
+
+		// 预演时所有迁移在同一事务中执行，后面的迁移可以看到前面的结果
+		if dryRun {
+			continue
+		}
+		if err := tx.Commit(); err != nil {
+			return applied[:len(applied)-1], fmt.Errorf("提交数据库迁移 %s 失败: %v", migration, err)
+		}
+		tx = nil
+	}
+	if dryRun {
+		tx.Rollback()
+	}
+	return applied, nil
+}
+
+// applyMigration 在事务中执行一个迁移并记录版本
+func applyMigration(tx *sql.Tx, migration Migration) error {
+	if _, err := tx.Exec(createVersionSQL); err != nil {
+		return err
+	}
+	if migration.Version == 1 {
+		if err := adoptLegacySchema(tx); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(migration.SQL); err != nil {
+		return err
+	}
+	_, err := tx.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)",
+		migration.Version, migration.Name, time.Now())
+	return err
+}
+
+// adoptLegacySchema 引入版本号之前的数据库由启动时的 CREATE TABLE IF NOT EXISTS 创建，
+// 初始迁移不会修改已有的表，因此先补上之后才添加的列
+func adoptLegacySchema(tx *sql.Tx) error {
+	return addColumn(tx, "targets", "spec", "TEXT DEFAULT ''")
+}
+
+// addColumn 表存在且没有该列时添加
+func addColumn(q queryer, table, column, definition string) error {
+	var tables, columns int
+	if err := q.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&tables); err != nil {
+		return err
+	}
+	query := "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?"
+	if err := q.QueryRow(query, table, column).Scan(&columns); err != nil {
+		return err
+	}
+	if tables == 0 || columns > 0 {
+		return nil
+	}
+	_, err := q.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
@@ -0,0 +1,183 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// openLegacy 复制testdata/legacy.db到临时目录后打开，不执行迁移
+// legacy.db由引入版本号之前的程序创建：targets表没有spec列，也没有schema_version表
+func openLegacy(t *testing.T) *DB {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "legacy.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "scallop.db")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func hasColumn(t *testing.T, db *DB, table, column string) bool {
+	t.Helper()
+	var count int
+	err := db.conn.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count > 0
+}
+
+func tableNames(t *testing.T, db *DB) string {
+	t.Helper()
+	rows, err := db.conn.Query("SELECT name FROM sqlite_master WHERE type = 'table' ORDER BY name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestMigrateLegacySchema(t *testing.T) {
+	db := openLegacy(t)
+	if hasColumn(t, db, "targets", "spec") {
+		t.Fatal("legacy.db 不应包含spec列")
+	}
+
+	applied, err := db.Migrate(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(migrations) {
+		t.Fatalf("应用了%d个迁移，应为%d个", len(applied), len(migrations))
+	}
+	if !hasColumn(t, db, "targets", "spec") {
+		t.Fatal("迁移后targets表缺少spec列")
+	}
+	version, err := db.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 {
+		t.Fatalf("结构版本为%d，应为1", version)
+	}
+
+	// 已有的数据保持不变
+	var targets, results int
+	db.conn.QueryRow("SELECT COUNT(*) FROM targets").Scan(&targets)
+	db.conn.QueryRow("SELECT COUNT(*) FROM ping_results").Scan(&results)
+	if targets != 1 || results != 3 {
+		t.Fatalf("迁移后有%d个目标、%d条结果，应为1和3", targets, results)
+	}
+
+	// 再次迁移不做任何事
+	if applied, err := db.Migrate(false); err != nil || len(applied) != 0 {
+		t.Fatalf("重复迁移: applied=%v err=%v", applied, err)
+	}
+}
+
+func TestMigrateDryRun(t *testing.T) {
+	db := openLegacy(t)
+	before := tableNames(t, db)
+
+	applied, err := db.Migrate(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) == 0 {
+		t.Fatal("预演没有返回待应用的迁移")
+	}
+	version, err := db.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 0 {
+		t.Fatalf("预演后结构版本为%d，应为0", version)
+	}
+	if after := tableNames(t, db); after != before {
+		t.Fatalf("预演修改了数据库: %s -> %s", before, after)
+	}
+	if hasColumn(t, db, "targets", "spec") {
+		t.Fatal("预演添加了spec列")
+	}
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	db := openLegacy(t)
+	if _, err := db.Migrate(false); err != nil {
+		t.Fatal(err)
+	}
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.conn.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES (?, 'future', CURRENT_TIMESTAMP)", len(migrations)+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Migrate(false); err == nil {
+		t.Fatal("结构版本高于程序支持的版本时应返回错误")
+	}
+	if _, err := db.Migrate(true); err == nil {
+		t.Fatal("预演时结构版本高于程序支持的版本也应返回错误")
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	files := fstest.MapFS{
+		"m/0001_initial.sql": {Data: []byte("CREATE TABLE a (id INTEGER);")},
+		"m/0002_second.sql":  {Data: []byte("CREATE TABLE b (id INTEGER);")},
+	}
+	migrations, err := loadMigrations(files, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 || migrations[1].String() != "0002_second" {
+		t.Fatalf("读取的迁移不正确: %v", migrations)
+	}
+
+	tests := map[string]fstest.MapFS{
+		"版本号不连续": {
+			"m/0001_initial.sql": {Data: []byte("")},
+			"m/0003_third.sql":   {Data: []byte("")},
+		},
+		"不从1开始": {
+			"m/0002_second.sql": {Data: []byte("")},
+		},
+		"版本号重复": {
+			"m/0001_initial.sql": {Data: []byte("")},
+			"m/0001_again.sql":   {Data: []byte("")},
+		},
+		"文件名无效": {
+			"m/initial.sql": {Data: []byte("")},
+		},
+	}
+	for name, files := range tests {
+		if _, err := loadMigrations(files, "m"); err == nil {
+			t.Errorf("%s: 应返回错误", name)
+		}
+	}
+}
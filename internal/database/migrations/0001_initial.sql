@@ -0,0 +1,100 @@
+-- 初始结构：之前版本在启动时以 CREATE TABLE IF NOT EXISTS 创建的全部表，已有的表保持不变
+
+-- 目标表
+CREATE TABLE IF NOT EXISTS targets (
+	id TEXT PRIMARY KEY,
+	addr TEXT NOT NULL,
+	description TEXT NOT NULL,
+	hide_addr BOOLEAN DEFAULT FALSE,
+	dns_server TEXT DEFAULT '',
+	spec TEXT DEFAULT '',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- ping结果表
+CREATE TABLE IF NOT EXISTS ping_results (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	target_id TEXT NOT NULL,
+	latency REAL NOT NULL,
+	success BOOLEAN NOT NULL,
+	timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (target_id) REFERENCES targets(id)
+);
+CREATE INDEX IF NOT EXISTS idx_target_timestamp ON ping_results(target_id, timestamp);
+CREATE INDEX IF NOT EXISTS idx_timestamp ON ping_results(timestamp);
+
+-- 附加指标表
+CREATE TABLE IF NOT EXISTS probe_metrics (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	target_id TEXT NOT NULL,
+	name TEXT NOT NULL,
+	value REAL NOT NULL,
+	timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (target_id) REFERENCES targets(id)
+);
+CREATE INDEX IF NOT EXISTS idx_metrics_target_name_timestamp ON probe_metrics(target_id, name, timestamp);
+
+-- 事件表和目标详情表
+CREATE TABLE IF NOT EXISTS events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	target_id TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	level TEXT NOT NULL,
+	message TEXT NOT NULL,
+	timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (target_id) REFERENCES targets(id)
+);
+CREATE INDEX IF NOT EXISTS idx_events_target_timestamp ON events(target_id, timestamp);
+CREATE TABLE IF NOT EXISTS target_info (
+	target_id TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	data TEXT NOT NULL,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (target_id, kind)
+);
+
+-- 解析地址表
+CREATE TABLE IF NOT EXISTS resolved_addresses (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	target_id TEXT NOT NULL,
+	ip TEXT NOT NULL,
+	asn INTEGER DEFAULT 0,
+	org TEXT DEFAULT '',
+	country TEXT DEFAULT '',
+	country_name TEXT DEFAULT '',
+	city TEXT DEFAULT '',
+	timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (target_id) REFERENCES targets(id)
+);
+CREATE INDEX IF NOT EXISTS idx_addresses_target_timestamp ON resolved_addresses(target_id, timestamp);
+
+-- 访客延迟（RUM）样本表
+CREATE TABLE IF NOT EXISTS rum_samples (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	prefix TEXT NOT NULL,
+	asn INTEGER DEFAULT 0,
+	org TEXT DEFAULT '',
+	country TEXT DEFAULT '',
+	country_name TEXT DEFAULT '',
+	city TEXT DEFAULT '',
+	latency REAL NOT NULL,
+	samples INTEGER DEFAULT 1,
+	timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_rum_timestamp ON rum_samples(timestamp);
+
+-- Ping结果汇总表，bucket为时间段开始的Unix时间戳
+CREATE TABLE IF NOT EXISTS ping_rollups (
+	target_id TEXT NOT NULL,
+	resolution INTEGER NOT NULL,
+	bucket INTEGER NOT NULL,
+	count INTEGER NOT NULL,
+	success INTEGER NOT NULL,
+	min REAL NOT NULL,
+	max REAL NOT NULL,
+	sum REAL NOT NULL,
+	histogram TEXT NOT NULL,
+	PRIMARY KEY (target_id, resolution, bucket)
+);
+CREATE INDEX IF NOT EXISTS idx_rollups_resolution_bucket ON ping_rollups(resolution, bucket);
@@ -0,0 +1,99 @@
+-- PostgreSQL初始结构，与SQLite的表和列一一对应
+-- 不声明外键，与SQLite中未启用外键检查时的行为一致；
+-- Ping结果和附加指标表没有主键，以便安装了TimescaleDB时转换为按时间分区的超表
+
+-- 目标表
+CREATE TABLE IF NOT EXISTS targets (
+	id TEXT PRIMARY KEY,
+	addr TEXT NOT NULL,
+	description TEXT NOT NULL,
+	hide_addr BOOLEAN DEFAULT FALSE,
+	dns_server TEXT DEFAULT '',
+	spec TEXT DEFAULT '',
+	created_at TIMESTAMPTZ DEFAULT NOW(),
+	updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- ping结果表
+CREATE TABLE IF NOT EXISTS ping_results (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY,
+	target_id TEXT NOT NULL,
+	latency DOUBLE PRECISION NOT NULL,
+	success BOOLEAN NOT NULL,
+	timestamp TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_target_timestamp ON ping_results(target_id, timestamp);
+CREATE INDEX IF NOT EXISTS idx_timestamp ON ping_results(timestamp);
+
+-- 附加指标表
+CREATE TABLE IF NOT EXISTS probe_metrics (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY,
+	target_id TEXT NOT NULL,
+	name TEXT NOT NULL,
+	value DOUBLE PRECISION NOT NULL,
+	timestamp TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_metrics_target_name_timestamp ON probe_metrics(target_id, name, timestamp);
+CREATE INDEX IF NOT EXISTS idx_metrics_target_timestamp ON probe_metrics(target_id, timestamp);
+
+-- 事件表和目标详情表
+CREATE TABLE IF NOT EXISTS events (
+	id BIGSERIAL PRIMARY KEY,
+	target_id TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	level TEXT NOT NULL,
+	message TEXT NOT NULL,
+	timestamp TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_events_target_timestamp ON events(target_id, timestamp);
+CREATE TABLE IF NOT EXISTS target_info (
+	target_id TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	data TEXT NOT NULL,
+	updated_at TIMESTAMPTZ DEFAULT NOW(),
+	PRIMARY KEY (target_id, kind)
+);
+
+-- 解析地址表
+CREATE TABLE IF NOT EXISTS resolved_addresses (
+	id BIGSERIAL PRIMARY KEY,
+	target_id TEXT NOT NULL,
+	ip TEXT NOT NULL,
+	asn BIGINT DEFAULT 0,
+	org TEXT DEFAULT '',
+	country TEXT DEFAULT '',
+	country_name TEXT DEFAULT '',
+	city TEXT DEFAULT '',
+	timestamp TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_addresses_target_timestamp ON resolved_addresses(target_id, timestamp);
+
+-- 访客延迟（RUM）样本表
+CREATE TABLE IF NOT EXISTS rum_samples (
+	id BIGSERIAL PRIMARY KEY,
+	prefix TEXT NOT NULL,
+	asn BIGINT DEFAULT 0,
+	org TEXT DEFAULT '',
+	country TEXT DEFAULT '',
+	country_name TEXT DEFAULT '',
+	city TEXT DEFAULT '',
+	latency DOUBLE PRECISION NOT NULL,
+	samples INTEGER DEFAULT 1,
+	timestamp TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_rum_timestamp ON rum_samples(timestamp);
+
+-- Ping结果汇总表，bucket为时间段开始的Unix时间戳
+CREATE TABLE IF NOT EXISTS ping_rollups (
+	target_id TEXT NOT NULL,
+	resolution INTEGER NOT NULL,
+	bucket BIGINT NOT NULL,
+	count BIGINT NOT NULL,
+	success BIGINT NOT NULL,
+	min DOUBLE PRECISION NOT NULL,
+	max DOUBLE PRECISION NOT NULL,
+	sum DOUBLE PRECISION NOT NULL,
+	histogram TEXT NOT NULL,
+	PRIMARY KEY (target_id, resolution, bucket)
+);
+CREATE INDEX IF NOT EXISTS idx_rollups_resolution_bucket ON ping_rollups(resolution, bucket);
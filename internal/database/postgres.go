@@ -0,0 +1,671 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"scallop/internal/models"
+
+	_ "github.com/lib/pq"
+)
+
+// PostgresDB PostgreSQL存储，数据库安装了TimescaleDB扩展时Ping结果和附加指标保存在超表中
+type PostgresDB struct {
+	conn      *sql.DB
+	mutex     sync.Mutex                         // 串行化写事务，汇总的读取和更新需要在同一事务中完成
+	lastPurge atomic.Pointer[models.PurgeResult] // 最近一次过期数据清理的结果
+	writer    *writer                            // 后台批量写入，未启动时为nil
+}
+
+var _ Store = (*PostgresDB)(nil)
+
+var postgresRollupSQL = rollupSQL{
+	load: `SELECT count, success, min, max, sum, histogram FROM ping_rollups
+			  WHERE target_id = $1 AND resolution = $2 AND bucket = $3`,
+	save: `INSERT INTO ping_rollups (target_id, resolution, bucket, count, success, min, max, sum, histogram)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			  ON CONFLICT (target_id, resolution, bucket) DO UPDATE SET
+			  count = EXCLUDED.count, success = EXCLUDED.success, min = EXCLUDED.min,
+			  max = EXCLUDED.max, sum = EXCLUDED.sum, histogram = EXCLUDED.histogram`,
+}
+
+// NewPostgres 连接PostgreSQL，应用未执行的迁移，安装了TimescaleDB扩展时将结果表转换为超表
+// dsn为lib/pq支持的连接字符串，如 postgres://scallop:密码@localhost/scallop?sslmode=disable
+func NewPostgres(dsn string) (*PostgresDB, error) {
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("连接PostgreSQL失败: %v", err)
+	}
+
+	db := &PostgresDB{conn: conn}
+	applied, err := db.migrate()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	for _, migration := range applied {
+		fmt.Printf("已应用数据库迁移 %s\n", migration)
+	}
+	if err := db.enableHypertables(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// migrate 依次应用未执行的迁移，每个迁移和版本记录在同一事务中提交
+// 数据库的结构版本高于程序内置的迁移时返回错误，避免旧程序写坏数据
+func (db *PostgresDB) migrate() ([]Migration, error) {
+	migrations, err := loadMigrations(migrationFiles, "migrations/postgres")
+	if err != nil {
+		return nil, err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	create := `CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL
+	)`
+	if _, err := db.conn.Exec(create); err != nil {
+		return nil, err
+	}
+	var current int
+	if err := db.conn.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&current); err != nil {
+		return nil, fmt.Errorf("读取数据库结构版本失败: %v", err)
+	}
+	if current > len(migrations) {
+		return nil, fmt.Errorf("数据库结构版本(%d)高于当前程序支持的版本(%d)，请升级Scallop后再使用该数据库", current, len(migrations))
+	}
+
+	applied := []Migration{}
+	for _, migration := range migrations[current:] {
+		err := db.inTxLocked(context.Background(), func(ctx context.Context, tx execer) error {
+			if _, err := tx.ExecContext(ctx, migration.SQL); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, "INSERT INTO schema_version (version, name, applied_at) VALUES ($1, $2, $3)",
+				migration.Version, migration.Name, time.Now())
+			return err
+		})
+		if err != nil {
+			return applied, fmt.Errorf("数据库迁移 %s 失败: %v", migration, err)
+		}
+		applied = append(applied, migration)
+	}
+	return applied, nil
+}
+
+// enableHypertables 安装了TimescaleDB扩展时将Ping结果和附加指标表转换为按时间分区的超表，已转换的表不做处理
+func (db *PostgresDB) enableHypertables() error {
+	var version string
+	err := db.conn.QueryRow("SELECT extversion FROM pg_extension WHERE extname = 'timescaledb'").Scan(&version)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, table := range []string{"ping_results", "probe_metrics"} {
+		query := "SELECT create_hypertable($1::regclass, 'timestamp'::name, if_not_exists => TRUE, migrate_data => TRUE)"
+		if _, err := db.conn.Exec(query, table); err != nil {
+			return fmt.Errorf("将%s转换为TimescaleDB超表失败: %v", table, err)
+		}
+	}
+	fmt.Printf("已使用TimescaleDB %s 超表保存Ping结果和附加指标\n", version)
+	return nil
+}
+
+// Close 写入队列中剩余的结果后关闭数据库连接
+func (db *PostgresDB) Close() error {
+	db.writer.close()
+	return db.conn.Close()
+}
+
+// StartWriter 启动后台批量写入，之后的Ping结果和附加指标先进入队列再按批写入
+func (db *PostgresDB) StartWriter(options *models.Writer) {
+	db.writer = newWriter(db.inTx, options)
+}
+
+// WriterStats 批量写入的运行状态，未启动时返回nil
+func (db *PostgresDB) WriterStats() *models.WriterStats {
+	return db.writer.snapshot()
+}
+
+// write 执行一次写入：批量写入已启动时放入队列，否则直接在事务中写入
+func (db *PostgresDB) write(apply func(ctx context.Context, tx execer) error) error {
+	if db.writer.enqueue(writeOp{apply: apply, queued: time.Now()}) {
+		return nil
+	}
+	return db.inTx(context.Background(), apply)
+}
+
+// inTx 在一个写事务中执行fn
+func (db *PostgresDB) inTx(ctx context.Context, fn func(ctx context.Context, tx execer) error) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	return db.inTxLocked(ctx, fn)
+}
+
+// inTxLocked 在一个事务中执行fn，调用方已持有写锁
+func (db *PostgresDB) inTxLocked(ctx context.Context, fn func(ctx context.Context, tx execer) error) error {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(ctx, tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SyncTargets 将配置中的目标同步到数据库，按配置顺序返回对应的目标
+func (db *PostgresDB) SyncTargets(configTargets []models.IPTarget) ([]*models.Target, error) {
+	return syncTargets(db, configTargets)
+}
+
+// MergeTarget 将目标from的历史数据合并到目标to并删除目标from
+// 两个目标都有同类详情或同一时间段的汇总时保留to的
+func (db *PostgresDB) MergeTarget(from, to string) error {
+	return db.inTx(context.Background(), func(ctx context.Context, tx execer) error {
+		for _, table := range targetTables {
+			if _, err := tx.ExecContext(ctx, "UPDATE "+table+" SET target_id = $1 WHERE target_id = $2", to, from); err != nil {
+				return fmt.Errorf("合并%s失败: %v", table, err)
+			}
+		}
+
+		merges := map[string]string{
+			"target_info":  "kept.kind = moved.kind",
+			"ping_rollups": "kept.resolution = moved.resolution AND kept.bucket = moved.bucket",
+		}
+		for table, same := range merges {
+			query := fmt.Sprintf(`UPDATE %s moved SET target_id = $1 WHERE moved.target_id = $2
+				AND NOT EXISTS (SELECT 1 FROM %s kept WHERE kept.target_id = $1 AND %s)`, table, table, same)
+			if _, err := tx.ExecContext(ctx, query, to, from); err != nil {
+				return fmt.Errorf("合并%s失败: %v", table, err)
+			}
+			if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE target_id = $1", from); err != nil {
+				return err
+			}
+		}
+		_, err := tx.ExecContext(ctx, "DELETE FROM targets WHERE id = $1", from)
+		return err
+	})
+}
+
+// SaveTarget 保存目标到数据库
+func (db *PostgresDB) SaveTarget(target *models.Target) error {
+	spec, err := json.Marshal(target.Spec)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO targets (id, addr, description, hide_addr, dns_server, spec, created_at, updated_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			  ON CONFLICT (id) DO UPDATE SET addr = EXCLUDED.addr, description = EXCLUDED.description,
+			  hide_addr = EXCLUDED.hide_addr, dns_server = EXCLUDED.dns_server, spec = EXCLUDED.spec,
+			  created_at = EXCLUDED.created_at, updated_at = EXCLUDED.updated_at`
+	_, err = db.conn.Exec(query, target.ID, target.Addr, target.Description,
+		target.HideAddr, target.DNSServer, string(spec), target.CreatedAt, target.UpdatedAt)
+	return err
+}
+
+// LoadTargets 从数据库加载目标
+func (db *PostgresDB) LoadTargets() (map[string]*models.Target, error) {
+	rows, err := db.conn.Query("SELECT id, addr, description, hide_addr, dns_server, spec, created_at, updated_at FROM targets")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	targets := make(map[string]*models.Target)
+	for rows.Next() {
+		target, err := scanTarget(rows)
+		if err != nil {
+			continue
+		}
+		targets[target.ID] = target
+	}
+	return targets, rows.Err()
+}
+
+// GetTarget 获取单个目标，不存在时返回sql.ErrNoRows
+func (db *PostgresDB) GetTarget(id string) (*models.Target, error) {
+	query := "SELECT id, addr, description, hide_addr, dns_server, spec, created_at, updated_at FROM targets WHERE id = $1"
+	return scanTarget(db.conn.QueryRow(query, id))
+}
+
+// GetRetiredTargets 获取已从配置中移除、但仍保留在数据库中的目标，最近仍有结果的在前
+func (db *PostgresDB) GetRetiredTargets(active map[string]bool) ([]models.RetiredTarget, error) {
+	return retiredTargets(db, active)
+}
+
+// GetRetiredTarget 获取单个已移除的目标，目标仍在配置中或不存在时返回sql.ErrNoRows
+func (db *PostgresDB) GetRetiredTarget(id string, active map[string]bool) (models.RetiredTarget, error) {
+	return getRetiredTarget(db, id, active)
+}
+
+// resultSpan 统计目标的结果数量和首末结果时间
+func (db *PostgresDB) resultSpan(targetID string) (count int, first, last time.Time, err error) {
+	var firstResult, lastResult sql.NullTime
+	query := "SELECT COUNT(*), MIN(timestamp), MAX(timestamp) FROM ping_results WHERE target_id = $1"
+	err = db.conn.QueryRow(query, targetID).Scan(&count, &firstResult, &lastResult)
+	return count, firstResult.Time, lastResult.Time, err
+}
+
+// PurgeTarget 删除目标及其全部历史数据
+func (db *PostgresDB) PurgeTarget(id string) error {
+	return db.inTx(context.Background(), func(ctx context.Context, tx execer) error {
+		tables := append([]string{"target_info", "ping_rollups"}, targetTables...)
+		for _, table := range tables {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE target_id = $1", id); err != nil {
+				return fmt.Errorf("删除%s失败: %v", table, err)
+			}
+		}
+		result, err := tx.ExecContext(ctx, "DELETE FROM targets WHERE id = $1", id)
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return sql.ErrNoRows
+		}
+		return nil
+	})
+}
+
+// SavePingResult 保存Ping结果，并在同一事务中计入各层级的汇总
+func (db *PostgresDB) SavePingResult(result models.PingResult) error {
+	return db.write(func(ctx context.Context, tx execer) error {
+		query := `INSERT INTO ping_results (target_id, latency, success, timestamp) VALUES ($1, $2, $3, $4)`
+		if _, err := tx.ExecContext(ctx, query, result.TargetID, result.Latency, result.Success, result.Timestamp); err != nil {
+			return err
+		}
+		if err := addToRollups(ctx, tx, postgresRollupSQL, result); err != nil {
+			return fmt.Errorf("更新汇总失败: %v", err)
+		}
+		return nil
+	})
+}
+
+// GetPingResults 查询指定目标在时间范围内的Ping结果
+func (db *PostgresDB) GetPingResults(targetID string, since, until time.Time) ([]models.PingResult, error) {
+	query := `SELECT id, target_id, latency, success, timestamp FROM ping_results
+			  WHERE target_id = $1 AND timestamp >= $2 AND timestamp <= $3
+			  ORDER BY timestamp ASC`
+	rows, err := db.conn.Query(query, targetID, since, until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanPingResults(rows), rows.Err()
+}
+
+// GetLatestResults 获取每个目标最新的一条Ping结果
+func (db *PostgresDB) GetLatestResults() ([]models.PingResult, error) {
+	query := `SELECT DISTINCT ON (target_id) id, target_id, latency, success, timestamp FROM ping_results
+			  ORDER BY target_id, timestamp DESC`
+	rows, err := db.conn.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanPingResults(rows), rows.Err()
+}
+
+// GetLastSuccess 获取目标最近一次成功结果的时间，没有结果时返回零值
+func (db *PostgresDB) GetLastSuccess(targetID string) (time.Time, error) {
+	var timestamp time.Time
+	query := `SELECT timestamp FROM ping_results WHERE target_id = $1 AND success
+			  ORDER BY timestamp DESC LIMIT 1`
+	err := db.conn.QueryRow(query, targetID).Scan(&timestamp)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return timestamp, err
+}
+
+// GetRollups 获取目标在时间范围内按step汇总的结果
+func (db *PostgresDB) GetRollups(targetID string, since, until time.Time, step time.Duration) ([]models.Rollup, error) {
+	tier := RollupTier(step)
+	if tier == 0 {
+		return nil, fmt.Errorf("时间分辨率不能小于%d秒", RollupMinute)
+	}
+	width := int64(RollupWidth(step))
+
+	query := `SELECT bucket, count, success, min, max, sum, histogram FROM ping_rollups
+			  WHERE target_id = $1 AND resolution = $2 AND bucket >= $3 AND bucket <= $4
+			  ORDER BY bucket ASC`
+	origin := bucketStart(since, tier).Unix()
+	rows, err := db.conn.Query(query, targetID, tier, origin, until.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRollups(rows, targetID, origin, width)
+}
+
+// SaveMetrics 保存一次探测产生的附加指标
+func (db *PostgresDB) SaveMetrics(targetID string, timestamp time.Time, metrics map[string]float64) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	// 写入前调用方可能继续修改map，入队时复制一份
+	values := make(map[string]float64, len(metrics))
+	for name, value := range metrics {
+		values[name] = value
+	}
+
+	return db.write(func(ctx context.Context, tx execer) error {
+		query := `INSERT INTO probe_metrics (target_id, name, value, timestamp) VALUES ($1, $2, $3, $4)`
+		for name, value := range values {
+			if _, err := tx.ExecContext(ctx, query, targetID, name, value, timestamp); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetMetrics 查询指定目标在时间范围内的附加指标，name为空时返回全部指标
+func (db *PostgresDB) GetMetrics(targetID, name string, since, until time.Time) ([]models.Metric, error) {
+	query := `SELECT target_id, name, value, timestamp FROM probe_metrics
+			  WHERE target_id = $1 AND timestamp >= $2 AND timestamp <= $3`
+	args := []interface{}{targetID, since, until}
+	if name != "" {
+		query += " AND name = $4"
+		args = append(args, name)
+	}
+	query += " ORDER BY timestamp ASC"
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	metrics := []models.Metric{}
+	for rows.Next() {
+		var metric models.Metric
+		if err := rows.Scan(&metric.TargetID, &metric.Name, &metric.Value, &metric.Timestamp); err != nil {
+			continue
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics, rows.Err()
+}
+
+// SaveEvent 保存目标事件
+func (db *PostgresDB) SaveEvent(event models.Event) error {
+	query := `INSERT INTO events (target_id, kind, level, message, timestamp) VALUES ($1, $2, $3, $4, $5)`
+	_, err := db.conn.Exec(query, event.TargetID, event.Kind, event.Level, event.Message, event.Timestamp)
+	return err
+}
+
+// GetEvents 查询时间范围内的事件，targetID为空时返回所有目标的事件
+func (db *PostgresDB) GetEvents(targetID string, since, until time.Time) ([]models.Event, error) {
+	query := `SELECT id, target_id, kind, level, message, timestamp FROM events
+			  WHERE timestamp >= $1 AND timestamp <= $2`
+	args := []interface{}{since, until}
+	if targetID != "" {
+		query += " AND target_id = $3"
+		args = append(args, targetID)
+	}
+	query += " ORDER BY timestamp ASC"
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.Event{}
+	for rows.Next() {
+		var event models.Event
+		if err := rows.Scan(&event.ID, &event.TargetID, &event.Kind, &event.Level, &event.Message, &event.Timestamp); err != nil {
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// SaveTargetInfo 保存目标的最新探测详情，同一类别只保留最新一份
+func (db *PostgresDB) SaveTargetInfo(targetID, kind string, data interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO target_info (target_id, kind, data, updated_at) VALUES ($1, $2, $3, $4)
+			  ON CONFLICT (target_id, kind) DO UPDATE SET data = EXCLUDED.data, updated_at = EXCLUDED.updated_at`
+	_, err = db.conn.Exec(query, targetID, kind, string(encoded), time.Now())
+	return err
+}
+
+// GetTargetInfo 获取目标的最新探测详情，不存在时返回sql.ErrNoRows
+func (db *PostgresDB) GetTargetInfo(targetID, kind string) (json.RawMessage, time.Time, error) {
+	var data string
+	var updatedAt time.Time
+	query := `SELECT data, updated_at FROM target_info WHERE target_id = $1 AND kind = $2`
+	if err := db.conn.QueryRow(query, targetID, kind).Scan(&data, &updatedAt); err != nil {
+		return nil, time.Time{}, err
+	}
+	return json.RawMessage(data), updatedAt, nil
+}
+
+// SaveResolvedAddress 保存目标解析到的新地址
+func (db *PostgresDB) SaveResolvedAddress(addr models.ResolvedAddress) error {
+	query := `INSERT INTO resolved_addresses (target_id, ip, asn, org, country, country_name, city, timestamp)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	_, err := db.conn.Exec(query, addr.TargetID, addr.IP, addr.Geo.ASN, addr.Geo.Org,
+		addr.Geo.Country, addr.Geo.CountryName, addr.Geo.City, addr.Timestamp)
+	return err
+}
+
+// GetLatestAddresses 获取每个目标最近一次解析到的地址，key为目标ID
+func (db *PostgresDB) GetLatestAddresses() (map[string]models.ResolvedAddress, error) {
+	query := `SELECT DISTINCT ON (target_id) target_id, ip, asn, org, country, country_name, city, timestamp
+			  FROM resolved_addresses
+			  ORDER BY target_id, id DESC`
+
+	rows, err := db.conn.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	addresses := make(map[string]models.ResolvedAddress)
+	for _, addr := range scanAddresses(rows) {
+		addresses[addr.TargetID] = addr
+	}
+	return addresses, rows.Err()
+}
+
+// GetAddressHistory 获取目标在时间范围内的地址变化记录
+func (db *PostgresDB) GetAddressHistory(targetID string, since, until time.Time) ([]models.ResolvedAddress, error) {
+	query := `SELECT target_id, ip, asn, org, country, country_name, city, timestamp
+			  FROM resolved_addresses
+			  WHERE target_id = $1 AND timestamp >= $2 AND timestamp <= $3
+			  ORDER BY timestamp ASC`
+
+	rows, err := db.conn.Query(query, targetID, since, until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanAddresses(rows), rows.Err()
+}
+
+// SaveRUMSample 保存一条访客延迟样本
+func (db *PostgresDB) SaveRUMSample(sample models.RUMSample) error {
+	query := `INSERT INTO rum_samples (prefix, asn, org, country, country_name, city, latency, samples, timestamp)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	_, err := db.conn.Exec(query, sample.Prefix, sample.Geo.ASN, sample.Geo.Org, sample.Geo.Country,
+		sample.Geo.CountryName, sample.Geo.City, sample.Latency, sample.Samples, sample.Timestamp)
+	return err
+}
+
+// GetRUMSamples 查询时间范围内的访客延迟样本
+func (db *PostgresDB) GetRUMSamples(since, until time.Time) ([]models.RUMSample, error) {
+	query := `SELECT id, prefix, asn, org, country, country_name, city, latency, samples, timestamp
+			  FROM rum_samples
+			  WHERE timestamp >= $1 AND timestamp <= $2
+			  ORDER BY timestamp ASC`
+
+	rows, err := db.conn.Query(query, since, until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	samples := []models.RUMSample{}
+	for rows.Next() {
+		var sample models.RUMSample
+		err := rows.Scan(&sample.ID, &sample.Prefix, &sample.Geo.ASN, &sample.Geo.Org, &sample.Geo.Country,
+			&sample.Geo.CountryName, &sample.Geo.City, &sample.Latency, &sample.Samples, &sample.Timestamp)
+		if err != nil {
+			continue
+		}
+		samples = append(samples, sample)
+	}
+	return samples, rows.Err()
+}
+
+// PurgeExpired 按保留策略分批删除过期的Ping结果、附加指标和汇总
+// 删除后的空间由PostgreSQL的autovacuum回收；TimescaleDB超表同样按行删除，不会整块删除分区
+func (db *PostgresDB) PurgeExpired(ctx context.Context, policy RetentionPolicy) (models.PurgeResult, error) {
+	result := models.PurgeResult{StartedAt: time.Now()}
+	err := db.purgeExpired(ctx, policy, &result)
+	if err != nil {
+		result.Error = err.Error()
+	}
+	result.Duration = float64(time.Since(result.StartedAt).Microseconds()) / 1000
+	db.lastPurge.Store(&result)
+	return result, err
+}
+
+// purgeExpired 逐个目标分批删除过期数据
+func (db *PostgresDB) purgeExpired(ctx context.Context, policy RetentionPolicy, result *models.PurgeResult) error {
+	targets, err := db.LoadTargets()
+	if err != nil {
+		return err
+	}
+
+	for id := range targets {
+		retention, ok := policy.Targets[id]
+		if !ok {
+			retention = policy.Default
+		}
+		if retention <= 0 {
+			continue
+		}
+		cutoff := result.StartedAt.Add(-retention)
+
+		deleted, err := db.deleteBatches(ctx, "ping_results", "target_id", id, "timestamp", cutoff, policy.BatchSize)
+		result.ResultsDeleted += deleted
+		if err != nil {
+			return err
+		}
+		deleted, err = db.deleteBatches(ctx, "probe_metrics", "target_id", id, "timestamp", cutoff, policy.BatchSize)
+		result.MetricsDeleted += deleted
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, resolution := range RollupTiers {
+		retention := policy.Rollups[resolution]
+		if retention <= 0 {
+			continue
+		}
+		cutoff := result.StartedAt.Add(-retention).Unix()
+		deleted, err := db.deleteBatches(ctx, "ping_rollups", "resolution", resolution, "bucket", cutoff, policy.BatchSize)
+		result.RollupsDeleted += deleted
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteBatches 分批删除表中key等于value、column小于cutoff的记录，返回删除的行数
+// PostgreSQL的DELETE不支持LIMIT，超表中ctid也不唯一，因此先按索引找到每批的边界再按范围删除
+func (db *PostgresDB) deleteBatches(ctx context.Context, table, key string, value interface{}, column string, cutoff interface{}, batchSize int) (int64, error) {
+	boundary := fmt.Sprintf("SELECT %s FROM %s WHERE %s = $1 AND %s < $2 ORDER BY %s OFFSET $3 LIMIT 1",
+		column, table, key, column, column)
+	remove := fmt.Sprintf("DELETE FROM %s WHERE %s = $1 AND %s < $2", table, key, column)
+	// 边界上的值重复超过一批时，按小于等于边界删除，保证每批都有进展
+	removeThrough := fmt.Sprintf("DELETE FROM %s WHERE %s = $1 AND %s <= $2", table, key, column)
+
+	var total int64
+	for {
+		var next interface{}
+		err := db.conn.QueryRowContext(ctx, boundary, value, cutoff, batchSize).Scan(&next)
+		last := err == sql.ErrNoRows
+		if err != nil && !last {
+			return total, fmt.Errorf("清理%s失败: %v", table, err)
+		}
+		limit := next
+		if last {
+			limit = cutoff
+		}
+
+		db.mutex.Lock()
+		res, err := db.conn.ExecContext(ctx, remove, value, limit)
+		if err == nil && !last {
+			if n, _ := res.RowsAffected(); n == 0 {
+				res, err = db.conn.ExecContext(ctx, removeThrough, value, limit)
+			}
+		}
+		db.mutex.Unlock()
+		if err != nil {
+			return total, fmt.Errorf("清理%s失败: %v", table, err)
+		}
+		n, _ := res.RowsAffected()
+		total += n
+		if last {
+			return total, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return total, ctx.Err()
+		case <-time.After(purgePause):
+		}
+	}
+}
+
+// Stats 数据库大小、最早的结果时间和最近一次清理的结果
+func (db *PostgresDB) Stats() (models.DatabaseStats, error) {
+	stats := models.DatabaseStats{Backend: "postgres"}
+	if err := db.conn.QueryRow("SELECT pg_database_size(current_database())").Scan(&stats.SizeBytes); err != nil {
+		return stats, err
+	}
+
+	query := "SELECT timestamp FROM ping_results ORDER BY timestamp ASC LIMIT 1"
+	if err := db.conn.QueryRow(query).Scan(&stats.OldestResult); err != nil && err != sql.ErrNoRows {
+		return stats, err
+	}
+	stats.LastPurge = db.lastPurge.Load()
+	stats.Writer = db.WriterStats()
+	return stats, nil
+}
@@ -0,0 +1,254 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"scallop/internal/models"
+)
+
+// 每批删除之间的间隔，让出写锁给探测结果的保存
+const purgePause = 50 * time.Millisecond
+
+// 每次增量VACUUM归还的页数，每批之间同样让出写锁
+const vacuumPages = 1024
+
+// RetentionPolicy 一次清理使用的保留策略，保留时长为0表示永久保留
+type RetentionPolicy struct {
+	Default   time.Duration            // 未单独配置的目标（包括已移除的目标）的保留时长
+	Targets   map[string]time.Duration // 按目标ID单独配置的保留时长
+	Rollups   map[int]time.Duration    // 各层级汇总的保留时长，key为时间段长度（秒）
+	BatchSize int                      // 每批删除的行数
+}
+
+// PurgeExpired 按保留策略删除过期的Ping结果、附加指标和汇总
+// 每批删除后释放写锁，不会长时间阻塞探测结果的保存；ctx取消时在批次之间停止
+func (db *DB) PurgeExpired(ctx context.Context, policy RetentionPolicy) (models.PurgeResult, error) {
+	result := models.PurgeResult{StartedAt: time.Now()}
+	err := db.purgeExpired(ctx, policy, &result)
+	if err == nil {
+		var freed int64
+		freed, err = db.IncrementalVacuum(ctx)
+		result.FreedBytes = freed
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	result.Duration = float64(time.Since(result.StartedAt).Microseconds()) / 1000
+	db.lastPurge.Store(&result)
+	return result, err
+}
+
+// purgeExpired 逐个目标分批删除过期数据
+func (db *DB) purgeExpired(ctx context.Context, policy RetentionPolicy, result *models.PurgeResult) error {
+	rows, err := db.conn.Query("SELECT id FROM targets")
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		retention, ok := policy.Targets[id]
+		if !ok {
+			retention = policy.Default
+		}
+		if retention <= 0 {
+			continue
+		}
+		cutoff := result.StartedAt.Add(-retention)
+
+		where := "target_id = ? AND timestamp < ?"
+		deleted, err := db.deleteBatches(ctx, "ping_results", where, policy.BatchSize, id, cutoff)
+		result.ResultsDeleted += deleted
+		if err != nil {
+			return err
+		}
+		deleted, err = db.deleteBatches(ctx, "probe_metrics", where, policy.BatchSize, id, cutoff)
+		result.MetricsDeleted += deleted
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, resolution := range RollupTiers {
+		retention := policy.Rollups[resolution]
+		if retention <= 0 {
+			continue
+		}
+		cutoff := result.StartedAt.Add(-retention).Unix()
+		deleted, err := db.deleteBatches(ctx, "ping_rollups", "resolution = ? AND bucket < ?", policy.BatchSize, resolution, cutoff)
+		result.RollupsDeleted += deleted
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteBatches 分批删除表中满足where条件的记录，返回删除的行数
+func (db *DB) deleteBatches(ctx context.Context, table, where string, batchSize int, args ...interface{}) (int64, error) {
+	query := fmt.Sprintf("DELETE FROM %s WHERE rowid IN (SELECT rowid FROM %s WHERE %s LIMIT ?)", table, table, where)
+	args = append(args, batchSize)
+
+	var total int64
+	for {
+		db.mutex.Lock()
+		res, err := db.conn.ExecContext(ctx, query, args...)
+		db.mutex.Unlock()
+		if err != nil {
+			return total, fmt.Errorf("清理%s失败: %v", table, err)
+		}
+		n, _ := res.RowsAffected()
+		total += n
+		if n < int64(batchSize) {
+			return total, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return total, ctx.Err()
+		case <-time.After(purgePause):
+		}
+	}
+}
+
+// IncrementalVacuum 将空闲页归还给文件系统，返回释放的字节数
+// 数据库未启用增量VACUUM时不做处理，空闲页仍会被之后写入的数据复用
+func (db *DB) IncrementalVacuum(ctx context.Context) (int64, error) {
+	mode, err := db.autoVacuum()
+	if err != nil || mode != "incremental" {
+		return 0, err
+	}
+	pageSize, err := db.pragmaInt("page_size")
+	if err != nil {
+		return 0, err
+	}
+
+	var freed int64
+	for {
+		free, err := db.pragmaInt("freelist_count")
+		if err != nil || free == 0 {
+			return freed, err
+		}
+
+		db.mutex.Lock()
+		err = db.vacuumStep(ctx)
+		db.mutex.Unlock()
+		if err != nil {
+			return freed, fmt.Errorf("增量VACUUM失败: %v", err)
+		}
+		remaining, err := db.pragmaInt("freelist_count")
+		if err != nil {
+			return freed, err
+		}
+		if remaining >= free {
+			return freed, nil
+		}
+		freed += (free - remaining) * pageSize
+
+		select {
+		case <-ctx.Done():
+			return freed, ctx.Err()
+		case <-time.After(purgePause):
+		}
+	}
+}
+
+// vacuumStep 归还最多vacuumPages个空闲页
+// incremental_vacuum每归还一页执行一步，需要读完结果才会全部执行
+func (db *DB) vacuumStep(ctx context.Context) error {
+	rows, err := db.conn.QueryContext(ctx, fmt.Sprintf("PRAGMA incremental_vacuum(%d)", vacuumPages))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+	}
+	return rows.Err()
+}
+
+// Vacuum 重建整个数据库并启用增量VACUUM，期间会阻塞所有写入，适合在停止服务后执行
+func (db *DB) Vacuum() error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	// auto_vacuum只对执行VACUUM的连接生效，两条语句必须在同一连接上执行
+	conn, err := db.conn.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(context.Background(), "PRAGMA auto_vacuum = INCREMENTAL"); err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(context.Background(), "VACUUM")
+	return err
+}
+
+// Stats 获取数据库的空间占用和最近一次清理的结果
+func (db *DB) Stats() (models.DatabaseStats, error) {
+	stats := models.DatabaseStats{Backend: "sqlite"}
+	pageSize, err := db.pragmaInt("page_size")
+	if err != nil {
+		return stats, err
+	}
+	pages, err := db.pragmaInt("page_count")
+	if err != nil {
+		return stats, err
+	}
+	free, err := db.pragmaInt("freelist_count")
+	if err != nil {
+		return stats, err
+	}
+	stats.SizeBytes = pages * pageSize
+	stats.FreeBytes = free * pageSize
+	if stats.AutoVacuum, err = db.autoVacuum(); err != nil {
+		return stats, err
+	}
+
+	// MIN会丢失列的时间类型，改为按时间排序取第一条
+	query := "SELECT timestamp FROM ping_results ORDER BY timestamp ASC LIMIT 1"
+	if err := db.conn.QueryRow(query).Scan(&stats.OldestResult); err != nil && err != sql.ErrNoRows {
+		return stats, err
+	}
+	stats.LastPurge = db.lastPurge.Load()
+	stats.Writer = db.WriterStats()
+	return stats, nil
+}
+
+// autoVacuum 数据库当前的auto_vacuum模式
+func (db *DB) autoVacuum() (string, error) {
+	mode, err := db.pragmaInt("auto_vacuum")
+	if err != nil {
+		return "", err
+	}
+	switch mode {
+	case 1:
+		return "full", nil
+	case 2:
+		return "incremental", nil
+	default:
+		return "none", nil
+	}
+}
+
+// pragmaInt 读取整数类型的PRAGMA
+func (db *DB) pragmaInt(name string) (int64, error) {
+	var value int64
+	err := db.conn.QueryRow("PRAGMA " + name).Scan(&value)
+	return value, err
+}
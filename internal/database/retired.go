@@ -0,0 +1,74 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"scallop/internal/models"
+)
+
+// ReactivationSpec 重新启用目标时写入配置的目标定义
+// 地址等字段生成的ID与原目标ID不同时（如原目标配置了id）显式指定id，保证沿用原来的历史数据
+func ReactivationSpec(target models.Target) models.IPTarget {
+	spec := target.Spec
+	if TargetID(spec) != target.ID {
+		spec.ID = target.ID
+	}
+	return spec
+}
+
+// GetRetiredTargets 获取已从配置中移除、但仍保留在数据库中的目标，最近仍有结果的在前
+// active为当前配置中的目标ID
+func (db *DB) GetRetiredTargets(active map[string]bool) ([]models.RetiredTarget, error) {
+	return retiredTargets(db, active)
+}
+
+// GetRetiredTarget 获取单个已移除的目标，目标仍在配置中或不存在时返回sql.ErrNoRows
+func (db *DB) GetRetiredTarget(id string, active map[string]bool) (models.RetiredTarget, error) {
+	return getRetiredTarget(db, id, active)
+}
+
+// resultSpan 统计目标的结果数量和首末结果时间
+func (db *DB) resultSpan(targetID string) (count int, first, last time.Time, err error) {
+	err = db.conn.QueryRow("SELECT COUNT(*) FROM ping_results WHERE target_id = ?", targetID).Scan(&count)
+	if err != nil || count == 0 {
+		return count, first, last, err
+	}
+
+	// MIN/MAX会丢失列的时间类型，改为按时间排序取首尾
+	query := "SELECT timestamp FROM ping_results WHERE target_id = ? ORDER BY timestamp ASC LIMIT 1"
+	if err = db.conn.QueryRow(query, targetID).Scan(&first); err != nil {
+		return count, first, last, err
+	}
+	query = "SELECT timestamp FROM ping_results WHERE target_id = ? ORDER BY timestamp DESC LIMIT 1"
+	err = db.conn.QueryRow(query, targetID).Scan(&last)
+	return count, first, last, err
+}
+
+// PurgeTarget 删除目标及其全部历史数据
+func (db *DB) PurgeTarget(id string) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	tables := append([]string{"target_info", "ping_rollups"}, targetTables...)
+	for _, table := range tables {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE target_id = ?", id); err != nil {
+			return fmt.Errorf("删除%s失败: %v", table, err)
+		}
+	}
+	result, err := tx.Exec("DELETE FROM targets WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
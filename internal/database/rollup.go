@@ -0,0 +1,430 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"scallop/internal/models"
+)
+
+// 汇总层级的时间段长度，单位：秒
+const (
+	RollupMinute = 60
+	RollupHour   = 3600
+	RollupDay    = 86400
+)
+
+// RollupTiers 所有汇总层级，从细到粗
+var RollupTiers = []int{RollupMinute, RollupHour, RollupDay}
+
+// RollupTierNames 汇总层级在配置中的名称
+var RollupTierNames = map[string]int{"1m": RollupMinute, "1h": RollupHour, "1d": RollupDay}
+
+// 延迟直方图的分桶参数：从0.01ms开始，每个桶的上界是前一个的1.1倍，百分位数的相对误差约5%
+const (
+	histogramMin    = 0.01
+	histogramGrowth = 1.1
+)
+
+// histogram 成功结果的延迟分布，key为桶序号，用于合并时间段后估算百分位数
+type histogram map[int]int64
+
+// add 记录一个延迟
+func (h histogram) add(latency float64) {
+	index := 0
+	if latency > histogramMin {
+		index = int(math.Ceil(math.Log(latency/histogramMin) / math.Log(histogramGrowth)))
+	}
+	h[index]++
+}
+
+// merge 合并另一个直方图
+func (h histogram) merge(other histogram) {
+	for index, count := range other {
+		h[index] += count
+	}
+}
+
+// quantile 估算q分位的延迟，取所在桶上下界的几何中点
+func (h histogram) quantile(q float64) float64 {
+	var total int64
+	indexes := make([]int, 0, len(h))
+	for index, count := range h {
+		total += count
+		indexes = append(indexes, index)
+	}
+	if total == 0 {
+		return 0
+	}
+	sort.Ints(indexes)
+
+	rank := int64(math.Ceil(q * float64(total)))
+	var seen int64
+	for _, index := range indexes {
+		seen += h[index]
+		if seen >= rank {
+			if index == 0 {
+				return histogramMin
+			}
+			return histogramMin * math.Pow(histogramGrowth, float64(index)-0.5)
+		}
+	}
+	return histogramMin * math.Pow(histogramGrowth, float64(indexes[len(indexes)-1]))
+}
+
+// rollup 一个时间段的汇总，min、max、sum只统计成功的结果
+type rollup struct {
+	count     int64
+	success   int64
+	min       float64
+	max       float64
+	sum       float64
+	histogram histogram
+}
+
+func newRollup() *rollup {
+	return &rollup{histogram: make(histogram)}
+}
+
+// add 记录一个Ping结果
+func (r *rollup) add(latency float64, success bool) {
+	r.count++
+	if !success {
+		return
+	}
+	if r.success == 0 || latency < r.min {
+		r.min = latency
+	}
+	if r.success == 0 || latency > r.max {
+		r.max = latency
+	}
+	r.success++
+	r.sum += latency
+	r.histogram.add(latency)
+}
+
+// merge 合并另一个时间段的汇总
+func (r *rollup) merge(other *rollup) {
+	if other.success > 0 {
+		if r.success == 0 || other.min < r.min {
+			r.min = other.min
+		}
+		if r.success == 0 || other.max > r.max {
+			r.max = other.max
+		}
+	}
+	r.count += other.count
+	r.success += other.success
+	r.sum += other.sum
+	r.histogram.merge(other.histogram)
+}
+
+// result 转换为对外的汇总结果
+func (r *rollup) result(targetID string, start time.Time, resolution int) models.Rollup {
+	item := models.Rollup{
+		TargetID:   targetID,
+		Timestamp:  start,
+		Resolution: resolution,
+		Count:      r.count,
+		Success:    r.success,
+	}
+	if r.count > 0 {
+		item.Loss = float64(r.count-r.success) / float64(r.count) * 100
+	}
+	if r.success > 0 {
+		clamp := func(v float64) float64 { return math.Min(math.Max(v, r.min), r.max) }
+		item.Min = r.min
+		item.Max = r.max
+		item.Avg = r.sum / float64(r.success)
+		item.P50 = clamp(r.histogram.quantile(0.50))
+		item.P95 = clamp(r.histogram.quantile(0.95))
+		item.P99 = clamp(r.histogram.quantile(0.99))
+	}
+	return item
+}
+
+// bucketStart 时间所在时间段的开始时间
+// 按小时和按天汇总时以本地时间为界，保证非整点时区的小时时间段同样落在一天之内
+func bucketStart(t time.Time, resolution int) time.Time {
+	local := t.Local()
+	year, month, day := local.Date()
+	switch resolution {
+	case RollupDay:
+		return time.Date(year, month, day, 0, 0, 0, 0, time.Local)
+	case RollupHour:
+		return time.Date(year, month, day, local.Hour(), 0, 0, 0, time.Local)
+	default:
+		return t.Truncate(time.Duration(resolution) * time.Second)
+	}
+}
+
+// execer 事务和单个连接共有的方法
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// rollupSQL 读取和写入单个汇总的语句，各数据库的参数占位符和插入或替换的写法不同
+type rollupSQL struct {
+	load string // 参数依次为target_id、resolution、bucket
+	save string // 参数依次为全部列
+}
+
+var sqliteRollupSQL = rollupSQL{
+	load: `SELECT count, success, min, max, sum, histogram FROM ping_rollups
+			  WHERE target_id = ? AND resolution = ? AND bucket = ?`,
+	save: `INSERT OR REPLACE INTO ping_rollups (target_id, resolution, bucket, count, success, min, max, sum, histogram)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+}
+
+// addToRollups 将一个Ping结果计入各层级的汇总
+func addToRollups(ctx context.Context, tx execer, queries rollupSQL, result models.PingResult) error {
+	for _, resolution := range RollupTiers {
+		bucket := bucketStart(result.Timestamp, resolution).Unix()
+		item, err := loadRollup(ctx, tx, queries, result.TargetID, resolution, bucket)
+		if err != nil {
+			return err
+		}
+		item.add(result.Latency, result.Success)
+		if err := saveRollup(ctx, tx, queries, result.TargetID, resolution, bucket, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadRollup 读取一个时间段的汇总，不存在时返回空的汇总
+func loadRollup(ctx context.Context, tx execer, queries rollupSQL, targetID string, resolution int, bucket int64) (*rollup, error) {
+	item := newRollup()
+	var data string
+	err := tx.QueryRowContext(ctx, queries.load, targetID, resolution, bucket).
+		Scan(&item.count, &item.success, &item.min, &item.max, &item.sum, &data)
+	if err == sql.ErrNoRows {
+		return item, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(data), &item.histogram); err != nil {
+		return nil, fmt.Errorf("解析汇总直方图失败: %v", err)
+	}
+	return item, nil
+}
+
+// saveRollup 写入一个时间段的汇总
+func saveRollup(ctx context.Context, tx execer, queries rollupSQL, targetID string, resolution int, bucket int64, item *rollup) error {
+	data, err := json.Marshal(item.histogram)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, queries.save, targetID, resolution, bucket,
+		item.count, item.success, item.min, item.max, item.sum, string(data))
+	return err
+}
+
+// RollupTier 按要求的时间分辨率选择汇总层级：不超过step的最粗层级，step小于1分钟时返回0（使用原始结果）
+func RollupTier(step time.Duration) int {
+	tier := 0
+	for _, resolution := range RollupTiers {
+		if step >= time.Duration(resolution)*time.Second {
+			tier = resolution
+		}
+	}
+	return tier
+}
+
+// RollupWidth 按step汇总时实际的时间段长度（秒），为所选层级长度的整数倍；不使用汇总时返回0
+func RollupWidth(step time.Duration) int {
+	tier := RollupTier(step)
+	if tier == 0 {
+		return 0
+	}
+	return int(math.Ceil(step.Seconds()/float64(tier))) * tier
+}
+
+// GetRollups 获取目标在时间范围内按step汇总的结果
+// 从不超过step的最粗层级读取，再合并为step长度的时间段（step向上取整为层级长度的整数倍）
+func (db *DB) GetRollups(targetID string, since, until time.Time, step time.Duration) ([]models.Rollup, error) {
+	tier := RollupTier(step)
+	if tier == 0 {
+		return nil, fmt.Errorf("时间分辨率不能小于%d秒", RollupMinute)
+	}
+	width := int64(RollupWidth(step))
+
+	query := `SELECT bucket, count, success, min, max, sum, histogram FROM ping_rollups
+			  WHERE target_id = ? AND resolution = ? AND bucket >= ? AND bucket <= ?
+			  ORDER BY bucket ASC`
+	origin := bucketStart(since, tier).Unix()
+	rows, err := db.conn.Query(query, targetID, tier, origin, until.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRollups(rows, targetID, origin, width)
+}
+
+// scanRollups 读取按时间排序的汇总记录，从origin开始合并为width秒的时间段
+func scanRollups(rows *sql.Rows, targetID string, origin, width int64) ([]models.Rollup, error) {
+	var starts []int64
+	groups := make(map[int64]*rollup)
+	for rows.Next() {
+		var bucket int64
+		var data string
+		item := newRollup()
+		if err := rows.Scan(&bucket, &item.count, &item.success, &item.min, &item.max, &item.sum, &data); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(data), &item.histogram); err != nil {
+			return nil, fmt.Errorf("解析汇总直方图失败: %v", err)
+		}
+
+		start := origin + (bucket-origin)/width*width
+		group, ok := groups[start]
+		if !ok {
+			group = newRollup()
+			groups[start] = group
+			starts = append(starts, start)
+		}
+		group.merge(item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	results := make([]models.Rollup, 0, len(starts))
+	for _, start := range starts {
+		results = append(results, groups[start].result(targetID, time.Unix(start, 0), int(width)))
+	}
+	return results, nil
+}
+
+// BackfillRollups 由原始结果重新计算since之后的汇总，用于为升级前的数据补充汇总
+// 按目标逐日处理，每天在一个写事务中完成，可以在服务运行时执行；progress在每个目标完成后调用
+func (db *DB) BackfillRollups(ctx context.Context, since time.Time, progress func(targetID string, days, results int)) error {
+	targets, err := db.LoadTargets()
+	if err != nil {
+		return err
+	}
+	ids := make([]string, 0, len(targets))
+	for id := range targets {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		var first, last time.Time
+		query := "SELECT timestamp FROM ping_results WHERE target_id = ? AND timestamp >= ? ORDER BY timestamp %s LIMIT 1"
+		err := db.conn.QueryRow(fmt.Sprintf(query, "ASC"), id, since).Scan(&first)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := db.conn.QueryRow(fmt.Sprintf(query, "DESC"), id, since).Scan(&last); err != nil {
+			return err
+		}
+
+		days, total := 0, 0
+		for day := bucketStart(first, RollupDay); !day.After(last); day = day.AddDate(0, 0, 1) {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			n, err := db.backfillDay(ctx, id, day)
+			if err != nil {
+				return fmt.Errorf("补充目标 %s 在 %s 的汇总失败: %v", id, day.Format("2006-01-02"), err)
+			}
+			days++
+			total += n
+		}
+		if progress != nil {
+			progress(id, days, total)
+		}
+	}
+	return nil
+}
+
+// backfillDay 由原始结果重新计算目标某一天的各层级汇总，返回处理的结果数
+func (db *DB) backfillDay(ctx context.Context, targetID string, day time.Time) (int, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	// 立即获取写锁，避免读取原始结果后、写入汇总前有新结果计入同一时间段
+	conn, err := db.conn.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return 0, err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			conn.ExecContext(context.Background(), "ROLLBACK")
+		}
+	}()
+
+	next := day.AddDate(0, 0, 1)
+	query := `SELECT latency, success, timestamp FROM ping_results
+			  WHERE target_id = ? AND timestamp >= ? AND timestamp < ?`
+	rows, err := conn.QueryContext(ctx, query, targetID, day, next)
+	if err != nil {
+		return 0, err
+	}
+	buckets := make(map[int]map[int64]*rollup)
+	for _, resolution := range RollupTiers {
+		buckets[resolution] = make(map[int64]*rollup)
+	}
+	count := 0
+	for rows.Next() {
+		var latency float64
+		var success bool
+		var timestamp time.Time
+		if err := rows.Scan(&latency, &success, &timestamp); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		for _, resolution := range RollupTiers {
+			bucket := bucketStart(timestamp, resolution).Unix()
+			item, ok := buckets[resolution][bucket]
+			if !ok {
+				item = newRollup()
+				buckets[resolution][bucket] = item
+			}
+			item.add(latency, success)
+		}
+		count++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// 替换当天已有的汇总
+	_, err = conn.ExecContext(ctx, `DELETE FROM ping_rollups WHERE target_id = ? AND
+		((resolution < ? AND bucket >= ? AND bucket < ?) OR (resolution = ? AND bucket = ?))`,
+		targetID, RollupDay, day.Unix(), next.Unix(), RollupDay, day.Unix())
+	if err != nil {
+		return 0, err
+	}
+	for resolution, items := range buckets {
+		for bucket, item := range items {
+			if err := saveRollup(ctx, conn, sqliteRollupSQL, targetID, resolution, bucket, item); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if _, err := conn.ExecContext(ctx, "COMMIT"); err != nil {
+		return 0, err
+	}
+	committed = true
+	return count, nil
+}
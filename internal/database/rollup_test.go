@@ -0,0 +1,207 @@
+package database
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"scallop/internal/models"
+)
+
+// histogramError 分桶估算的最大相对误差：取桶上下界的几何中点，误差不超过sqrt(1.1)-1
+var histogramError = math.Sqrt(histogramGrowth) - 1
+
+// exactQuantile 按最近秩法计算已排序数据的分位数，与直方图的秩一致
+func exactQuantile(sorted []float64, q float64) float64 {
+	return sorted[int(math.Ceil(q*float64(len(sorted))))-1]
+}
+
+func TestRollupQuantiles(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	distributions := map[string]func() float64{
+		"对数正态":  func() float64 { return 20 * math.Exp(random.NormFloat64()*0.5) },
+		"均匀":    func() float64 { return 1 + random.Float64()*99 },
+		"双峰":    func() float64 { return []float64{5, 150}[random.Intn(2)] + random.Float64() },
+		"长尾":    func() float64 { return 10 + random.ExpFloat64()*40 },
+		"亚毫秒级":  func() float64 { return 0.1 + random.Float64()*0.5 },
+		"少量结果":  func() float64 { return 30 + random.Float64()*10 },
+		"单一延迟值": func() float64 { return 42 },
+	}
+
+	for name, next := range distributions {
+		n := 10000
+		if name == "少量结果" {
+			n = 7
+		}
+		item := newRollup()
+		var latencies []float64
+		for i := 0; i < n; i++ {
+			latency := next()
+			latencies = append(latencies, latency)
+			item.add(latency, true)
+			// 失败的结果不计入延迟分布
+			if i%10 == 0 {
+				item.add(0, false)
+			}
+		}
+		sort.Float64s(latencies)
+
+		result := item.result("t", time.Now(), RollupMinute)
+		for q, got := range map[float64]float64{0.50: result.P50, 0.95: result.P95, 0.99: result.P99} {
+			want := exactQuantile(latencies, q)
+			if math.Abs(got-want)/want > histogramError {
+				t.Errorf("%s: p%.0f为%.4f，精确值为%.4f，误差超过%.1f%%", name, q*100, got, want, histogramError*100)
+			}
+		}
+		if result.Min != latencies[0] || result.Max != latencies[n-1] {
+			t.Errorf("%s: min/max为%v/%v", name, result.Min, result.Max)
+		}
+		if result.Count != int64(n+(n+9)/10) || result.Success != int64(n) {
+			t.Errorf("%s: count=%d success=%d", name, result.Count, result.Success)
+		}
+	}
+}
+
+func TestRollupMerge(t *testing.T) {
+	// 分成多个时间段汇总后合并，与直接汇总全部结果相同
+	random := rand.New(rand.NewSource(2))
+	whole := newRollup()
+	merged := newRollup()
+	for part := 0; part < 10; part++ {
+		item := newRollup()
+		for i := 0; i < 100; i++ {
+			latency := 5 + random.ExpFloat64()*20
+			success := random.Intn(20) != 0
+			whole.add(latency, success)
+			item.add(latency, success)
+		}
+		merged.merge(item)
+	}
+	merged.merge(newRollup())
+
+	start := time.Now()
+	a, b := whole.result("t", start, RollupHour), merged.result("t", start, RollupHour)
+	if math.Abs(a.Avg-b.Avg) > 1e-9 {
+		t.Fatalf("平均值不同: %v %v", a.Avg, b.Avg)
+	}
+	a.Avg, b.Avg = 0, 0
+	if a != b {
+		t.Fatalf("合并结果不同:\n%+v\n%+v", a, b)
+	}
+
+	// 没有成功结果的时间段只有丢包率
+	failed := newRollup()
+	failed.add(0, false)
+	if result := failed.result("t", start, RollupMinute); result.Loss != 100 || result.P50 != 0 || result.Max != 0 {
+		t.Fatalf("全部失败的时间段: %+v", result)
+	}
+}
+
+func TestRollupTierSelection(t *testing.T) {
+	tests := []struct {
+		step  time.Duration
+		tier  int
+		width int
+	}{
+		{0, 0, 0},
+		{59 * time.Second, 0, 0},
+		{time.Minute, RollupMinute, 60},
+		{61 * time.Second, RollupMinute, 120},
+		{90 * time.Second, RollupMinute, 120},
+		{3599 * time.Second, RollupMinute, 3600},
+		{time.Hour, RollupHour, 3600},
+		{time.Hour + time.Second, RollupHour, 7200},
+		{23*time.Hour + 59*time.Minute, RollupHour, 86400},
+		{24 * time.Hour, RollupDay, 86400},
+		{36 * time.Hour, RollupDay, 172800},
+		{7 * 24 * time.Hour, RollupDay, 7 * 86400},
+		// 常用时间范围按500个点计算的步长
+		{24 * time.Hour / 500, RollupMinute, 180},
+		{7 * 24 * time.Hour / 500, RollupMinute, 1260},
+		{30 * 24 * time.Hour / 500, RollupHour, 7200},
+	}
+	for _, test := range tests {
+		if tier := RollupTier(test.step); tier != test.tier {
+			t.Errorf("step=%v: 层级为%d，应为%d", test.step, tier, test.tier)
+		}
+		if width := RollupWidth(test.step); width != test.width {
+			t.Errorf("step=%v: 时间段为%d秒，应为%d秒", test.step, width, test.width)
+		}
+	}
+}
+
+func TestBackfillRollups(t *testing.T) {
+	db, err := New(filepath.Join(t.TempDir(), "scallop.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.SyncTargets([]models.IPTarget{{ID: "a", Addr: "1.1.1.1"}, {ID: "b", Addr: "8.8.8.8"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 跨越昨天开始时的日期边界，保存时即时更新的汇总作为对照
+	start := bucketStart(time.Now(), RollupDay).AddDate(0, 0, -1).Add(-90 * time.Minute)
+	saveResults(t, db, "a", start, 180)
+	saveResults(t, db, "b", start.Add(2*time.Hour), 5)
+	query := func(targetID string, step time.Duration) []models.Rollup {
+		rollups, err := db.GetRollups(targetID, start.Add(-48*time.Hour), time.Now(), step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rollups
+	}
+	steps := []time.Duration{time.Minute, time.Hour, 24 * time.Hour}
+	expected := make(map[string][][]models.Rollup)
+	for _, id := range []string{"a", "b"} {
+		for _, step := range steps {
+			expected[id] = append(expected[id], query(id, step))
+		}
+	}
+	if len(expected["a"][0]) != 180 || len(expected["a"][2]) != 2 {
+		t.Fatalf("对照汇总不正确: %d个分钟汇总，%d个天汇总", len(expected["a"][0]), len(expected["a"][2]))
+	}
+
+	// 模拟升级前只有原始结果的数据库
+	if _, err := db.conn.Exec("DELETE FROM ping_rollups"); err != nil {
+		t.Fatal(err)
+	}
+
+	progress := make(map[string][2]int)
+	err = db.BackfillRollups(context.Background(), time.Time{}, func(targetID string, days, results int) {
+		progress[targetID] = [2]int{days, results}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress["a"] != [2]int{2, 1080} || progress["b"] != [2]int{1, 30} {
+		t.Fatalf("进度不正确: %v", progress)
+	}
+	for _, id := range []string{"a", "b"} {
+		for i, step := range steps {
+			if got := query(id, step); !reflect.DeepEqual(got, expected[id][i]) {
+				t.Errorf("%s step=%v: 补充的汇总与即时更新的不同:\n%+v\n%+v", id, step, got, expected[id][i])
+			}
+		}
+	}
+
+	// 重复执行结果不变；since之前的日期不处理
+	if err := db.BackfillRollups(context.Background(), start.Add(2*time.Hour), nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := query("a", time.Hour); !reflect.DeepEqual(got, expected["a"][1]) {
+		t.Errorf("重复补充后汇总发生变化:\n%+v\n%+v", got, expected["a"][1])
+	}
+
+	// 取消后立即停止
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := db.BackfillRollups(ctx, time.Time{}, nil); err != context.Canceled {
+		t.Fatalf("取消后返回%v", err)
+	}
+}
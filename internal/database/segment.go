@@ -0,0 +1,224 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"scallop/internal/models"
+)
+
+// SegmentStore 分段存储，用于高频采样：Ping结果和附加指标按目标压缩为块文件，每个数据点通常只占几个字节；
+// 目标、事件、探测详情和汇总等其余数据仍保存在SQLite数据库中，长时间范围的查询同样使用汇总
+type SegmentStore struct {
+	*DB
+	chunks *chunkStore
+}
+
+var _ Store = (*SegmentStore)(nil)
+
+// NewSegment 打开分段存储，dbPath为保存其余数据的SQLite数据库，dir为块文件目录
+func NewSegment(dbPath, dir string, options *models.Segment) (*SegmentStore, error) {
+	db, err := New(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := openChunkStore(dir, options)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SegmentStore{DB: db, chunks: chunks}, nil
+}
+
+// Close 将未写满的块保存为块文件，再关闭数据库
+func (s *SegmentStore) Close() error {
+	return errors.Join(s.chunks.close(), s.DB.Close())
+}
+
+// SyncTargets 将配置中的目标同步到数据库，按配置顺序返回对应的目标
+func (s *SegmentStore) SyncTargets(configTargets []models.IPTarget) ([]*models.Target, error) {
+	return syncTargets(s, configTargets)
+}
+
+// MergeTarget 将目标from的历史数据合并到目标to并删除目标from
+func (s *SegmentStore) MergeTarget(from, to string) error {
+	if err := s.DB.MergeTarget(from, to); err != nil {
+		return err
+	}
+	return s.chunks.merge(from, to)
+}
+
+// GetRetiredTargets 获取已从配置中移除、但仍保留在数据库中的目标，最近仍有结果的在前
+func (s *SegmentStore) GetRetiredTargets(active map[string]bool) ([]models.RetiredTarget, error) {
+	return retiredTargets(s, active)
+}
+
+// GetRetiredTarget 获取单个已移除的目标，目标仍在配置中或不存在时返回sql.ErrNoRows
+func (s *SegmentStore) GetRetiredTarget(id string, active map[string]bool) (models.RetiredTarget, error) {
+	return getRetiredTarget(s, id, active)
+}
+
+// resultSpan 由块索引统计目标的结果数量和首末结果时间，不需要读取块文件
+func (s *SegmentStore) resultSpan(targetID string) (count int, first, last time.Time, err error) {
+	count, min, max := s.chunks.span(seriesKey{target: targetID})
+	if count > 0 {
+		first, last = time.UnixMilli(min), time.UnixMilli(max)
+	}
+	return count, first, last, nil
+}
+
+// PurgeTarget 删除目标及其全部历史数据
+func (s *SegmentStore) PurgeTarget(id string) error {
+	if err := s.DB.PurgeTarget(id); err != nil {
+		return err
+	}
+	return s.chunks.purgeTarget(id)
+}
+
+// SavePingResult 将Ping结果写入目标正在写入的块，汇总仍在SQLite中更新
+// 结果的时间只保留到毫秒
+func (s *SegmentStore) SavePingResult(result models.PingResult) error {
+	p := point{t: result.Timestamp.UnixMilli(), v: result.Latency, ok: result.Success}
+	if err := s.chunks.append(seriesKey{target: result.TargetID}, p); err != nil {
+		return err
+	}
+	return s.write(func(ctx context.Context, tx execer) error {
+		return addToRollups(ctx, tx, sqliteRollupSQL, result)
+	})
+}
+
+// GetPingResults 查询指定目标在时间范围内的Ping结果
+func (s *SegmentStore) GetPingResults(targetID string, since, until time.Time) ([]models.PingResult, error) {
+	points, err := s.chunks.query(seriesKey{target: targetID}, since.UnixMilli(), until.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	results := make([]models.PingResult, 0, len(points))
+	for _, p := range points {
+		results = append(results, pingResult(targetID, p))
+	}
+	return results, nil
+}
+
+// pingResult 由数据点还原Ping结果
+func pingResult(targetID string, p point) models.PingResult {
+	return models.PingResult{TargetID: targetID, Latency: p.v, Success: p.ok, Timestamp: time.UnixMilli(p.t)}
+}
+
+// GetLatestResults 获取每个目标最新的一条Ping结果
+func (s *SegmentStore) GetLatestResults() ([]models.PingResult, error) {
+	results := []models.PingResult{}
+	for _, key := range s.chunks.keys() {
+		if key.metric != "" {
+			continue
+		}
+		p, ok, err := s.chunks.last(key, func(point) bool { return true })
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			results = append(results, pingResult(key.target, p))
+		}
+	}
+	return results, nil
+}
+
+// GetLastSuccess 获取目标最近一次成功结果的时间，没有成功结果时返回零值
+func (s *SegmentStore) GetLastSuccess(targetID string) (time.Time, error) {
+	p, ok, err := s.chunks.last(seriesKey{target: targetID}, func(p point) bool { return p.ok })
+	if err != nil || !ok {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(p.t), nil
+}
+
+// SaveMetrics 将一次探测产生的附加指标分别写入各指标正在写入的块
+func (s *SegmentStore) SaveMetrics(targetID string, timestamp time.Time, metrics map[string]float64) error {
+	for name, value := range metrics {
+		p := point{t: timestamp.UnixMilli(), v: value, ok: true}
+		if err := s.chunks.append(seriesKey{target: targetID, metric: name}, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetMetrics 查询指定目标在时间范围内的附加指标，name为空时返回全部指标
+func (s *SegmentStore) GetMetrics(targetID, name string, since, until time.Time) ([]models.Metric, error) {
+	metrics := []models.Metric{}
+	for _, key := range s.chunks.keys() {
+		if key.target != targetID || key.metric == "" || (name != "" && key.metric != name) {
+			continue
+		}
+		points, err := s.chunks.query(key, since.UnixMilli(), until.UnixMilli())
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range points {
+			metrics = append(metrics, models.Metric{TargetID: targetID, Name: key.metric, Value: p.v, Timestamp: time.UnixMilli(p.t)})
+		}
+	}
+	sort.SliceStable(metrics, func(i, j int) bool {
+		return metrics[i].Timestamp.Before(metrics[j].Timestamp)
+	})
+	return metrics, nil
+}
+
+// PurgeExpired 按保留策略删除过期的汇总，以及块文件中过期的Ping结果和附加指标
+func (s *SegmentStore) PurgeExpired(ctx context.Context, policy RetentionPolicy) (models.PurgeResult, error) {
+	result, err := s.DB.PurgeExpired(ctx, policy)
+	if err != nil {
+		return result, err
+	}
+
+	for _, key := range s.chunks.keys() {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		retention, ok := policy.Targets[key.target]
+		if !ok {
+			retention = policy.Default
+		}
+		if retention <= 0 {
+			continue
+		}
+		var deleted int64
+		deleted, err = s.chunks.purge(key, result.StartedAt.Add(-retention).UnixMilli())
+		if key.metric == "" {
+			result.ResultsDeleted += deleted
+		} else {
+			result.MetricsDeleted += deleted
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	result.Duration = float64(time.Since(result.StartedAt).Microseconds()) / 1000
+	s.lastPurge.Store(&result)
+	return result, err
+}
+
+// Stats 获取SQLite数据库的空间占用、块文件的数量和压缩效果
+func (s *SegmentStore) Stats() (models.DatabaseStats, error) {
+	stats, err := s.DB.Stats()
+	stats.Backend = "segment"
+	stats.Segments = s.chunks.stats()
+
+	stats.OldestResult = time.Time{}
+	for _, key := range s.chunks.keys() {
+		if key.metric != "" {
+			continue
+		}
+		if count, min, _ := s.chunks.span(key); count > 0 {
+			if first := time.UnixMilli(min); stats.OldestResult.IsZero() || first.Before(stats.OldestResult) {
+				stats.OldestResult = first
+			}
+		}
+	}
+	return stats, err
+}
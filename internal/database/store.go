@@ -0,0 +1,249 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"scallop/internal/models"
+)
+
+// Store 监控数据的存储，Web服务和监控器只通过它读写数据
+// 查询的记录不存在时返回sql.ErrNoRows
+type Store interface {
+	// 目标
+	SyncTargets(configTargets []models.IPTarget) ([]*models.Target, error)
+	LoadTargets() (map[string]*models.Target, error)
+	GetTarget(id string) (*models.Target, error)
+	GetRetiredTargets(active map[string]bool) ([]models.RetiredTarget, error)
+	GetRetiredTarget(id string, active map[string]bool) (models.RetiredTarget, error)
+	PurgeTarget(id string) error
+
+	// Ping结果、汇总和附加指标
+	SavePingResult(result models.PingResult) error
+	GetPingResults(targetID string, since, until time.Time) ([]models.PingResult, error)
+	GetLatestResults() ([]models.PingResult, error)
+	GetLastSuccess(targetID string) (time.Time, error)
+	GetRollups(targetID string, since, until time.Time, step time.Duration) ([]models.Rollup, error)
+	SaveMetrics(targetID string, timestamp time.Time, metrics map[string]float64) error
+	GetMetrics(targetID, name string, since, until time.Time) ([]models.Metric, error)
+
+	// 事件、探测详情、解析地址和访客延迟
+	SaveEvent(event models.Event) error
+	GetEvents(targetID string, since, until time.Time) ([]models.Event, error)
+	SaveTargetInfo(targetID, kind string, data interface{}) error
+	GetTargetInfo(targetID, kind string) (json.RawMessage, time.Time, error)
+	SaveResolvedAddress(addr models.ResolvedAddress) error
+	GetLatestAddresses() (map[string]models.ResolvedAddress, error)
+	GetAddressHistory(targetID string, since, until time.Time) ([]models.ResolvedAddress, error)
+	SaveRUMSample(sample models.RUMSample) error
+	GetRUMSamples(since, until time.Time) ([]models.RUMSample, error)
+
+	// 维护
+	PurgeExpired(ctx context.Context, policy RetentionPolicy) (models.PurgeResult, error)
+	Stats() (models.DatabaseStats, error)
+	Close() error
+}
+
+// targetStore 目标同步和已移除目标的查询在各存储间共用的基本操作
+type targetStore interface {
+	LoadTargets() (map[string]*models.Target, error)
+	GetTarget(id string) (*models.Target, error)
+	SaveTarget(target *models.Target) error
+	MergeTarget(from, to string) error
+	GetTargetInfo(targetID, kind string) (json.RawMessage, time.Time, error)
+	SaveTargetInfo(targetID, kind string, data interface{}) error
+	// resultSpan 目标的结果数量和首末结果时间
+	resultSpan(targetID string) (count int, first, last time.Time, err error)
+}
+
+// syncTargets 将配置中的目标同步到存储，按配置顺序返回对应的目标
+// 返回的目标都是新创建的对象，可直接放入目标注册表
+func syncTargets(store targetStore, configTargets []models.IPTarget) ([]*models.Target, error) {
+	// 先从数据库加载现有目标
+	existingTargets, err := store.LoadTargets()
+	if err != nil {
+		return nil, err
+	}
+
+	// 当前配置使用的所有目标ID，仍在使用的目标不会被合并
+	active := make(map[string]bool)
+	for _, configTarget := range configTargets {
+		active[TargetID(configTarget)] = true
+	}
+
+	// 创建新的目标列表
+	newTargets := make([]*models.Target, 0, len(configTargets))
+
+	for _, configTarget := range configTargets {
+		targetID := TargetID(configTarget)
+		merges := mergeSources(configTarget, targetID, active, existingTargets)
+
+		// 检查是否已存在
+		target, exists := existingTargets[targetID]
+		if exists {
+			specChanged := !reflect.DeepEqual(target.Spec, configTarget)
+			target.UpdatedAt = time.Now()
+			target.Spec = configTarget
+
+			// 配置了id的目标以配置为准更新地址、描述等字段，配置项变化时一并保存
+			if specChanged || target.Addr != configTarget.Addr || target.Description != configTarget.Description ||
+				target.HideAddr != configTarget.HideAddr || target.DNSServer != configTarget.DNSServer {
+				target.Addr = configTarget.Addr
+				target.Description = configTarget.Description
+				target.HideAddr = configTarget.HideAddr
+				target.DNSServer = configTarget.DNSServer
+				if err := store.SaveTarget(target); err != nil {
+					return nil, err
+				}
+			}
+		} else {
+			// 创建新目标，由旧目标改名而来时沿用最早的创建时间
+			target = &models.Target{
+				ID:          targetID,
+				Addr:        configTarget.Addr,
+				Description: configTarget.Description,
+				HideAddr:    configTarget.HideAddr,
+				DNSServer:   configTarget.DNSServer,
+				CreatedAt:   time.Now(),
+				UpdatedAt:   time.Now(),
+				Spec:        configTarget,
+			}
+			for _, previousID := range merges {
+				if createdAt := existingTargets[previousID].CreatedAt; createdAt.Before(target.CreatedAt) {
+					target.CreatedAt = createdAt
+				}
+			}
+
+			if err := store.SaveTarget(target); err != nil {
+				return nil, err
+			}
+			if len(merges) == 0 {
+				fmt.Printf("添加新目标: %s (%s)\n", target.Description, target.Addr)
+			}
+		}
+
+		for _, previousID := range merges {
+			if err := store.MergeTarget(previousID, targetID); err != nil {
+				return nil, err
+			}
+			delete(existingTargets, previousID)
+			fmt.Printf("已将目标 %s 的历史数据合并到 %s (%s)\n", previousID, targetID, target.Description)
+		}
+
+		if configTarget.ProbeType() == models.ProbeHeartbeat {
+			token, err := heartbeatToken(store, targetID, configTarget.Heartbeat)
+			if err != nil {
+				return nil, err
+			}
+			target.HeartbeatToken = token
+		}
+		newTargets = append(newTargets, target)
+	}
+
+	return newTargets, nil
+}
+
+// mergeSources 需要合并到目标的旧目标ID：previous_ids中列出的，以及配置了id的目标原先按字段生成的ID
+// 只合并数据库中仍存在且当前配置未使用的目标，合并完成后旧目标被删除，因此每个旧目标只会迁移一次
+func mergeSources(spec models.IPTarget, targetID string, active map[string]bool, existing map[string]*models.Target) []string {
+	candidates := spec.PreviousIDs
+	if spec.ID != "" {
+		candidates = append(candidates[:len(candidates):len(candidates)],
+			GenerateTargetID(spec.Addr, spec.Description, spec.HideAddr, spec.DNSServer))
+	}
+
+	var sources []string
+	for _, id := range candidates {
+		if id == targetID || active[id] {
+			continue
+		}
+		if _, ok := existing[id]; ok {
+			sources = append(sources, id)
+		}
+	}
+	return sources
+}
+
+// heartbeatToken 获取心跳目标的令牌：优先使用配置中的令牌，否则使用数据库中保存的令牌，没有时生成新令牌
+func heartbeatToken(store targetStore, targetID string, opts *models.HeartbeatOptions) (string, error) {
+	if opts != nil && opts.Token != "" {
+		return opts.Token, nil
+	}
+
+	if data, _, err := store.GetTargetInfo(targetID, "heartbeat_token"); err == nil {
+		var token string
+		if json.Unmarshal(data, &token) == nil && token != "" {
+			return token, nil
+		}
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+	if err := store.SaveTargetInfo(targetID, "heartbeat_token", token); err != nil {
+		return "", err
+	}
+	fmt.Printf("心跳目标 %s 的上报地址: /api/heartbeat/%s\n", targetID, token)
+	return token, nil
+}
+
+// retiredTargets 获取已从配置中移除、但仍保留在存储中的目标，最近仍有结果的在前
+func retiredTargets(store targetStore, active map[string]bool) ([]models.RetiredTarget, error) {
+	targets, err := store.LoadTargets()
+	if err != nil {
+		return nil, err
+	}
+
+	retired := []models.RetiredTarget{}
+	for id, target := range targets {
+		if active[id] {
+			continue
+		}
+		item, err := retiredTarget(store, target)
+		if err != nil {
+			return nil, err
+		}
+		retired = append(retired, item)
+	}
+
+	// 按最后一次结果的时间倒序排列，没有结果的按更新时间
+	lastSeen := func(target models.RetiredTarget) time.Time {
+		if target.ResultCount > 0 {
+			return target.LastResult
+		}
+		return target.UpdatedAt
+	}
+	sort.Slice(retired, func(i, j int) bool {
+		return lastSeen(retired[i]).After(lastSeen(retired[j]))
+	})
+	return retired, nil
+}
+
+// getRetiredTarget 获取单个已移除的目标，目标仍在配置中或不存在时返回sql.ErrNoRows
+func getRetiredTarget(store targetStore, id string, active map[string]bool) (models.RetiredTarget, error) {
+	if active[id] {
+		return models.RetiredTarget{}, sql.ErrNoRows
+	}
+	target, err := store.GetTarget(id)
+	if err != nil {
+		return models.RetiredTarget{}, err
+	}
+	return retiredTarget(store, target)
+}
+
+// retiredTarget 统计目标的结果数量和首末结果时间
+func retiredTarget(store targetStore, target *models.Target) (models.RetiredTarget, error) {
+	item := models.RetiredTarget{Target: *target}
+	var err error
+	item.ResultCount, item.FirstResult, item.LastResult, err = store.resultSpan(target.ID)
+	return item, err
+}
@@ -0,0 +1,262 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"scallop/internal/models"
+)
+
+// testStores 对各存储运行同一组检查，每个存储使用独立的临时目录
+func testStores(t *testing.T, check func(t *testing.T, store Store)) {
+	stores := map[string]func(t *testing.T) (Store, error){
+		"sqlite": func(t *testing.T) (Store, error) {
+			return New(filepath.Join(t.TempDir(), "scallop.db"))
+		},
+		"memory": func(t *testing.T) (Store, error) {
+			return NewMemory(1000, "")
+		},
+		"segment": func(t *testing.T) (Store, error) {
+			dir := t.TempDir()
+			return NewSegment(filepath.Join(dir, "scallop.db"), filepath.Join(dir, "segments"), testSegment)
+		},
+	}
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			store, err := open(t)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			check(t, store)
+		})
+	}
+}
+
+// saveResults 保存每10秒一条的Ping结果，每分钟的第3条失败，延迟为分钟内的序号加10
+func saveResults(t *testing.T, store Store, targetID string, start time.Time, minutes int) {
+	t.Helper()
+	for i := 0; i < minutes*6; i++ {
+		result := models.PingResult{
+			TargetID:  targetID,
+			Latency:   float64(i%6 + 10),
+			Success:   i%6 != 2,
+			Timestamp: start.Add(time.Duration(i) * 10 * time.Second),
+		}
+		if !result.Success {
+			result.Latency = 0
+		}
+		if err := store.SavePingResult(result); err != nil {
+			t.Fatal(err)
+		}
+		metrics := map[string]float64{"dns_ms": float64(i)}
+		if err := store.SaveMetrics(targetID, result.Timestamp, metrics); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestStoreSyncTargets(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		a := models.IPTarget{ID: "a", Addr: "1.1.1.1", Description: "A"}
+		b := models.IPTarget{Addr: "8.8.8.8", Description: "B"}
+		targets, err := store.SyncTargets([]models.IPTarget{a, b})
+		if err != nil {
+			t.Fatal(err)
+		}
+		oldB := GenerateTargetID(b.Addr, b.Description, b.HideAddr, b.DNSServer)
+		if len(targets) != 2 || targets[0].ID != "a" || targets[1].ID != oldB {
+			t.Fatalf("同步后的目标不正确: %+v", targets)
+		}
+		start := time.Now().Add(-time.Hour).Truncate(time.Minute)
+		saveResults(t, store, "a", start, 1)
+		saveResults(t, store, oldB, start, 2)
+
+		// b配置id后由自动生成的ID合并而来，a改名为a2并通过previous_ids合并
+		a2 := models.IPTarget{ID: "a2", PreviousIDs: []string{"a"}, Addr: "1.1.1.1", Description: "A"}
+		b.ID = "b"
+		targets, err = store.SyncTargets([]models.IPTarget{a2, b})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(targets) != 2 || targets[0].ID != "a2" || targets[1].ID != "b" {
+			t.Fatalf("合并后的目标不正确: %+v", targets)
+		}
+
+		loaded, err := store.LoadTargets()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(loaded) != 2 || loaded["a2"] == nil || loaded["b"] == nil {
+			t.Fatalf("合并后存储中的目标不正确: %v", loaded)
+		}
+		if loaded["b"].Spec.ID != "b" {
+			t.Fatalf("目标的配置未保存: %+v", loaded["b"].Spec)
+		}
+		for _, id := range []string{"a", oldB} {
+			if _, err := store.GetTarget(id); !errors.Is(err, sql.ErrNoRows) {
+				t.Fatalf("合并后的旧目标%s应不存在，得到%v", id, err)
+			}
+		}
+
+		for id, want := range map[string]int{"a2": 6, "b": 12, "a": 0, oldB: 0} {
+			results, err := store.GetPingResults(id, start, start.Add(time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != want {
+				t.Errorf("%s有%d条结果，应为%d条", id, len(results), want)
+			}
+			metrics, err := store.GetMetrics(id, "dns_ms", start, start.Add(time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(metrics) != want {
+				t.Errorf("%s有%d条附加指标，应为%d条", id, len(metrics), want)
+			}
+		}
+
+		// 再次同步相同的配置不改变目标
+		targets, err = store.SyncTargets([]models.IPTarget{a2, b})
+		if err != nil || len(targets) != 2 {
+			t.Fatalf("重复同步: %v %v", targets, err)
+		}
+	})
+}
+
+func TestStorePingResults(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		if _, err := store.SyncTargets([]models.IPTarget{{ID: "t", Addr: "1.1.1.1", Description: "T"}}); err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now().Add(-time.Hour).Truncate(time.Minute)
+		saveResults(t, store, "t", start, 2)
+
+		results, err := store.GetPingResults("t", start, start.Add(2*time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 12 {
+			t.Fatalf("查询到%d条结果，应为12条", len(results))
+		}
+		for i, result := range results {
+			if !result.Timestamp.Equal(start.Add(time.Duration(i)*10*time.Second)) || result.Success != (i%6 != 2) {
+				t.Fatalf("第%d条结果不正确: %+v", i, result)
+			}
+		}
+
+		// 时间范围两端都包含在内
+		results, err = store.GetPingResults("t", start.Add(10*time.Second), start.Add(30*time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 3 {
+			t.Fatalf("查询到%d条结果，应为3条", len(results))
+		}
+
+		latest, err := store.GetLatestResults()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(latest) != 1 || !latest[0].Timestamp.Equal(start.Add(110*time.Second)) {
+			t.Fatalf("最新结果不正确: %+v", latest)
+		}
+		lastSuccess, err := store.GetLastSuccess("t")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !lastSuccess.Equal(start.Add(110 * time.Second)) {
+			t.Fatalf("最近一次成功的时间为%v", lastSuccess)
+		}
+
+		rollups, err := store.GetRollups("t", start, start.Add(2*time.Minute), time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rollups) != 2 {
+			t.Fatalf("得到%d个汇总，应为2个: %+v", len(rollups), rollups)
+		}
+		for i, rollup := range rollups {
+			if !rollup.Timestamp.Equal(start.Add(time.Duration(i)*time.Minute)) || rollup.Resolution != RollupMinute {
+				t.Errorf("第%d个汇总的时间段不正确: %+v", i, rollup)
+			}
+			if rollup.Count != 6 || rollup.Success != 5 || rollup.Min != 10 || rollup.Max != 15 || rollup.Avg != 12.6 {
+				t.Errorf("第%d个汇总不正确: %+v", i, rollup)
+			}
+		}
+
+		// 两分钟合并为一个时间段
+		rollups, err = store.GetRollups("t", start, start.Add(2*time.Minute), 2*time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var count int64
+		for _, rollup := range rollups {
+			count += rollup.Count
+		}
+		if count != 12 {
+			t.Fatalf("按2分钟汇总共%d条结果，应为12条: %+v", count, rollups)
+		}
+	})
+}
+
+func TestStorePurgeExpired(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		targets := []models.IPTarget{
+			{ID: "old", Addr: "1.1.1.1", Description: "Old"},
+			{ID: "kept", Addr: "8.8.8.8", Description: "Kept"},
+		}
+		if _, err := store.SyncTargets(targets); err != nil {
+			t.Fatal(err)
+		}
+		old := time.Now().Add(-3 * time.Hour).Truncate(time.Minute)
+		recent := time.Now().Add(-10 * time.Minute).Truncate(time.Minute)
+		saveResults(t, store, "old", old, 1)
+		saveResults(t, store, "old", recent, 1)
+		saveResults(t, store, "kept", old, 1)
+
+		// kept单独配置了更长的保留时间，未配置保留时间的汇总不删除
+		policy := RetentionPolicy{
+			Default:   time.Hour,
+			Targets:   map[string]time.Duration{"kept": 24 * time.Hour},
+			BatchSize: 4,
+		}
+		result, err := store.PurgeExpired(context.Background(), policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.ResultsDeleted != 6 || result.MetricsDeleted != 6 || result.RollupsDeleted != 0 {
+			t.Fatalf("清理结果不正确: %+v", result)
+		}
+
+		for id, want := range map[string]int{"old": 6, "kept": 6} {
+			results, err := store.GetPingResults(id, old, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != want {
+				t.Errorf("清理后%s有%d条结果，应为%d条", id, len(results), want)
+			}
+		}
+		results, err := store.GetPingResults("old", old, old.Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 0 {
+			t.Errorf("过期的结果未删除: %+v", results)
+		}
+
+		// 没有过期数据时不删除任何内容
+		result, err = store.PurgeExpired(context.Background(), policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.ResultsDeleted != 0 || result.MetricsDeleted != 0 {
+			t.Fatalf("重复清理删除了数据: %+v", result)
+		}
+	})
+}
@@ -0,0 +1,231 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"scallop/internal/models"
+)
+
+const (
+	defaultQueueSize     = 10000
+	defaultBatchSize     = 500
+	defaultFlushInterval = 500 * time.Millisecond
+)
+
+// writeOp 队列中的一次写入
+type writeOp struct {
+	apply  func(ctx context.Context, tx execer) error
+	queued time.Time
+}
+
+// txFunc 在一个写事务中执行fn
+type txFunc func(ctx context.Context, fn func(ctx context.Context, tx execer) error) error
+
+// writer 后台批量写入探测结果，探测协程只需将结果放入队列，不必等待磁盘
+type writer struct {
+	inTx      txFunc
+	queue     chan writeOp
+	batchSize int
+	interval  time.Duration
+	done      chan struct{}
+
+	mutex  sync.RWMutex // 保护closed，关闭队列前等待正在入队的调用返回
+	closed bool
+
+	statsMutex sync.Mutex
+	stats      models.WriterStats
+	totalFlush time.Duration
+}
+
+// StartWriter 启动后台批量写入，之后的Ping结果和附加指标先进入队列再按批写入
+// 未启动时（如执行子命令）直接写入数据库
+func (db *DB) StartWriter(options *models.Writer) {
+	db.writer = newWriter(db.inTx, options)
+}
+
+// WriterStats 批量写入的运行状态，未启动时返回nil
+func (db *DB) WriterStats() *models.WriterStats {
+	return db.writer.snapshot()
+}
+
+// newWriter 创建并启动后台批量写入
+func newWriter(inTx txFunc, options *models.Writer) *writer {
+	w := &writer{
+		inTx:      inTx,
+		batchSize: defaultBatchSize,
+		interval:  defaultFlushInterval,
+		done:      make(chan struct{}),
+	}
+	queueSize := defaultQueueSize
+	if options != nil {
+		if options.QueueSize > 0 {
+			queueSize = options.QueueSize
+		}
+		if options.BatchSize > 0 {
+			w.batchSize = options.BatchSize
+		}
+		if options.FlushInterval > 0 {
+			w.interval = time.Duration(options.FlushInterval) * time.Millisecond
+		}
+	}
+	w.queue = make(chan writeOp, queueSize)
+	w.stats.QueueSize = queueSize
+
+	go w.run()
+	return w
+}
+
+// snapshot 当前的运行状态，w为nil时返回nil
+func (w *writer) snapshot() *models.WriterStats {
+	if w == nil {
+		return nil
+	}
+	w.statsMutex.Lock()
+	defer w.statsMutex.Unlock()
+	stats := w.stats
+	stats.QueueDepth = len(w.queue)
+	return &stats
+}
+
+// write 执行一次写入：批量写入已启动时放入队列，否则直接在事务中写入
+func (db *DB) write(apply func(ctx context.Context, tx execer) error) error {
+	if db.writer.enqueue(writeOp{apply: apply, queued: time.Now()}) {
+		return nil
+	}
+	return db.inTx(context.Background(), apply)
+}
+
+// inTx 在一个写事务中执行fn
+func (db *DB) inTx(ctx context.Context, fn func(ctx context.Context, tx execer) error) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(ctx, tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// enqueue 将写入放入队列，队列已满时等待，以免结果产生的速度长期超过磁盘写入的速度
+// 未启动或队列已关闭时返回false
+func (w *writer) enqueue(op writeOp) bool {
+	if w == nil {
+		return false
+	}
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	if w.closed {
+		return false
+	}
+
+	select {
+	case w.queue <- op:
+		return true
+	default:
+	}
+
+	w.statsMutex.Lock()
+	w.stats.Blocked++
+	w.statsMutex.Unlock()
+	w.queue <- op
+	return true
+}
+
+// close 关闭队列，等待已入队的结果全部写入
+func (w *writer) close() {
+	if w == nil {
+		return
+	}
+	w.mutex.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mutex.Unlock()
+	<-w.done
+}
+
+// run 收集队列中的写入，达到批量大小或到达写入间隔时提交
+func (w *writer) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	batch := make([]writeOp, 0, w.batchSize)
+	for {
+		select {
+		case op, ok := <-w.queue:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+			batch = append(batch, op)
+			if len(batch) < w.batchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+		w.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+// flush 在一个事务中写入一批结果
+func (w *writer) flush(batch []writeOp) {
+	if len(batch) == 0 {
+		return
+	}
+
+	ctx := context.Background()
+	start := time.Now()
+	err := w.inTx(ctx, func(ctx context.Context, tx execer) error {
+		for _, op := range batch {
+			if err := op.apply(ctx, tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	// 一条写入失败会回滚整批，逐条重试以免丢失其余结果
+	var failed int64
+	if err != nil {
+		fmt.Printf("批量写入失败，改为逐条写入: %v\n", err)
+		for _, op := range batch {
+			if err := w.inTx(ctx, op.apply); err != nil {
+				fmt.Printf("保存数据失败: %v\n", err)
+				failed++
+			}
+		}
+	}
+	elapsed := time.Since(start)
+
+	w.statsMutex.Lock()
+	defer w.statsMutex.Unlock()
+	w.stats.Written += int64(len(batch)) - failed
+	w.stats.Failed += failed
+	w.stats.Batches++
+	w.stats.LastBatch = len(batch)
+	w.totalFlush += elapsed
+	w.stats.LastFlushMs = durationMs(elapsed)
+	w.stats.AvgFlushMs = durationMs(w.totalFlush / time.Duration(w.stats.Batches))
+	if w.stats.LastFlushMs > w.stats.MaxFlushMs {
+		w.stats.MaxFlushMs = w.stats.LastFlushMs
+	}
+	w.stats.LastLatencyMs = durationMs(time.Since(batch[0].queued))
+}
+
+// durationMs 将时长转换为毫秒
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
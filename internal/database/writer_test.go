@@ -0,0 +1,172 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"scallop/internal/models"
+)
+
+// newWriterDB 创建数据库并以指定参数启动批量写入
+func newWriterDB(t *testing.T, options *models.Writer) *DB {
+	t.Helper()
+	db, err := New(filepath.Join(t.TempDir(), "scallop.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.SyncTargets([]models.IPTarget{{ID: "a", Addr: "1.1.1.1"}}); err != nil {
+		t.Fatal(err)
+	}
+	db.StartWriter(options)
+	return db
+}
+
+// queueResults 放入n条Ping结果
+func queueResults(t *testing.T, db *DB, n int) {
+	t.Helper()
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < n; i++ {
+		if err := db.SavePingResult(models.PingResult{TargetID: "a", Latency: 10, Success: true, Timestamp: start.Add(time.Duration(i) * time.Second)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// storedResults 数据库中已提交的Ping结果数量
+func storedResults(t *testing.T, db *DB) int {
+	t.Helper()
+	var count int
+	if err := db.conn.QueryRow("SELECT COUNT(*) FROM ping_results").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+// waitStats 等待批量写入的状态满足条件，超时返回最后的状态
+func waitStats(db *DB, ok func(stats *models.WriterStats) bool) *models.WriterStats {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats := db.WriterStats()
+		if ok(stats) || time.Now().After(deadline) {
+			return stats
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWriterFlushOnSize(t *testing.T) {
+	// 写入间隔很长，只有达到批量大小时才提交
+	db := newWriterDB(t, &models.Writer{BatchSize: 10, FlushInterval: int(time.Hour / time.Millisecond)})
+	queueResults(t, db, 25)
+
+	stats := waitStats(db, func(stats *models.WriterStats) bool { return stats.Written == 20 })
+	if stats.Written != 20 || stats.Batches != 2 || stats.LastBatch != 10 {
+		t.Fatalf("状态不正确: %+v", stats)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if count := storedResults(t, db); count != 20 {
+		t.Fatalf("已提交%d条结果，应为20条", count)
+	}
+}
+
+func TestWriterFlushOnInterval(t *testing.T) {
+	// 未达到批量大小，到达写入间隔时提交
+	db := newWriterDB(t, &models.Writer{BatchSize: 1000, FlushInterval: 20})
+	queueResults(t, db, 5)
+
+	stats := waitStats(db, func(stats *models.WriterStats) bool { return stats.Written == 5 })
+	if stats.Written != 5 || stats.Batches != 1 || stats.LastBatch != 5 || stats.QueueDepth != 0 {
+		t.Fatalf("状态不正确: %+v", stats)
+	}
+	if count := storedResults(t, db); count != 5 {
+		t.Fatalf("已提交%d条结果，应为5条", count)
+	}
+}
+
+func TestWriterQueueFull(t *testing.T) {
+	db := newWriterDB(t, &models.Writer{QueueSize: 2, BatchSize: 1, FlushInterval: 10})
+
+	// 占用写锁使后台写入停在第一批，队列随后被填满
+	db.mutex.Lock()
+	queueResults(t, db, 1)
+	waitStats(db, func(stats *models.WriterStats) bool { return stats.QueueDepth == 0 })
+	queueResults(t, db, 2)
+
+	// 队列已满时等待而不是丢弃
+	done := make(chan error)
+	go func() {
+		done <- db.SavePingResult(models.PingResult{TargetID: "a", Latency: 10, Success: true, Timestamp: time.Now()})
+	}()
+	stats := waitStats(db, func(stats *models.WriterStats) bool { return stats.Blocked == 1 })
+	var err error
+	waited := true
+	select {
+	case err = <-done:
+		waited = false
+	case <-time.After(50 * time.Millisecond):
+	}
+	// 先释放写锁再检查，以免失败时关闭数据库一直等待
+	db.mutex.Unlock()
+	if !waited {
+		t.Fatalf("队列已满时没有等待: %v", err)
+	}
+	if stats.Blocked != 1 || stats.QueueDepth != 2 || stats.Written != 0 {
+		t.Fatalf("状态不正确: %+v", stats)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	stats = waitStats(db, func(stats *models.WriterStats) bool { return stats.Written == 4 })
+	if stats.Written != 4 || stats.Blocked != 1 {
+		t.Fatalf("状态不正确: %+v", stats)
+	}
+	if count := storedResults(t, db); count != 4 {
+		t.Fatalf("已提交%d条结果，应为4条", count)
+	}
+}
+
+func TestWriterBatchFailure(t *testing.T) {
+	db := newWriterDB(t, &models.Writer{BatchSize: 5, FlushInterval: int(time.Hour / time.Millisecond)})
+
+	// 一条写入失败时整批回滚，逐条重试后只丢失失败的一条
+	queueResults(t, db, 2)
+	if err := db.write(func(ctx context.Context, tx execer) error { return errors.New("写入失败") }); err != nil {
+		t.Fatal(err)
+	}
+	queueResults(t, db, 2)
+
+	stats := waitStats(db, func(stats *models.WriterStats) bool { return stats.Batches == 1 })
+	if stats.Written != 4 || stats.Failed != 1 || stats.LastBatch != 5 {
+		t.Fatalf("状态不正确: %+v", stats)
+	}
+	if count := storedResults(t, db); count != 4 {
+		t.Fatalf("已提交%d条结果，应为4条", count)
+	}
+}
+
+func TestWriterCloseDrains(t *testing.T) {
+	db := newWriterDB(t, &models.Writer{BatchSize: 1000, FlushInterval: int(time.Hour / time.Millisecond)})
+	queueResults(t, db, 300)
+	if count := storedResults(t, db); count != 0 {
+		t.Fatalf("关闭前已提交%d条结果", count)
+	}
+
+	// 关闭时写入队列中剩余的全部结果
+	db.writer.close()
+	if count := storedResults(t, db); count != 300 {
+		t.Fatalf("关闭后已提交%d条结果，应为300条", count)
+	}
+	if stats := db.WriterStats(); stats.Written != 300 || stats.Batches != 1 {
+		t.Fatalf("状态不正确: %+v", stats)
+	}
+
+	// 队列关闭后直接写入数据库
+	queueResults(t, db, 1)
+	if count := storedResults(t, db); count != 301 {
+		t.Fatalf("队列关闭后写入了%d条结果，应为301条", count)
+	}
+}
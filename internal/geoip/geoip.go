@@ -0,0 +1,165 @@
+package geoip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"scallop/internal/models"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// asnRecord GeoLite2-ASN / GeoIP2-ISP 数据库记录
+type asnRecord struct {
+	ASN uint   `maxminddb:"autonomous_system_number"`
+	Org string `maxminddb:"autonomous_system_organization"`
+}
+
+// cityRecord GeoLite2-City / GeoIP2-City 数据库记录
+type cityRecord struct {
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+// database 单个已打开的mmdb文件
+type database struct {
+	path    string
+	modTime time.Time
+	reader  *maxminddb.Reader
+}
+
+// Resolver 基于本地MaxMind格式数据库的ASN与地理位置查询
+type Resolver struct {
+	mutex sync.RWMutex
+	asn   *database
+	city  *database
+}
+
+// NewResolver 创建查询器，未加载数据库时查询结果为空
+func NewResolver() *Resolver {
+	return &Resolver{}
+}
+
+// Load 按配置打开数据库，路径未变化且文件未修改时保留已打开的数据库
+func (r *Resolver) Load(opts *models.GeoIPOptions) error {
+	var asnPath, cityPath string
+	if opts != nil {
+		asnPath, cityPath = opts.ASNDatabase, opts.CityDatabase
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var firstErr error
+	if err := reopen(&r.asn, asnPath); err != nil {
+		firstErr = err
+	}
+	if err := reopen(&r.city, cityPath); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+// Watch 定期检查数据库文件，文件更新后重新打开，直到ctx取消
+func (r *Resolver) Watch(ctx context.Context) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		r.mutex.Lock()
+		for _, db := range []**database{&r.asn, &r.city} {
+			if *db == nil {
+				continue
+			}
+			if err := reopen(db, (*db).path); err != nil {
+				fmt.Printf("重新加载GeoIP数据库失败: %v\n", err)
+			}
+		}
+		r.mutex.Unlock()
+	}
+}
+
+// Lookup 查询IP地址的ASN、组织、国家和城市
+func (r *Resolver) Lookup(addr string) models.GeoInfo {
+	var info models.GeoInfo
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return info
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if r.asn != nil {
+		var record asnRecord
+		if err := r.asn.reader.Lookup(ip, &record); err == nil {
+			info.ASN = record.ASN
+			info.Org = record.Org
+		}
+	}
+
+	if r.city != nil {
+		var record cityRecord
+		if err := r.city.reader.Lookup(ip, &record); err == nil {
+			info.Country = record.Country.ISOCode
+			info.CountryName = localizedName(record.Country.Names)
+			info.City = localizedName(record.City.Names)
+		}
+	}
+
+	return info
+}
+
+// reopen 在路径变化或文件被修改时重新打开数据库，失败时保留原数据库
+func reopen(db **database, path string) error {
+	if path == "" {
+		if *db != nil {
+			(*db).reader.Close()
+			*db = nil
+		}
+		return nil
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("GeoIP数据库不可用 %s: %v", path, err)
+	}
+	if *db != nil && (*db).path == path && (*db).modTime.Equal(stat.ModTime()) {
+		return nil
+	}
+
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return fmt.Errorf("打开GeoIP数据库失败 %s: %v", path, err)
+	}
+
+	if *db != nil {
+		(*db).reader.Close()
+	}
+	*db = &database{path: path, modTime: stat.ModTime(), reader: reader}
+	fmt.Printf("已加载GeoIP数据库: %s (%s)\n", path, reader.Metadata.DatabaseType)
+	return nil
+}
+
+// localizedName 优先使用中文名称
+func localizedName(names map[string]string) string {
+	if name := names["zh-CN"]; name != "" {
+		return name
+	}
+	return names["en"]
+}
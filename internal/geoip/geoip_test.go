@@ -0,0 +1,139 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"scallop/internal/models"
+)
+
+// mmdbString 按MaxMind DB格式编码UTF-8字符串
+func mmdbString(value string) []byte {
+	if len(value) < 29 {
+		return append([]byte{2<<5 | byte(len(value))}, value...)
+	}
+	return append([]byte{2<<5 | 29, byte(len(value) - 29)}, value...)
+}
+
+// mmdbUint 按MaxMind DB格式编码无符号整数，kind为5（uint16）或6（uint32）
+func mmdbUint(kind byte, value uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], value)
+	data := bytes.TrimLeft(buf[:], "\x00")
+	return append([]byte{kind<<5 | byte(len(data))}, data...)
+}
+
+// mmdbMap 按MaxMind DB格式编码键值对，values中依次为键和已编码的值
+func mmdbMap(values ...interface{}) []byte {
+	data := []byte{7<<5 | byte(len(values)/2)}
+	for i := 0; i < len(values); i += 2 {
+		data = append(data, mmdbString(values[i].(string))...)
+		data = append(data, values[i+1].([]byte)...)
+	}
+	return data
+}
+
+// writeASNDatabase 生成只有一条记录的IPv4 ASN数据库，所有地址都查询到这条记录
+// 先写入临时文件再改名替换，与geoipupdate更新数据库的方式相同
+func writeASNDatabase(t *testing.T, path string, asn uint32, org string, modTime time.Time) {
+	t.Helper()
+	// 搜索树只有一个节点，左右记录都指向数据区开头：节点数 + 16字节分隔符 + 偏移0
+	var data []byte
+	data = append(data, 0, 0, 17, 0, 0, 17)
+	data = append(data, make([]byte, 16)...)
+	data = append(data, mmdbMap(
+		"autonomous_system_number", mmdbUint(6, asn),
+		"autonomous_system_organization", mmdbString(org),
+	)...)
+	data = append(data, "\xAB\xCD\xEFMaxMind.com"...)
+	data = append(data, mmdbMap(
+		"node_count", mmdbUint(6, 1),
+		"record_size", mmdbUint(5, 24),
+		"ip_version", mmdbUint(5, 4),
+		"database_type", mmdbString("GeoLite2-ASN"),
+		"binary_format_major_version", mmdbUint(5, 2),
+		"binary_format_minor_version", mmdbUint(5, 0),
+	)...)
+	writeFile(t, path, data, modTime)
+}
+
+func writeFile(t *testing.T, path string, data []byte, modTime time.Time) {
+	t.Helper()
+	temp := path + ".tmp"
+	if err := os.WriteFile(temp, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(temp, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(temp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// reload 与Watch中的检查相同：文件修改后重新打开ASN数据库
+func reload(r *Resolver) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return reopen(&r.asn, r.asn.path)
+}
+
+func TestResolverReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "asn.mmdb")
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	writeASNDatabase(t, path, 64500, "Old Network", modTime)
+
+	r := NewResolver()
+	if err := r.Load(&models.GeoIPOptions{ASNDatabase: path}); err != nil {
+		t.Fatal(err)
+	}
+	if info := r.Lookup("192.0.2.1"); info.ASN != 64500 || info.Org != "Old Network" {
+		t.Fatalf("查询结果不正确: %+v", info)
+	}
+
+	// 文件未修改时保留已打开的数据库
+	opened := r.asn
+	if err := reload(r); err != nil || r.asn != opened {
+		t.Fatalf("文件未修改时重新打开了数据库: %v", err)
+	}
+
+	// 文件被替换后按新的修改时间重新打开
+	modTime = modTime.Add(time.Minute)
+	writeASNDatabase(t, path, 64501, "New Network", modTime)
+	if err := reload(r); err != nil {
+		t.Fatal(err)
+	}
+	if !r.asn.modTime.Equal(modTime) {
+		t.Fatalf("修改时间为%v，应为%v", r.asn.modTime, modTime)
+	}
+	if info := r.Lookup("192.0.2.1"); info.ASN != 64501 || info.Org != "New Network" {
+		t.Fatalf("替换后的查询结果不正确: %+v", info)
+	}
+
+	// 替换为无效文件或文件被删除时返回错误，继续使用原数据库
+	writeFile(t, path, []byte("not a database"), modTime.Add(time.Minute))
+	if err := reload(r); err == nil {
+		t.Fatal("无效文件应返回错误")
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := reload(r); err == nil {
+		t.Fatal("文件不存在时应返回错误")
+	}
+	if info := r.Lookup("192.0.2.1"); info.ASN != 64501 || !r.asn.modTime.Equal(modTime) {
+		t.Fatalf("出错后没有保留原数据库: %+v", info)
+	}
+
+	// 配置中去掉数据库后不再查询
+	if err := r.Load(nil); err != nil {
+		t.Fatal(err)
+	}
+	if info := r.Lookup("192.0.2.1"); info != (models.GeoInfo{}) {
+		t.Fatalf("关闭数据库后仍有查询结果: %+v", info)
+	}
+}
@@ -2,41 +2,318 @@ package models
 
 import "time"
 
+// 探测类型
+const (
+	ProbeICMP          = "icmp"           // ICMP ping（默认）
+	ProbeHTTP          = "http"           // HTTP下载测速，addr为URL
+	ProbeTLS           = "tls"            // TLS握手与证书检查，addr为host[:port]
+	ProbeNTP           = "ntp"            // NTP查询，addr为host[:port]
+	ProbeTCP           = "tcp"            // TCP建连，addr为host:port
+	ProbeExec          = "exec"           // 运行Nagios兼容的检查插件
+	ProbeHeartbeat     = "heartbeat"      // 被动心跳，由客户端访问 /api/heartbeat/<token> 上报
+	ProbeHappyEyeballs = "happy_eyeballs" // 双栈连接竞速（RFC 8305），addr为host[:port]或URL
+)
+
 // IPTarget 配置文件中的目标定义
 type IPTarget struct {
-	Addr        string `json:"addr"`                    // 支持IPv4、IPv6、域名
-	Description string `json:"description"`             // 描述信息
-	HideAddr    bool   `json:"hide_addr,omitempty"`     // 是否隐藏地址显示
-	DNSServer   string `json:"dns_server,omitempty"`    // 自定义DNS服务器（仅域名时有效）
+	ID          string             `json:"id,omitempty"`           // 稳定的目标ID，配置后修改描述等字段不影响历史数据
+	PreviousIDs []string           `json:"previous_ids,omitempty"` // 之前使用过的目标ID，其历史数据会合并到本目标
+	Addr        string             `json:"addr"`                   // 支持IPv4、IPv6、域名
+	Description string             `json:"description"`            // 描述信息
+	HideAddr    bool               `json:"hide_addr,omitempty"`    // 是否隐藏地址显示
+	DNSServer   string             `json:"dns_server,omitempty"`   // 自定义DNS服务器（仅域名时有效）
+	Type        string             `json:"type,omitempty"`         // 探测类型，默认icmp
+	AnycastID   bool               `json:"anycast_id,omitempty"`   // 每次探测时识别任播节点（仅DNS服务器目标）
+	HTTP        *HTTPOptions       `json:"http,omitempty"`         // HTTP探测配置（type为http时有效）
+	TLS         *TLSOptions        `json:"tls,omitempty"`          // TLS探测配置（type为tls时有效）
+	Exec        *ExecOptions       `json:"exec,omitempty"`         // 外部检查配置（type为exec时有效）
+	Heartbeat   *HeartbeatOptions  `json:"heartbeat,omitempty"`    // 心跳配置（type为heartbeat时有效）
+	Throughput  *ThroughputOptions `json:"throughput,omitempty"`   // 带宽测试（可选，需对端运行响应端）
+	Proxy       *ProxyOptions      `json:"proxy,omitempty"`        // 经由代理探测（仅tcp、http、tls类型）
+
+	RetentionDays int `json:"retention_days,omitempty"` // 原始结果保留天数，覆盖全局的retention.raw_days，-1表示永久保留
+}
+
+// ProbeType 返回目标的探测类型，未配置时为icmp
+func (t IPTarget) ProbeType() string {
+	if t.Type == "" {
+		return ProbeICMP
+	}
+	return t.Type
+}
+
+// ProxyOptions 探测使用的代理
+type ProxyOptions struct {
+	Type     string `json:"type,omitempty"`     // 代理类型：socks5（默认）、http（CONNECT）
+	Addr     string `json:"addr"`               // 代理地址，host:port
+	Username string `json:"username,omitempty"` // 认证用户名（可选）
+	Password string `json:"password,omitempty"` // 认证密码（可选）
+}
+
+// HTTPOptions HTTP下载探测配置
+type HTTPOptions struct {
+	MaxBytes int64 `json:"max_bytes,omitempty"` // 最多下载的字节数，默认1MB，上限100MB
+	Timeout  int   `json:"timeout,omitempty"`   // 超时时间，单位：秒，默认30秒
+}
+
+// TLSOptions TLS证书探测配置
+type TLSOptions struct {
+	ServerName   string `json:"server_name,omitempty"`   // SNI及证书校验使用的域名，默认取addr中的主机名
+	WarnDays     int    `json:"warn_days,omitempty"`     // 证书剩余天数低于该值时产生警告事件，默认30天
+	CriticalDays int    `json:"critical_days,omitempty"` // 证书剩余天数低于该值时产生严重事件，默认7天
+}
+
+// ExecOptions Nagios兼容插件的运行配置
+// Args和Env的值支持模板，可引用目标字段，如 {{.Addr}}、{{.Description}}
+type ExecOptions struct {
+	Command string            `json:"command"`           // 插件路径，必须位于exec_plugin_dirs中的目录下
+	Args    []string          `json:"args,omitempty"`    // 命令参数
+	Env     map[string]string `json:"env,omitempty"`     // 额外环境变量
+	Timeout int               `json:"timeout,omitempty"` // 超时时间，单位：秒，默认10秒
+}
+
+// HeartbeatOptions 被动心跳配置
+type HeartbeatOptions struct {
+	Token string `json:"token,omitempty"` // 心跳URL中的令牌，为空时自动生成并保存在数据库中
+	Grace int    `json:"grace,omitempty"` // 宽限期，单位：秒，超过该时间未收到心跳视为失败，默认600秒
+}
+
+// ThroughputOptions 带宽测试配置
+type ThroughputOptions struct {
+	Enabled  bool   `json:"enabled"`            // 是否启用
+	Port     int    `json:"port,omitempty"`     // 对端响应端口，默认5201
+	Duration int    `json:"duration,omitempty"` // 每个方向的测试时长，单位：秒，默认5秒
+	Streams  int    `json:"streams,omitempty"`  // 并行TCP流数量，默认1
+	Interval int    `json:"interval,omitempty"` // 测试间隔，单位：秒，不小于600秒
+	Secret   string `json:"secret,omitempty"`   // 对端响应端的共享密钥（可选），与对端的throughput_secret相同
 }
 
 // Config 应用配置
 type Config struct {
-	Title        string     `json:"title,omitempty"`       // 页面标题
-	Description  string     `json:"description,omitempty"` // 页面介绍
-	Targets      []IPTarget `json:"targets"`
-	PingInterval int        `json:"ping_interval"` // ping间隔，单位：秒
-	PingCount    int        `json:"ping_count"`    // 每次ping的次数，默认4次
-	WebPort      int        `json:"web_port"`      // Web服务端口
-	DefaultDNS   string     `json:"default_dns,omitempty"` // 默认DNS服务器
+	Title            string        `json:"title,omitempty"`       // 页面标题
+	Description      string        `json:"description,omitempty"` // 页面介绍
+	Targets          []IPTarget    `json:"targets"`
+	PingInterval     int           `json:"ping_interval"`               // ping间隔，单位：秒
+	PingCount        int           `json:"ping_count"`                  // 每次ping的次数，默认4次
+	WebPort          int           `json:"web_port"`                    // Web服务端口
+	TrustedProxies   []string      `json:"trusted_proxies,omitempty"`   // 可信反向代理的地址或网段，只采用它们转发的X-Forwarded-For，修改后需重启生效
+	DefaultDNS       string        `json:"default_dns,omitempty"`       // 默认DNS服务器
+	ThroughputListen string        `json:"throughput_listen,omitempty"` // 带宽测试响应端监听地址，如 ":5201"
+	ThroughputSecret string        `json:"throughput_secret,omitempty"` // 带宽测试响应端的共享密钥，设置后只接受携带相同密钥的测试，修改后需重启生效
+	ThroughputAllow  []string      `json:"throughput_allow,omitempty"`  // 允许发起带宽测试的地址或网段，为空时不限制来源，修改后需重启生效
+	GeoIP            *GeoIPOptions `json:"geoip,omitempty"`             // 本地GeoIP数据库（可选）
+	ExecPluginDirs   []string      `json:"exec_plugin_dirs,omitempty"`  // 允许exec探测运行的插件目录，为空时禁用exec探测
+	ShutdownTimeout  int           `json:"shutdown_timeout,omitempty"`  // 退出时等待进行中的请求和探测完成的最长时间，单位：秒，默认30秒
+	AdminToken       string        `json:"admin_token,omitempty"`       // 管理接口（重新启用、删除目标等）的访问令牌，为空时禁用管理接口
+	ShowRetired      bool          `json:"show_retired,omitempty"`      // 仪表盘是否显示已移除目标的归档
+	Retention        *Retention    `json:"retention,omitempty"`         // 数据保留策略（可选），未配置时永久保留
+	Writer           *Writer       `json:"writer,omitempty"`            // 探测结果批量写入设置（可选），修改后需重启生效
+	Storage          *Storage      `json:"storage,omitempty"`           // 存储设置（可选），修改后需重启生效
+	Backup           *Backup       `json:"backup,omitempty"`            // 定期备份（可选），只适用于SQLite存储
+}
+
+// Retention 原始结果（Ping结果和附加指标）的保留策略
+type Retention struct {
+	RawDays       int `json:"raw_days,omitempty"`       // 原始结果保留天数，0表示永久保留；已移除的目标同样适用
+	PurgeInterval int `json:"purge_interval,omitempty"` // 清理间隔，单位：秒，默认3600秒
+	BatchSize     int `json:"batch_size,omitempty"`     // 每批删除的行数，默认1000
+
+	RollupDays map[string]int `json:"rollup_days,omitempty"` // 各层级汇总（1m、1h、1d）的保留天数，未配置或0表示永久保留
+}
+
+// Writer 探测结果的批量写入设置，结果先进入队列，由后台按批在一个事务中写入
+type Writer struct {
+	QueueSize     int `json:"queue_size,omitempty"`     // 队列长度，默认10000，队列已满时探测等待写入
+	BatchSize     int `json:"batch_size,omitempty"`     // 每个事务最多写入的条数，默认500
+	FlushInterval int `json:"flush_interval,omitempty"` // 写入间隔，单位：毫秒，默认500毫秒
+}
+
+// Backup 定期在线备份数据库，备份文件名为scallop-<时间>.db
+type Backup struct {
+	Dir      string `json:"dir"`                // 备份目录
+	Interval int    `json:"interval,omitempty"` // 备份间隔，单位：小时，默认24小时
+	Keep     int    `json:"keep,omitempty"`     // 保留的备份数量，默认7，更早的备份被删除
+}
+
+// Storage 存储设置，命令行指定的-storage优先于backend
+type Storage struct {
+	Backend string   `json:"backend,omitempty"` // 存储类型：sqlite（默认）、postgres、memory、segment
+	Segment *Segment `json:"segment,omitempty"` // 分段存储的设置
+}
+
+// Segment 分段存储的设置，每个目标的结果按时间切分为压缩块保存在数据目录的segments目录中
+type Segment struct {
+	ChunkPoints     int `json:"chunk_points,omitempty"`     // 每个块最多的数据点数，默认7200
+	ChunkDuration   int `json:"chunk_duration,omitempty"`   // 每个块最长覆盖的时间，单位：秒，默认7200秒
+	FlushInterval   int `json:"flush_interval,omitempty"`   // 未写满的块保存到磁盘的间隔，单位：秒，默认60秒，进程异常退出时最多丢失这段时间的结果
+	CompactInterval int `json:"compact_interval,omitempty"` // 合并小块的间隔，单位：秒，默认3600秒
+}
+
+// GeoIPOptions 本地MaxMind格式（.mmdb）数据库路径，文件更新后自动重新加载
+type GeoIPOptions struct {
+	ASNDatabase  string `json:"asn_db,omitempty"`  // ASN数据库，如 GeoLite2-ASN.mmdb
+	CityDatabase string `json:"city_db,omitempty"` // 城市数据库，如 GeoLite2-City.mmdb
 }
 
 // Target 数据库中的目标
 type Target struct {
-	ID          string    `json:"id"`          // 目标唯一ID
-	Addr        string    `json:"addr"`        // 地址
-	Description string    `json:"description"` // 描述
-	HideAddr    bool      `json:"hide_addr"`   // 是否隐藏地址
-	DNSServer   string    `json:"dns_server"`  // DNS服务器
-	CreatedAt   time.Time `json:"created_at"`  // 创建时间
-	UpdatedAt   time.Time `json:"updated_at"`  // 更新时间
+	ID             string    `json:"id"`                    // 目标唯一ID
+	Addr           string    `json:"addr"`                  // 地址
+	Description    string    `json:"description"`           // 描述
+	HideAddr       bool      `json:"hide_addr"`             // 是否隐藏地址
+	DNSServer      string    `json:"dns_server"`            // DNS服务器
+	CreatedAt      time.Time `json:"created_at"`            // 创建时间
+	UpdatedAt      time.Time `json:"updated_at"`            // 更新时间
+	Spec           IPTarget  `json:"-"`                     // 对应的配置项，目标移除后用于重新启用
+	HeartbeatToken string    `json:"-"`                     // 心跳令牌（仅心跳目标）
+	ResolvedIP     string    `json:"resolved_ip,omitempty"` // 最近一次探测到的IP地址
+	Geo            *GeoInfo  `json:"geo,omitempty"`         // 最近一次探测到的IP地址的ASN和地理位置
+}
+
+// RetiredTarget 已从配置中移除、但仍保留历史数据的目标
+type RetiredTarget struct {
+	Target
+	ResultCount int       `json:"result_count"`           // 保留的Ping结果数量
+	FirstResult time.Time `json:"first_result,omitempty"` // 第一条结果的时间
+	LastResult  time.Time `json:"last_result,omitempty"`  // 最后一条结果的时间，通常即移除时间
+}
+
+// Rollup 一个时间段内Ping结果的汇总，延迟统计只包含成功的结果
+type Rollup struct {
+	TargetID   string    `json:"target_id"`
+	Timestamp  time.Time `json:"timestamp"`  // 时间段开始时间
+	Resolution int       `json:"resolution"` // 时间段长度，单位：秒
+	Count      int64     `json:"count"`      // 结果数量
+	Success    int64     `json:"success"`    // 成功的结果数量
+	Loss       float64   `json:"loss"`       // 丢包率，百分比
+	Min        float64   `json:"min"`
+	Avg        float64   `json:"avg"`
+	Max        float64   `json:"max"`
+	P50        float64   `json:"p50"`
+	P95        float64   `json:"p95"`
+	P99        float64   `json:"p99"`
 }
 
 // PingResult Ping结果
 type PingResult struct {
 	ID        int       `json:"id"`
-	TargetID  string    `json:"target_id"`  // 关联目标ID
-	Latency   float64   `json:"latency"`    // 毫秒
+	TargetID  string    `json:"target_id"` // 关联目标ID
+	Latency   float64   `json:"latency"`   // 毫秒
 	Success   bool      `json:"success"`
 	Timestamp time.Time `json:"timestamp"`
 }
+
+// GeoInfo IP地址的网络归属与地理位置
+type GeoInfo struct {
+	ASN         uint   `json:"asn,omitempty"`          // 自治系统号
+	Org         string `json:"org,omitempty"`          // 自治系统所属组织
+	Country     string `json:"country,omitempty"`      // 国家ISO代码
+	CountryName string `json:"country_name,omitempty"` // 国家名称
+	City        string `json:"city,omitempty"`         // 城市名称
+}
+
+// ResolvedAddress 目标实际探测到的IP地址及其归属，仅在变化时记录
+type ResolvedAddress struct {
+	TargetID  string    `json:"target_id"` // 关联目标ID
+	IP        string    `json:"ip"`        // 解析得到的IP地址
+	Geo       GeoInfo   `json:"geo"`       // 归属信息
+	Timestamp time.Time `json:"timestamp"` // 首次观察到的时间
+}
+
+// 事件级别
+const (
+	EventInfo     = "info"
+	EventWarning  = "warning"
+	EventCritical = "critical"
+)
+
+// Event 目标事件（如证书即将过期），仅在状态变化时记录
+type Event struct {
+	ID        int       `json:"id"`
+	TargetID  string    `json:"target_id"` // 关联目标ID
+	Kind      string    `json:"kind"`      // 事件类别，如 tls_expiry
+	Level     string    `json:"level"`     // 事件级别：info、warning、critical
+	Message   string    `json:"message"`   // 事件描述
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Metric 探测附加指标（如带宽），与Ping结果分开存储
+type Metric struct {
+	TargetID  string    `json:"target_id"` // 关联目标ID
+	Name      string    `json:"name"`      // 指标名称
+	Value     float64   `json:"value"`     // 指标数值
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// RUMSample 访客浏览器测得的到Scallop服务器的延迟，按客户端网段聚合
+type RUMSample struct {
+	ID        int       `json:"id"`
+	Prefix    string    `json:"prefix"`  // 客户端网段，IPv4取/24，IPv6取/48
+	Geo       GeoInfo   `json:"geo"`     // 客户端ASN与地理位置
+	Latency   float64   `json:"latency"` // 多次往返的中位数，毫秒
+	Samples   int       `json:"samples"` // 本次上报包含的往返次数
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// PurgeResult 一次过期数据清理的结果
+type PurgeResult struct {
+	StartedAt      time.Time `json:"started_at"`
+	Duration       float64   `json:"duration_ms"`     // 清理耗时，单位：毫秒
+	ResultsDeleted int64     `json:"results_deleted"` // 删除的Ping结果数量
+	MetricsDeleted int64     `json:"metrics_deleted"` // 删除的附加指标数量
+	RollupsDeleted int64     `json:"rollups_deleted"` // 删除的汇总数量
+	FreedBytes     int64     `json:"freed_bytes"`     // 增量VACUUM归还给文件系统的空间
+	Error          string    `json:"error,omitempty"`
+}
+
+// DatabaseStats 数据库文件的空间占用
+type DatabaseStats struct {
+	Backend      string        `json:"backend"`                 // 存储类型：sqlite、postgres、memory、segment
+	SizeBytes    int64         `json:"size_bytes"`              // 数据库文件大小
+	FreeBytes    int64         `json:"free_bytes"`              // 文件中未使用的空间，增量VACUUM后归还给文件系统
+	AutoVacuum   string        `json:"auto_vacuum,omitempty"`   // SQLite的auto_vacuum模式：none、full或incremental
+	OldestResult time.Time     `json:"oldest_result,omitempty"` // 最早的Ping结果时间
+	LastPurge    *PurgeResult  `json:"last_purge,omitempty"`    // 最近一次过期数据清理
+	Writer       *WriterStats  `json:"writer,omitempty"`        // 批量写入的队列和耗时
+	Segments     *SegmentStats `json:"segments,omitempty"`      // 分段存储的块数量和压缩效果
+}
+
+// SegmentStats 分段存储的运行状态
+type SegmentStats struct {
+	Series         int        `json:"series"`                    // 时间序列数，每个目标的Ping结果和每个附加指标各为一个
+	Chunks         int        `json:"chunks"`                    // 磁盘上的块数量，不含未写满的块
+	Points         int64      `json:"points"`                    // 数据点总数
+	Bytes          int64      `json:"bytes"`                     // 块的总大小
+	BytesPerPoint  float64    `json:"bytes_per_point"`           // 每个数据点平均占用的字节数
+	LastCompaction *time.Time `json:"last_compaction,omitempty"` // 最近一次合并小块的时间
+	Compacted      int        `json:"compacted"`                 // 最近一次合并的块数量
+}
+
+// WriterStats 批量写入的运行状态
+type WriterStats struct {
+	QueueDepth    int     `json:"queue_depth"`     // 队列中等待写入的条数，一个Ping结果或一次探测的附加指标计一条
+	QueueSize     int     `json:"queue_size"`      // 队列长度
+	Written       int64   `json:"written"`         // 已写入的条数
+	Failed        int64   `json:"failed"`          // 写入失败被丢弃的条数
+	Blocked       int64   `json:"blocked"`         // 因队列已满而等待的次数
+	Batches       int64   `json:"batches"`         // 已提交的事务数
+	LastBatch     int     `json:"last_batch"`      // 最近一个事务写入的条数
+	LastFlushMs   float64 `json:"last_flush_ms"`   // 最近一个事务的耗时，单位：毫秒
+	AvgFlushMs    float64 `json:"avg_flush_ms"`    // 事务的平均耗时
+	MaxFlushMs    float64 `json:"max_flush_ms"`    // 事务的最长耗时
+	LastLatencyMs float64 `json:"last_latency_ms"` // 最近一批中最早入队的一条从入队到提交的时间
+}
+
+// ReloadResult 一次配置重载的结果
+type ReloadResult struct {
+	Timestamp time.Time `json:"timestamp"`
+	Trigger   string    `json:"trigger"`         // 触发方式：file（文件变化）、sighup
+	Success   bool      `json:"success"`         // 是否已应用新配置
+	Changed   bool      `json:"changed"`         // 配置内容是否有变化
+	Added     int       `json:"added"`           // 新增的目标数量
+	Removed   int       `json:"removed"`         // 移除的目标数量
+	Error     string    `json:"error,omitempty"` // 失败原因，失败时保留原配置；成功时为未能在运行中生效的变化
+
+	Changes         []string `json:"changes,omitempty"`          // 变化的配置项
+	RestartRequired []string `json:"restart_required,omitempty"` // 需要重启才能生效的配置项
+}
@@ -0,0 +1,111 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"scallop/internal/database"
+	"scallop/internal/models"
+)
+
+// 定期备份的文件名为scallop-<时间>.db，按文件名排序即按时间排序
+const (
+	backupPrefix = "scallop-"
+	backupSuffix = ".db"
+	backupLayout = "20060102-150405"
+)
+
+// startBackupLoop 按配置定期在线备份数据库，并删除超出保留数量的旧备份
+// 每分钟检查一次，以备份目录中最新备份的时间判断是否到期，重启后不会立即重复备份
+func (m *Monitor) startBackupLoop(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		backup := m.configManager.Get().Backup
+		if backup == nil {
+			continue
+		}
+		err := m.backup(ctx, *backup)
+		if errors.Is(err, database.ErrBackupUnsupported) {
+			fmt.Println("当前存储不支持在线备份，已停止定期备份")
+			return
+		}
+		if err != nil && ctx.Err() == nil {
+			fmt.Printf("定期备份失败: %v\n", err)
+		}
+	}
+}
+
+// backup 到期时备份一次数据库
+func (m *Monitor) backup(ctx context.Context, options models.Backup) error {
+	backuper, ok := m.db.(database.Backuper)
+	if !ok {
+		return database.ErrBackupUnsupported
+	}
+
+	existing, err := listBackups(options.Dir)
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		name := strings.TrimSuffix(strings.TrimPrefix(existing[len(existing)-1], backupPrefix), backupSuffix)
+		last, err := time.ParseInLocation(backupLayout, name, time.Local)
+		if err == nil && time.Since(last) < time.Duration(options.Interval)*time.Hour {
+			return nil
+		}
+	}
+
+	if err := os.MkdirAll(options.Dir, 0755); err != nil {
+		return err
+	}
+	name := backupPrefix + time.Now().Format(backupLayout) + backupSuffix
+	started := time.Now()
+	if err := backuper.Backup(ctx, filepath.Join(options.Dir, name)); err != nil {
+		return err
+	}
+	fmt.Printf("已备份数据库到 %s，耗时 %s\n", filepath.Join(options.Dir, name), time.Since(started).Round(time.Millisecond))
+
+	existing = append(existing, name)
+	for len(existing) > options.Keep {
+		if err := os.Remove(filepath.Join(options.Dir, existing[0])); err != nil {
+			return err
+		}
+		fmt.Printf("已删除旧备份 %s\n", existing[0])
+		existing = existing[1:]
+	}
+	return nil
+}
+
+// listBackups 备份目录中的定期备份，最早的在前，目录不存在时返回空
+func listBackups(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, backupSuffix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,100 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"scallop/internal/database"
+	"scallop/internal/models"
+)
+
+func TestBackupRotation(t *testing.T) {
+	dbDir, dir := t.TempDir(), t.TempDir()
+	db, err := database.New(filepath.Join(dbDir, "scallop.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	m := &Monitor{db: db}
+
+	// 已有5个较早的备份，以及不属于定期备份的文件
+	old := []string{
+		"scallop-20200101-000000.db",
+		"scallop-20200102-000000.db",
+		"scallop-20200103-000000.db",
+		"scallop-20200104-000000.db",
+		"scallop-20200105-000000.db",
+	}
+	others := []string{"scallop.db", "manual-20200101.db", "scallop-20200101-000000.db.tmp", "notes.txt"}
+	for _, name := range append(append([]string{}, old...), others...) {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	options := models.Backup{Dir: dir, Interval: 24, Keep: 3}
+	if err := m.backup(context.Background(), options); err != nil {
+		t.Fatal(err)
+	}
+	backups, err := listBackups(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 6个备份保留3个：删除最早的3个，其余文件不受影响
+	if len(backups) != 3 || !reflect.DeepEqual(backups[:2], old[3:]) {
+		t.Fatalf("备份为%v", backups)
+	}
+	if _, err := database.InspectBackup(filepath.Join(dir, backups[2])); err != nil {
+		t.Fatalf("新备份无效: %v", err)
+	}
+	for _, name := range others {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("删除了其他文件 %s", name)
+		}
+	}
+
+	// 未到备份间隔时不再备份
+	if err := m.backup(context.Background(), options); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := listBackups(dir); !reflect.DeepEqual(again, backups) {
+		t.Fatalf("间隔内重复备份: %v", again)
+	}
+}
+
+func TestBackupFirstRun(t *testing.T) {
+	db, err := database.New(filepath.Join(t.TempDir(), "scallop.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	m := &Monitor{db: db}
+
+	// 备份目录不存在时创建
+	dir := filepath.Join(t.TempDir(), "backups", "daily")
+	if err := m.backup(context.Background(), models.Backup{Dir: dir, Interval: 24, Keep: 1}); err != nil {
+		t.Fatal(err)
+	}
+	backups, err := listBackups(dir)
+	if err != nil || len(backups) != 1 {
+		t.Fatalf("备份为%v: %v", backups, err)
+	}
+	if _, err := time.ParseInLocation(backupLayout, backups[0][len(backupPrefix):len(backups[0])-len(backupSuffix)], time.Local); err != nil {
+		t.Fatalf("备份文件名 %s 不正确", backups[0])
+	}
+
+	// 内存存储不支持在线备份
+	memory, err := database.NewMemory(100, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m = &Monitor{db: memory}
+	if err := m.backup(context.Background(), models.Backup{Dir: dir, Interval: 24, Keep: 1}); !errors.Is(err, database.ErrBackupUnsupported) {
+		t.Fatalf("内存存储备份返回%v", err)
+	}
+}
@@ -1,55 +1,168 @@
 package monitor
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"scallop/internal/config"
 	"scallop/internal/database"
+	"scallop/internal/geoip"
 	"scallop/internal/models"
 	"scallop/internal/ping"
+	"scallop/internal/registry"
+	"scallop/internal/throughput"
 )
 
 // Monitor Ping监控器
 type Monitor struct {
-	db            *database.DB
-	configManager *config.Manager
-	pingExecutor  *ping.Executor
+	db             database.Store
+	configManager  *config.Manager
+	targets        *registry.Registry
+	pingExecutor   atomic.Pointer[ping.Executor]     // 配置变化时整体替换，进行中的探测继续使用旧的执行器
+	intervalChange chan struct{}                     // ping间隔变化时通知ping循环重新调度
+	throughputRuns map[string]time.Time              // 各目标上次带宽测试时间
+	eventLevels    map[string]string                 // 各目标各类事件的当前级别，key为"目标ID|类别"
+	anycastNodes   map[string]string                 // 各目标当前的任播节点标识
+	addresses      map[string]models.ResolvedAddress // 各目标当前解析到的地址
+	stateMutex     sync.Mutex
+	geo            *geoip.Resolver
+	startedAt      time.Time // 监控启动时间，用于计算从未上报过的心跳目标
+
+	// 退出时不再调度新的探测，进行中的探测使用probeCtx，等待超时后才取消
+	probeCtx     context.Context
+	cancelProbes context.CancelFunc
+	tasks        sync.WaitGroup
 }
 
-// NewMonitor 创建监控器
-func NewMonitor(db *database.DB, configManager *config.Manager) *Monitor {
+// NewMonitor 创建监控器，geo由监控器按配置加载，并可与Web服务器共用
+// 配置重新加载时监控器更新targets中的目标
+func NewMonitor(db database.Store, configManager *config.Manager, targets *registry.Registry, geo *geoip.Resolver) *Monitor {
 	config := configManager.Get()
-	return &Monitor{
-		db:            db,
-		configManager: configManager,
-		pingExecutor:  ping.NewExecutor(config.PingCount),
+	probeCtx, cancelProbes := context.WithCancel(context.Background())
+	m := &Monitor{
+		db:             db,
+		configManager:  configManager,
+		targets:        targets,
+		intervalChange: make(chan struct{}, 1),
+		throughputRuns: make(map[string]time.Time),
+		eventLevels:    make(map[string]string),
+		anycastNodes:   make(map[string]string),
+		addresses:      make(map[string]models.ResolvedAddress),
+		geo:            geo,
+		probeCtx:       probeCtx,
+		cancelProbes:   cancelProbes,
 	}
+	m.pingExecutor.Store(ping.NewExecutor(config.PingCount, config.ExecPluginDirs))
+	return m
 }
 
-// Start 启动监控
-func (m *Monitor) Start() {
+// Start 启动监控，ctx取消后停止调度新的探测，进行中的探测由Wait等待完成
+func (m *Monitor) Start(ctx context.Context) {
+	m.startedAt = time.Now()
+
+	// 加载GeoIP数据库和各目标上次解析到的地址
+	if err := m.geo.Load(m.configManager.Get().GeoIP); err != nil {
+		fmt.Printf("加载GeoIP数据库失败: %v\n", err)
+	}
+	m.goTask(func() { m.geo.Watch(ctx) })
+	if addresses, err := m.db.GetLatestAddresses(); err == nil {
+		m.addresses = addresses
+		for id, addr := range addresses {
+			m.targets.SetAddress(id, addr.IP, addr.Geo)
+		}
+	}
+
 	// 先执行一次初始ping测试
 	fmt.Println("执行初始ping测试...")
-	m.runPingTests()
+	m.runPingTests(ctx)
 
-	// 启动配置文件监控
-	go m.watchConfig()
+	// 启动配置文件监控，目标变化由watchTargets处理
+	m.configManager.OnChange(m.applyConfig)
+	targetEvents := m.targets.Subscribe(ctx)
+	m.goTask(func() { m.watchTargets(targetEvents) })
+	m.goTask(func() { m.watchConfig(ctx) })
 
 	// 启动定期ping监控
-	go m.startPingLoop()
+	m.goTask(func() { m.startPingLoop(ctx) })
+
+	// 启动带宽测试调度
+	m.goTask(func() { m.startThroughputLoop(ctx) })
+
+	// 启动过期数据清理
+	m.goTask(func() { m.startRetentionLoop(ctx) })
+
+	// 启动定期备份
+	m.goTask(func() { m.startBackupLoop(ctx) })
+}
+
+// probeGrace 取消剩余探测后等待其收尾的最长时间，计入Wait的期限之内
+const probeGrace = 5 * time.Second
+
+// Wait 等待监控循环退出、进行中的探测完成并保存结果，最多等到ctx到期
+// ctx有期限时在到期前预留收尾时间（最多5秒、不超过剩余时间的五分之一）：先取消剩余的探测，
+// 再等待其收尾直到ctx到期；未能按时完成时返回取消探测的原因
+func (m *Monitor) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		m.tasks.Wait()
+		close(done)
+	}()
+
+	cancelCtx := ctx
+	if deadline, ok := ctx.Deadline(); ok {
+		grace := min(probeGrace, time.Until(deadline)/5)
+		var cancel context.CancelFunc
+		cancelCtx, cancel = context.WithDeadline(ctx, deadline.Add(-grace))
+		defer cancel()
+	}
+
+	select {
+	case <-done:
+		m.cancelProbes()
+		return nil
+	case <-cancelCtx.Done():
+	}
+
+	m.cancelProbes()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+	return cancelCtx.Err()
+}
+
+// goTask 在后台运行任务，并纳入Wait的等待范围
+func (m *Monitor) goTask(task func()) {
+	m.tasks.Add(1)
+	go func() {
+		defer m.tasks.Done()
+		task()
+	}()
 }
 
 // runPingTests 执行ping测试
-func (m *Monitor) runPingTests() {
-	targets := m.db.GetTargets()
-	for _, target := range targets {
-		latency, success := m.pingExecutor.Ping(target)
+func (m *Monitor) runPingTests(ctx context.Context) {
+	for _, target := range m.targets.Snapshot().List() {
+		if ctx.Err() != nil {
+			return
+		}
+		if target.Spec.ProbeType() == models.ProbeHeartbeat {
+			continue
+		}
+		result := m.pingExecutor.Load().Probe(m.probeCtx, target)
 		// Console打印显示真实地址
 		fmt.Printf("测试 %s (%s): ", target.Description, target.Addr)
-		if success {
-			fmt.Printf("%.2fms\n", latency)
+		if result.Success {
+			fmt.Printf("%.2fms\n", result.Latency)
 		} else {
 			fmt.Printf("失败\n")
 		}
@@ -57,7 +170,7 @@ func (m *Monitor) runPingTests() {
 }
 
 // startPingLoop 启动定期ping循环
-func (m *Monitor) startPingLoop() {
+func (m *Monitor) startPingLoop(ctx context.Context) {
 	config := m.configManager.Get()
 	interval := time.Duration(config.PingInterval) * time.Second
 
@@ -65,91 +178,413 @@ func (m *Monitor) startPingLoop() {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	for {
+	for ctx.Err() == nil {
 		// 获取当前目标列表的快照
-		targets := m.db.GetTargets()
-		currentTargets := make([]*models.Target, 0, len(targets))
-		for _, target := range targets {
-			currentTargets = append(currentTargets, target)
+		for _, target := range m.targets.Snapshot().List() {
+			target := target
+			m.goTask(func() { m.pingAndSave(target) })
 		}
 
-		for _, target := range currentTargets {
-			go m.pingAndSave(target)
+		for waiting := true; waiting; {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				waiting = false
+			case <-m.intervalChange:
+				// 按新间隔重新计时，本轮不提前探测
+				interval = time.Duration(m.configManager.Get().PingInterval) * time.Second
+				ticker.Reset(interval)
+				fmt.Printf("Ping间隔已调整为: %v\n", interval)
+			}
 		}
-		<-ticker.C
 	}
 }
 
-// pingAndSave 执行ping并保存结果
+// pingAndSave 执行探测并保存结果
 func (m *Monitor) pingAndSave(target *models.Target) {
-	latency, success := m.pingExecutor.Ping(target)
+	if target.Spec.ProbeType() == models.ProbeHeartbeat {
+		m.checkHeartbeat(target)
+		return
+	}
+
+	probe := m.pingExecutor.Load().Probe(m.probeCtx, target)
+	if m.probeCtx.Err() != nil {
+		// 退出时被中途取消的探测结果不可信，不做保存
+		return
+	}
 
 	result := models.PingResult{
 		TargetID:  target.ID,
-		Latency:   latency,
-		Success:   success,
+		Latency:   probe.Latency,
+		Success:   probe.Success,
 		Timestamp: time.Now(),
 	}
 
 	if err := m.db.SavePingResult(result); err != nil {
 		fmt.Printf("保存数据失败: %v\n", err)
 	}
+	if winner, ok := probe.Metrics["he_winner_ipv6"]; ok {
+		probe.Metrics["he_ipv6_share"] = m.ipv6WinShare(target.ID, winner, result.Timestamp)
+	}
+	if err := m.db.SaveMetrics(target.ID, result.Timestamp, probe.Metrics); err != nil {
+		fmt.Printf("保存指标失败: %v\n", err)
+	}
+	if probe.Details != nil {
+		if err := m.db.SaveTargetInfo(target.ID, target.Spec.ProbeType(), probe.Details); err != nil {
+			fmt.Printf("保存探测详情失败: %v\n", err)
+		}
+	}
+	m.recordEvents(probe.Events)
+	if probe.Addr != "" {
+		m.trackAddress(target, probe.Addr, result.Timestamp)
+	}
+
+	if target.Spec.AnycastID {
+		m.trackAnycastNode(target, result.Timestamp)
+	}
 
 	fmt.Printf("[%s] %s (%s): ", result.Timestamp.Format("15:04:05"), target.Description, target.Addr)
-	if success {
-		fmt.Printf("%.2fms\n", latency)
+	if probe.Success {
+		fmt.Printf("%.2fms\n", probe.Latency)
 	} else {
 		fmt.Printf("失败\n")
 	}
 }
 
-// watchConfig 监控配置文件变化
-func (m *Monitor) watchConfig() {
-	ticker := time.NewTicker(5 * time.Second) // 每5秒检查一次
-	defer ticker.Stop()
+// checkHeartbeat 检查被动心跳目标，超过宽限期未收到心跳时记录一次失败结果
+func (m *Monitor) checkHeartbeat(target *models.Target) {
+	last, err := m.db.GetLastSuccess(target.ID)
+	if err != nil {
+		fmt.Printf("查询心跳失败: %v\n", err)
+		return
+	}
+	// Scallop未运行期间客户端无法上报，宽限期从监控启动或目标创建时开始计算
+	since := last
+	if m.startedAt.After(since) {
+		since = m.startedAt
+	}
+	if target.CreatedAt.After(since) {
+		since = target.CreatedAt
+	}
 
-	for {
-		<-ticker.C
+	grace := 600 * time.Second
+	if target.Spec.Heartbeat != nil {
+		grace = time.Duration(target.Spec.Heartbeat.Grace) * time.Second
+	}
+	silence := time.Since(since)
+	now := time.Now()
+
+	event := models.Event{TargetID: target.ID, Kind: "heartbeat", Level: models.EventInfo, Message: "心跳恢复", Timestamp: now}
+	if silence > grace {
+		event.Level = models.EventCritical
+		event.Message = fmt.Sprintf("超过%v未收到心跳", silence.Round(time.Second))
+	}
+	m.recordEvents([]models.Event{event})
+
+	if silence <= grace {
+		return
+	}
+
+	result := models.PingResult{TargetID: target.ID, Success: false, Timestamp: now}
+	if err := m.db.SavePingResult(result); err != nil {
+		fmt.Printf("保存数据失败: %v\n", err)
+	}
+	if last.IsZero() {
+		fmt.Printf("[%s] %s: 心跳超时（从未收到心跳）\n", now.Format("15:04:05"), target.Description)
+	} else {
+		fmt.Printf("[%s] %s: 心跳超时（上次心跳 %s）\n", now.Format("15:04:05"), target.Description, last.Format("2006-01-02 15:04:05"))
+	}
+}
 
-		stat, err := os.Stat(m.configManager.GetConfigPath())
-		if err != nil {
+// recordEvents 记录级别发生变化的事件
+// 首次出现的info级别事件视为正常状态，不做记录
+func (m *Monitor) recordEvents(events []models.Event) {
+	m.stateMutex.Lock()
+	defer m.stateMutex.Unlock()
+
+	for _, event := range events {
+		key := event.TargetID + "|" + event.Kind
+		last, seen := m.eventLevels[key]
+		m.eventLevels[key] = event.Level
+		if last == event.Level || (!seen && event.Level == models.EventInfo) {
 			continue
 		}
 
-		if stat.ModTime().After(m.configManager.GetLastModTime()) {
-			fmt.Println("检测到配置文件变化，重新加载...")
+		if err := m.db.SaveEvent(event); err != nil {
+			fmt.Printf("保存事件失败: %v\n", err)
+			continue
+		}
+		fmt.Printf("[%s] 事件(%s) %s: %s\n", event.Timestamp.Format("15:04:05"), event.Level, event.Kind, event.Message)
+	}
+}
+
+// trackAddress 记录目标解析到的地址及其ASN和地理位置，仅在变化时保存
+func (m *Monitor) trackAddress(target *models.Target, ip string, timestamp time.Time) {
+	addr := models.ResolvedAddress{
+		TargetID:  target.ID,
+		IP:        ip,
+		Geo:       m.geo.Lookup(ip),
+		Timestamp: timestamp,
+	}
 
-			// 保存旧的目标列表
-			oldTargets := m.db.GetTargets()
-			oldTargetIDs := make(map[string]bool)
-			for id := range oldTargets {
-				oldTargetIDs[id] = true
+	m.stateMutex.Lock()
+	previous, seen := m.addresses[target.ID]
+	changed := !seen || previous.IP != addr.IP || previous.Geo != addr.Geo
+	if changed {
+		m.addresses[target.ID] = addr
+	}
+	m.stateMutex.Unlock()
+
+	// 同步到目标快照，供/api/targets展示和分组；目标重新加入时快照中没有地址，同样需要补上，未变化时不产生新快照
+	m.targets.SetAddress(target.ID, addr.IP, addr.Geo)
+
+	if !changed {
+		return
+	}
+	if err := m.db.SaveResolvedAddress(addr); err != nil {
+		fmt.Printf("保存解析地址失败: %v\n", err)
+	}
+}
+
+// trackAnycastNode 识别任播节点，节点变化时记录事件以便在趋势图上标注
+func (m *Monitor) trackAnycastNode(target *models.Target, timestamp time.Time) {
+	info, err := ping.IdentifyNode(m.probeCtx, target)
+	if err != nil {
+		fmt.Printf("识别任播节点失败 %s: %v\n", target.Addr, err)
+		return
+	}
+
+	m.stateMutex.Lock()
+	previous, seen := m.anycastNodes[target.ID]
+	if !seen {
+		// 重启后从数据库恢复上次的节点，避免漏记重启期间的切换
+		if data, _, err := m.db.GetTargetInfo(target.ID, "anycast"); err == nil {
+			var last ping.NodeInfo
+			if json.Unmarshal(data, &last) == nil && last.Node != "" {
+				previous, seen = last.Node, true
 			}
+		}
+	}
+	m.anycastNodes[target.ID] = info.Node
+	m.stateMutex.Unlock()
+
+	if err := m.db.SaveTargetInfo(target.ID, "anycast", info); err != nil {
+		fmt.Printf("保存任播节点失败: %v\n", err)
+	}
 
-			if err := m.configManager.Load(); err != nil {
-				fmt.Printf("重新加载配置失败: %v\n", err)
+	if seen && previous != info.Node {
+		event := models.Event{
+			TargetID:  target.ID,
+			Kind:      "anycast_node",
+			Level:     models.EventInfo,
+			Message:   fmt.Sprintf("任播节点变化: %s → %s", previous, info.Node),
+			Timestamp: timestamp,
+		}
+		if err := m.db.SaveEvent(event); err != nil {
+			fmt.Printf("保存事件失败: %v\n", err)
+		}
+		fmt.Printf("[%s] %s (%s) %s\n", timestamp.Format("15:04:05"), target.Description, target.Addr, event.Message)
+	}
+}
+
+// startThroughputLoop 启动带宽测试调度
+// 带宽测试会占用链路容量，因此逐个目标串行执行，且每个目标至少间隔 throughput.MinInterval 秒
+func (m *Monitor) startThroughputLoop(ctx context.Context) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		for _, target := range m.targets.Snapshot().List() {
+			if ctx.Err() != nil {
+				return
+			}
+			opts := target.Spec.Throughput
+			if opts == nil || !opts.Enabled {
 				continue
 			}
 
-			config := m.configManager.Get()
-			if err := m.db.UpdateTargetsFromConfig(config.Targets); err != nil {
-				fmt.Printf("更新目标失败: %v\n", err)
-				continue
+			interval := time.Duration(opts.Interval) * time.Second
+			m.stateMutex.Lock()
+			due := time.Since(m.throughputRuns[target.ID]) >= interval
+			if due {
+				m.throughputRuns[target.ID] = time.Now()
 			}
+			m.stateMutex.Unlock()
+			if due {
+				m.runThroughputTest(target, *opts)
+			}
+		}
 
-			// 更新ping执行器的ping次数
-			m.pingExecutor = ping.NewExecutor(config.PingCount)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
 
-			// 检测新增的目标并立即进行ping测试
-			newTargets := m.db.GetTargets()
-			for id, target := range newTargets {
-				if !oldTargetIDs[id] {
-					fmt.Printf("检测到新目标，立即进行ping测试: %s (%s)\n", target.Description, target.Addr)
-					go m.pingAndSave(target)
-				}
-			}
+// runThroughputTest 执行带宽测试并保存结果
+func (m *Monitor) runThroughputTest(target *models.Target, opts models.ThroughputOptions) {
+	timestamp := time.Now()
+	result, err := throughput.Run(m.probeCtx, target.Addr, opts)
+	if m.probeCtx.Err() != nil {
+		return
+	}
+	if err != nil {
+		fmt.Printf("[%s] 带宽测试 %s (%s) 失败: %v\n", timestamp.Format("15:04:05"), target.Description, target.Addr, err)
+		return
+	}
+
+	metrics := map[string]float64{
+		"throughput_upload_mbps":   result.UploadMbps,
+		"throughput_download_mbps": result.DownloadMbps,
+	}
+	if err := m.db.SaveMetrics(target.ID, timestamp, metrics); err != nil {
+		fmt.Printf("保存带宽测试数据失败: %v\n", err)
+	}
+
+	fmt.Printf("[%s] 带宽测试 %s (%s): 上行 %.2fMbps, 下行 %.2fMbps\n", timestamp.Format("15:04:05"),
+		target.Description, target.Addr, result.UploadMbps, result.DownloadMbps)
+}
+
+// ipv6WinShare 计算最近一小时（含本次）Happy Eyeballs竞速中IPv6胜出的比例，单位：%
+func (m *Monitor) ipv6WinShare(targetID string, winner float64, now time.Time) float64 {
+	history, err := m.db.GetMetrics(targetID, "he_winner_ipv6", now.Add(-time.Hour), now)
+	if err != nil {
+		return winner * 100
+	}
+
+	sum := winner
+	for _, metric := range history {
+		sum += metric.Value
+	}
+	return sum / float64(len(history)+1) * 100
+}
+
+// watchConfig 配置文件变化或收到SIGHUP时重新加载配置
+func (m *Monitor) watchConfig(ctx context.Context) {
+	changes := m.configManager.Watch(ctx)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-changes:
+			fmt.Println("检测到配置文件变化，重新加载...")
+			m.reloadConfig("file")
+		case <-hup:
+			fmt.Println("收到SIGHUP，重新加载配置...")
+			m.reloadConfig("sighup")
+		}
+	}
+}
+
+// reloadConfig 校验并应用新配置，校验失败时保留原配置；结果记入重新加载历史
+func (m *Monitor) reloadConfig(trigger string) {
+	result := models.ReloadResult{Timestamp: time.Now(), Trigger: trigger}
+	defer func() { m.configManager.RecordReload(result) }()
+
+	cfg, err := m.configManager.Parse()
+	if err != nil {
+		result.Error = err.Error()
+		fmt.Printf("配置校验失败，继续使用原配置: %v\n", err)
+		return
+	}
+	result.Changes = config.Changes(m.configManager.Get(), cfg)
+	if len(result.Changes) == 0 {
+		result.Success = true
+		fmt.Println("配置未变化")
+		return
+	}
+
+	targets, err := m.db.SyncTargets(cfg.Targets)
+	if err != nil {
+		result.Error = err.Error()
+		fmt.Printf("更新目标失败: %v\n", err)
+		return
+	}
+	result.Success = true
+	result.Changed = true
+
+	// 应用其余配置项，未能生效的变化记入结果
+	if err := m.configManager.Apply(cfg); err != nil {
+		result.Error = err.Error()
+		fmt.Printf("部分配置未能生效: %v\n", err)
+	}
+	result.RestartRequired = config.RestartRequired(result.Changes)
+	if len(result.RestartRequired) > 0 {
+		fmt.Printf("以下配置项需要重启后生效: %s\n", strings.Join(result.RestartRequired, ", "))
+	}
+
+	// 新增的目标由watchTargets立即探测
+	for _, event := range m.targets.Update(targets) {
+		switch event.Type {
+		case registry.EventAdded:
+			result.Added++
+		case registry.EventRemoved:
+			result.Removed++
+		}
+	}
+
+	fmt.Printf("配置重新加载完成：新增%d个目标，移除%d个目标\n", result.Added, result.Removed)
+}
+
+// watchTargets 处理目标变化：新增或配置变化的目标立即探测一次，移除的目标清除其状态
+func (m *Monitor) watchTargets(events <-chan registry.Event) {
+	for event := range events {
+		target := event.Target
+		switch event.Type {
+		case registry.EventAdded:
+			fmt.Printf("检测到新目标，立即进行ping测试: %s (%s)\n", target.Description, target.Addr)
+			m.goTask(func() { m.pingAndSave(target) })
+		case registry.EventChanged:
+			fmt.Printf("目标配置已变化，立即进行ping测试: %s (%s)\n", target.Description, target.Addr)
+			m.goTask(func() { m.pingAndSave(target) })
+		case registry.EventRemoved:
+			fmt.Printf("目标已移除: %s (%s)\n", target.Description, target.Addr)
+			m.forgetTarget(target.ID)
+		}
+	}
+}
+
+// forgetTarget 清除已移除目标的事件级别、任播节点、解析地址和上次带宽测试时间
+func (m *Monitor) forgetTarget(targetID string) {
+	m.stateMutex.Lock()
+	defer m.stateMutex.Unlock()
+
+	for key := range m.eventLevels {
+		if strings.HasPrefix(key, targetID+"|") {
+			delete(m.eventLevels, key)
+		}
+	}
+	delete(m.anycastNodes, targetID)
+	delete(m.addresses, targetID)
+	delete(m.throughputRuns, targetID)
+}
+
+// applyConfig 在运行中应用监控相关的配置变化
+func (m *Monitor) applyConfig(old, new models.Config) error {
+	// 替换ping执行器，进行中的探测不受影响
+	if old.PingCount != new.PingCount || !reflect.DeepEqual(old.ExecPluginDirs, new.ExecPluginDirs) {
+		m.pingExecutor.Store(ping.NewExecutor(new.PingCount, new.ExecPluginDirs))
+	}
+
+	if old.PingInterval != new.PingInterval {
+		select {
+		case m.intervalChange <- struct{}{}:
+		default:
+		}
+	}
 
-			fmt.Println("配置重新加载完成")
+	// 更新GeoIP数据库路径
+	if !reflect.DeepEqual(old.GeoIP, new.GeoIP) {
+		if err := m.geo.Load(new.GeoIP); err != nil {
+			return fmt.Errorf("加载GeoIP数据库失败: %v", err)
 		}
 	}
+	return nil
 }
@@ -0,0 +1,34 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+
+	"scallop/internal/models"
+	"scallop/internal/registry"
+)
+
+func TestWatchTargetsForgetsRemoved(t *testing.T) {
+	m := &Monitor{
+		throughputRuns: map[string]time.Time{"a": time.Now(), "b": time.Now()},
+		eventLevels:    map[string]string{"a|loss": "warning", "ab|loss": "warning", "b|loss": "critical"},
+		anycastNodes:   map[string]string{"a": "hkg", "b": "sjc"},
+		addresses:      map[string]models.ResolvedAddress{"a": {}, "b": {}},
+	}
+
+	events := make(chan registry.Event, 1)
+	events <- registry.Event{Type: registry.EventRemoved, Target: &models.Target{ID: "a"}}
+	close(events)
+	m.watchTargets(events)
+
+	// 只清除被移除目标的状态
+	if _, ok := m.throughputRuns["a"]; ok || len(m.throughputRuns) != 1 {
+		t.Fatalf("带宽测试时间未清除: %v", m.throughputRuns)
+	}
+	if len(m.eventLevels) != 2 || m.eventLevels["ab|loss"] == "" || m.eventLevels["b|loss"] == "" {
+		t.Fatalf("事件级别不正确: %v", m.eventLevels)
+	}
+	if len(m.anycastNodes) != 1 || len(m.addresses) != 1 || m.anycastNodes["b"] != "sjc" {
+		t.Fatalf("任播节点或解析地址不正确: %v %v", m.anycastNodes, m.addresses)
+	}
+}
@@ -0,0 +1,90 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"scallop/internal/database"
+)
+
+// startRetentionLoop 按保留策略定期清理过期数据
+// 每分钟检查一次是否到了清理时间，策略和间隔在每次清理时从当前配置读取，重新加载配置后无需重启
+func (m *Monitor) startRetentionLoop(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	var lastRun time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		retention := m.configManager.Get().Retention
+		if retention == nil {
+			continue
+		}
+		if time.Since(lastRun) < time.Duration(retention.PurgeInterval)*time.Second {
+			continue
+		}
+		lastRun = time.Now()
+		m.purgeExpired(ctx)
+	}
+}
+
+// purgeExpired 执行一次过期数据清理
+func (m *Monitor) purgeExpired(ctx context.Context) {
+	policy, ok := m.retentionPolicy()
+	if !ok {
+		return
+	}
+
+	result, err := m.db.PurgeExpired(ctx, policy)
+	if err != nil {
+		if ctx.Err() == nil {
+			fmt.Printf("清理过期数据失败: %v\n", err)
+		}
+		return
+	}
+	if result.ResultsDeleted > 0 || result.MetricsDeleted > 0 || result.RollupsDeleted > 0 {
+		fmt.Printf("已清理过期数据: %d 条Ping结果，%d 条附加指标，%d 条汇总，释放 %d 字节，耗时 %.0fms\n",
+			result.ResultsDeleted, result.MetricsDeleted, result.RollupsDeleted, result.FreedBytes, result.Duration)
+	}
+}
+
+// retentionPolicy 由当前配置生成保留策略，所有目标都永久保留时返回false
+func (m *Monitor) retentionPolicy() (database.RetentionPolicy, bool) {
+	retention := m.configManager.Get().Retention
+	if retention == nil {
+		return database.RetentionPolicy{}, false
+	}
+
+	days := func(n int) time.Duration {
+		return time.Duration(n) * 24 * time.Hour
+	}
+	policy := database.RetentionPolicy{
+		Default:   days(retention.RawDays),
+		Targets:   make(map[string]time.Duration),
+		Rollups:   make(map[int]time.Duration),
+		BatchSize: retention.BatchSize,
+	}
+	expires := policy.Default > 0
+	for name, n := range retention.RollupDays {
+		if n > 0 {
+			policy.Rollups[database.RollupTierNames[name]] = days(n)
+			expires = true
+		}
+	}
+	for _, target := range m.targets.Snapshot().List() {
+		switch {
+		case target.Spec.RetentionDays > 0:
+			policy.Targets[target.ID] = days(target.Spec.RetentionDays)
+			expires = true
+		case target.Spec.RetentionDays < 0:
+			policy.Targets[target.ID] = 0
+		}
+	}
+	return policy, expires
+}
@@ -0,0 +1,176 @@
+package ping
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"scallop/internal/models"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ednsOptionNSID EDNS NSID选项代码（RFC 5001）
+const ednsOptionNSID = 3
+
+// NodeInfo 任播DNS节点标识
+type NodeInfo struct {
+	Node         string `json:"node"`                    // 综合得到的节点标识
+	NSID         string `json:"nsid,omitempty"`          // EDNS NSID
+	IDServer     string `json:"id_server,omitempty"`     // CHAOS TXT id.server
+	HostnameBind string `json:"hostname_bind,omitempty"` // CHAOS TXT hostname.bind
+}
+
+// IdentifyNode 向DNS服务器发送NSID及CHAOS类查询，识别当前路由到的任播节点
+// 优先使用NSID，其次为id.server和hostname.bind
+func IdentifyNode(ctx context.Context, target *models.Target) (NodeInfo, error) {
+	addr := target.Addr
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), "53")
+	}
+
+	var info NodeInfo
+	var lastErr error
+
+	if nsid, err := queryNSID(ctx, addr); err == nil {
+		info.NSID = nsid
+	} else {
+		lastErr = err
+	}
+	if id, err := queryChaosTXT(ctx, addr, "id.server."); err == nil {
+		info.IDServer = id
+	} else {
+		lastErr = err
+	}
+	if hostname, err := queryChaosTXT(ctx, addr, "hostname.bind."); err == nil {
+		info.HostnameBind = hostname
+	} else {
+		lastErr = err
+	}
+
+	for _, candidate := range []string{info.NSID, info.IDServer, info.HostnameBind} {
+		if candidate != "" {
+			info.Node = candidate
+			return info, nil
+		}
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("服务器未返回节点标识")
+	}
+	return info, lastErr
+}
+
+// queryChaosTXT 查询CHAOS类TXT记录
+func queryChaosTXT(ctx context.Context, addr, name string) (string, error) {
+	question := dnsmessage.Question{
+		Name:  dnsmessage.MustNewName(name),
+		Type:  dnsmessage.TypeTXT,
+		Class: dnsmessage.ClassCHAOS,
+	}
+
+	msg, err := exchange(ctx, addr, question, nil)
+	if err != nil {
+		return "", err
+	}
+
+	for _, answer := range msg.Answers {
+		if txt, ok := answer.Body.(*dnsmessage.TXTResource); ok && len(txt.TXT) > 0 {
+			return strings.Join(txt.TXT, ""), nil
+		}
+	}
+	return "", fmt.Errorf("%s 无TXT记录", name)
+}
+
+// queryNSID 发送带NSID选项的普通查询，从响应的OPT记录中读取NSID
+func queryNSID(ctx context.Context, addr string) (string, error) {
+	question := dnsmessage.Question{
+		Name:  dnsmessage.MustNewName("."),
+		Type:  dnsmessage.TypeNS,
+		Class: dnsmessage.ClassINET,
+	}
+
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(1232, dnsmessage.RCodeSuccess, false); err != nil {
+		return "", err
+	}
+	additional := &dnsmessage.Resource{
+		Header: opt,
+		Body: &dnsmessage.OPTResource{
+			Options: []dnsmessage.Option{{Code: ednsOptionNSID}},
+		},
+	}
+
+	msg, err := exchange(ctx, addr, question, additional)
+	if err != nil {
+		return "", err
+	}
+
+	for _, resource := range msg.Additionals {
+		optBody, ok := resource.Body.(*dnsmessage.OPTResource)
+		if !ok {
+			continue
+		}
+		for _, option := range optBody.Options {
+			if option.Code == ednsOptionNSID && len(option.Data) > 0 {
+				return string(option.Data), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("服务器未返回NSID")
+}
+
+// exchange 通过UDP发送单个DNS查询并解析响应
+func exchange(ctx context.Context, addr string, question dnsmessage.Question, additional *dnsmessage.Resource) (*dnsmessage.Message, error) {
+	request := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: uint16(rand.Intn(1 << 16)), RecursionDesired: true},
+		Questions: []dnsmessage.Question{question},
+	}
+	if additional != nil {
+		request.Additionals = []dnsmessage.Resource{*additional}
+	}
+
+	packed, err := request.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	if _, err := conn.Write(packed); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	var response dnsmessage.Message
+	if err := response.Unpack(buf[:n]); err != nil {
+		return nil, err
+	}
+	if response.ID != request.ID {
+		return nil, fmt.Errorf("DNS响应ID不匹配")
+	}
+	if response.RCode != dnsmessage.RCodeSuccess {
+		return nil, fmt.Errorf("DNS响应码: %v", response.RCode)
+	}
+	return &response, nil
+}
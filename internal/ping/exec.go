@@ -0,0 +1,306 @@
+package ping
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"scallop/internal/models"
+)
+
+// Nagios插件退出码
+const (
+	nagiosOK       = 0
+	nagiosWarning  = 1
+	nagiosCritical = 2
+	nagiosUnknown  = 3
+)
+
+// maxPluginOutput 插件输出的最大读取长度
+const maxPluginOutput = 64 * 1024
+
+// nagiosStates 退出码对应的状态名称
+var nagiosStates = map[int]string{
+	nagiosOK:       "OK",
+	nagiosWarning:  "WARNING",
+	nagiosCritical: "CRITICAL",
+	nagiosUnknown:  "UNKNOWN",
+}
+
+// perfdataPattern 单个perfdata项：'label'=value[UOM];[warn];[crit];[min];[max]
+var perfdataPattern = regexp.MustCompile(`('[^']+'|[^\s=']+)=([-+]?[0-9.]+(?:[eE][-+]?[0-9]+)?)([a-zA-Z%/]*)((?:;[^;\s]*){0,4})`)
+
+// metricNamePattern 指标名称中不允许的字符
+var metricNamePattern = regexp.MustCompile(`[^a-zA-Z0-9_]+`)
+
+// PerfData 插件输出的单个性能数据
+type PerfData struct {
+	Label string  `json:"label"`
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit,omitempty"`
+	Warn  string  `json:"warn,omitempty"`
+	Crit  string  `json:"crit,omitempty"`
+}
+
+// ExecDetails 一次插件运行的详情
+type ExecDetails struct {
+	Status   string     `json:"status"`
+	ExitCode int        `json:"exit_code"`
+	Output   string     `json:"output"`
+	PerfData []PerfData `json:"perfdata,omitempty"`
+}
+
+// probeExec 运行Nagios兼容的检查插件，按退出码判断状态并解析perfdata
+// 插件直接执行而不经过shell，只能位于允许的目录中，且仅继承PATH等最小环境变量
+func (e *Executor) probeExec(parent context.Context, target *models.Target) Result {
+	opts := target.Spec.Exec
+	if opts == nil || opts.Command == "" {
+		fmt.Printf("exec探测未配置命令 %s\n", target.Description)
+		return Result{}
+	}
+
+	command, err := e.resolvePlugin(opts.Command)
+	if err != nil {
+		fmt.Printf("exec探测拒绝运行 %s: %v\n", opts.Command, err)
+		return Result{}
+	}
+
+	args := make([]string, 0, len(opts.Args))
+	for _, arg := range opts.Args {
+		expanded, err := expandTemplate(arg, target)
+		if err != nil {
+			fmt.Printf("exec参数模板错误 %s: %v\n", arg, err)
+			return Result{}
+		}
+		args = append(args, expanded)
+	}
+
+	env := []string{"PATH=" + os.Getenv("PATH"), "LANG=C"}
+	for key, value := range opts.Env {
+		expanded, err := expandTemplate(value, target)
+		if err != nil {
+			fmt.Printf("exec环境变量模板错误 %s: %v\n", key, err)
+			return Result{}
+		}
+		env = append(env, key+"="+expanded)
+	}
+
+	timeout := time.Duration(opts.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Env = env
+	cmd.Dir = filepath.Dir(command)
+	configureSandbox(cmd)
+
+	var output bytes.Buffer
+	cmd.Stdout = &limitedWriter{buf: &output, limit: maxPluginOutput}
+	cmd.Stderr = cmd.Stdout
+
+	start := time.Now()
+	err = cmd.Run()
+	elapsed := time.Since(start)
+
+	exitCode := nagiosOK
+	if err != nil {
+		var exitErr *exec.ExitError
+		switch {
+		case parent.Err() != nil:
+			return Result{}
+		case ctx.Err() == context.DeadlineExceeded:
+			exitCode = nagiosUnknown
+			output.WriteString("\n插件运行超时")
+		case errors.As(err, &exitErr):
+			exitCode = exitErr.ExitCode()
+		default:
+			fmt.Printf("exec探测运行失败 %s: %v\n", command, err)
+			return Result{}
+		}
+	}
+	if _, ok := nagiosStates[exitCode]; !ok {
+		exitCode = nagiosUnknown
+	}
+
+	text, perfdata := parsePluginOutput(output.String())
+	details := ExecDetails{
+		Status:   nagiosStates[exitCode],
+		ExitCode: exitCode,
+		Output:   text,
+		PerfData: perfdata,
+	}
+
+	metrics := map[string]float64{"exec_status": float64(exitCode)}
+	for _, item := range perfdata {
+		metrics["perf_"+metricName(item.Label)] = item.Value
+	}
+
+	return Result{
+		Latency: float64(elapsed.Microseconds()) / 1000,
+		Success: exitCode == nagiosOK || exitCode == nagiosWarning,
+		Metrics: metrics,
+		Details: details,
+		Events:  []models.Event{execEvent(target, details)},
+	}
+}
+
+// resolvePlugin 将插件路径解析为绝对路径，并确认其位于允许的目录中
+func (e *Executor) resolvePlugin(command string) (string, error) {
+	if len(e.pluginDirs) == 0 {
+		return "", fmt.Errorf("未配置exec_plugin_dirs")
+	}
+
+	path, err := filepath.Abs(command)
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	for _, dir := range e.pluginDirs {
+		absDir, err := filepath.Abs(dir)
+		if err != nil {
+			continue
+		}
+		if resolved, err := filepath.EvalSymlinks(absDir); err == nil {
+			absDir = resolved
+		}
+		rel, err := filepath.Rel(absDir, path)
+		if err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("插件不在允许的目录中")
+}
+
+// templateData 模板中可以引用的目标字段
+// 只暴露这几个字段，避免模板读取心跳令牌、代理密码等目标的其余配置
+type templateData struct {
+	Addr        string
+	Description string
+	ID          string
+	DNSServer   string
+}
+
+// expandTemplate 使用目标字段展开模板
+func expandTemplate(text string, target *models.Target) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+
+	tmpl, err := template.New("arg").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	data := templateData{Addr: target.Addr, Description: target.Description, ID: target.ID, DNSServer: target.DNSServer}
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// parsePluginOutput 拆分插件输出的文本和perfdata
+// 第一行"|"之前为状态文本，之后为perfdata；其余各行为详细输出，其中第一个"|"之后直到输出结束的所有内容都是perfdata
+func parsePluginOutput(output string) (string, []PerfData) {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	text := strings.TrimSpace(lines[0])
+
+	var perfText []string
+	if idx := strings.Index(text, "|"); idx >= 0 {
+		perfText = append(perfText, text[idx+1:])
+		text = strings.TrimSpace(text[:idx])
+	}
+	inPerfData := false
+	for _, line := range lines[1:] {
+		if inPerfData {
+			perfText = append(perfText, line)
+			continue
+		}
+		if idx := strings.Index(line, "|"); idx >= 0 {
+			perfText = append(perfText, line[idx+1:])
+			inPerfData = true
+		}
+	}
+
+	var perfdata []PerfData
+	for _, part := range perfText {
+		for _, match := range perfdataPattern.FindAllStringSubmatch(part, -1) {
+			value, err := strconv.ParseFloat(match[2], 64)
+			if err != nil {
+				continue
+			}
+
+			item := PerfData{
+				Label: strings.Trim(match[1], "'"),
+				Value: value,
+				Unit:  match[3],
+			}
+			thresholds := strings.Split(strings.TrimPrefix(match[4], ";"), ";")
+			if len(thresholds) > 0 {
+				item.Warn = thresholds[0]
+			}
+			if len(thresholds) > 1 {
+				item.Crit = thresholds[1]
+			}
+			perfdata = append(perfdata, item)
+		}
+	}
+
+	return text, perfdata
+}
+
+// metricName 将perfdata标签转换为指标名称
+func metricName(label string) string {
+	return strings.Trim(metricNamePattern.ReplaceAllString(strings.ToLower(label), "_"), "_")
+}
+
+// execEvent 根据插件状态生成事件
+func execEvent(target *models.Target, details ExecDetails) models.Event {
+	level := models.EventInfo
+	switch details.ExitCode {
+	case nagiosWarning, nagiosUnknown:
+		level = models.EventWarning
+	case nagiosCritical:
+		level = models.EventCritical
+	}
+
+	return models.Event{
+		TargetID:  target.ID,
+		Kind:      "exec_state",
+		Level:     level,
+		Message:   fmt.Sprintf("%s: %s", details.Status, details.Output),
+		Timestamp: time.Now(),
+	}
+}
+
+// limitedWriter 超出长度限制后丢弃多余输出
+type limitedWriter struct {
+	buf   *bytes.Buffer
+	limit int
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if remaining := w.limit - w.buf.Len(); remaining > 0 {
+		if len(p) > remaining {
+			w.buf.Write(p[:remaining])
+		} else {
+			w.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,233 @@
+package ping
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"scallop/internal/models"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	target := &models.Target{
+		ID:             "web",
+		Addr:           "example.com",
+		Description:    "Web",
+		DNSServer:      "1.1.1.1",
+		HeartbeatToken: "token",
+		Spec: models.IPTarget{
+			Addr:  "example.com",
+			Proxy: &models.ProxyOptions{Addr: "127.0.0.1:1080", Username: "user", Password: "secret"},
+		},
+	}
+
+	got, err := expandTemplate("{{.ID}} {{.Addr}} {{.Description}} {{.DNSServer}}", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "web example.com Web 1.1.1.1" {
+		t.Fatalf("展开结果为%q", got)
+	}
+	if got, err := expandTemplate("-H example.com", target); err != nil || got != "-H example.com" {
+		t.Fatalf("不含模板的参数: %q %v", got, err)
+	}
+
+	// 目标的其余字段不能引用
+	for _, text := range []string{"{{.HeartbeatToken}}", "{{.Spec.Proxy.Password}}", "{{.CreatedAt}}"} {
+		if got, err := expandTemplate(text, target); err == nil {
+			t.Errorf("%s 应返回错误，得到%q", text, got)
+		}
+	}
+}
+
+func TestParsePluginOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		text     string
+		perfdata []PerfData
+	}{
+		{
+			name:   "单位和阈值",
+			output: "PING OK - Packet loss = 0%, RTA = 0.80 ms|rta=0.800000ms;200.000000;500.000000;0.000000 pl=0%;40;80;0\n",
+			text:   "PING OK - Packet loss = 0%, RTA = 0.80 ms",
+			perfdata: []PerfData{
+				{Label: "rta", Value: 0.8, Unit: "ms", Warn: "200.000000", Crit: "500.000000"},
+				{Label: "pl", Value: 0, Unit: "%", Warn: "40", Crit: "80"},
+			},
+		},
+		{
+			name:   "带空格的引号标签",
+			output: "DISK OK | '/var/lib data'=42.5GB;80;90;0;100 'inode use'=12%",
+			text:   "DISK OK",
+			perfdata: []PerfData{
+				{Label: "/var/lib data", Value: 42.5, Unit: "GB", Warn: "80", Crit: "90"},
+				{Label: "inode use", Value: 12, Unit: "%"},
+			},
+		},
+		{
+			name:   "其他单位",
+			output: "OK | time=1.5e-3s;;;0 size=2048B requests=1234c rate=10/s",
+			text:   "OK",
+			perfdata: []PerfData{
+				{Label: "time", Value: 0.0015, Unit: "s"},
+				{Label: "size", Value: 2048, Unit: "B"},
+				{Label: "requests", Value: 1234, Unit: "c"},
+				{Label: "rate", Value: 10, Unit: "/s"},
+			},
+		},
+		{
+			name:   "阈值为空或缺失",
+			output: "OK | a=1;;5 b=2;3 c=-4 d=5;~:10;@20:30",
+			text:   "OK",
+			perfdata: []PerfData{
+				{Label: "a", Value: 1, Crit: "5"},
+				{Label: "b", Value: 2, Warn: "3"},
+				{Label: "c", Value: -4},
+				{Label: "d", Value: 5, Warn: "~:10", Crit: "@20:30"},
+			},
+		},
+		{
+			name:     "值为U时忽略该项",
+			output:   "UNKNOWN | load=U;1;2 users=3;5;10",
+			text:     "UNKNOWN",
+			perfdata: []PerfData{{Label: "users", Value: 3, Warn: "5", Crit: "10"}},
+		},
+		{
+			name: "多行输出中的perfdata",
+			output: "DISK OK - free space: / 3326 MB (56%); | /=2643MB;5948;5958;0;5968\n" +
+				"/ 15272 MB (77%);\n" +
+				"/boot 68 MB (69%); | /boot=68MB;88;93;0;98\n" +
+				"/home=69357MB;253404;253409;0;253414\n" +
+				"'/var log'=818MB;970;975;0;980\n",
+			text: "DISK OK - free space: / 3326 MB (56%);",
+			perfdata: []PerfData{
+				{Label: "/", Value: 2643, Unit: "MB", Warn: "5948", Crit: "5958"},
+				{Label: "/boot", Value: 68, Unit: "MB", Warn: "88", Crit: "93"},
+				{Label: "/home", Value: 69357, Unit: "MB", Warn: "253404", Crit: "253409"},
+				{Label: "/var log", Value: 818, Unit: "MB", Warn: "970", Crit: "975"},
+			},
+		},
+		{
+			name:   "没有perfdata",
+			output: "CHECK OK\nsome=detail without separator\n",
+			text:   "CHECK OK",
+		},
+	}
+	for _, test := range tests {
+		text, perfdata := parsePluginOutput(test.output)
+		if text != test.text {
+			t.Errorf("%s: 文本为%q，应为%q", test.name, text, test.text)
+		}
+		if !reflect.DeepEqual(perfdata, test.perfdata) {
+			t.Errorf("%s: perfdata为%+v，应为%+v", test.name, perfdata, test.perfdata)
+		}
+	}
+}
+
+// writePlugin 在dir中生成shell脚本插件
+func writePlugin(t *testing.T, dir, name, script string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestProbeExecExitCodes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("需要/bin/sh")
+	}
+	dir := t.TempDir()
+	executor := NewExecutor(1, []string{dir})
+
+	tests := []struct {
+		script  string
+		status  string
+		code    int
+		success bool
+	}{
+		{"echo 'OK | load=1'; exit 0", "OK", nagiosOK, true},
+		{"echo WARNING; exit 1", "WARNING", nagiosWarning, true},
+		{"echo CRITICAL; exit 2", "CRITICAL", nagiosCritical, false},
+		{"echo UNKNOWN; exit 3", "UNKNOWN", nagiosUnknown, false},
+		{"echo 'exit 4'; exit 4", "UNKNOWN", nagiosUnknown, false},
+		{"echo 'exit 255'; exit 255", "UNKNOWN", nagiosUnknown, false},
+		{"kill -9 $$", "UNKNOWN", nagiosUnknown, false},
+	}
+	for i, test := range tests {
+		plugin := writePlugin(t, dir, "check_"+strings.Repeat("x", i+1), test.script)
+		target := &models.Target{ID: "exec", Spec: models.IPTarget{Exec: &models.ExecOptions{Command: plugin}}}
+		result := executor.probeExec(context.Background(), target)
+		details, ok := result.Details.(ExecDetails)
+		if !ok {
+			t.Fatalf("%q: 没有运行详情: %+v", test.script, result)
+		}
+		if details.Status != test.status || details.ExitCode != test.code || result.Success != test.success {
+			t.Errorf("%q: 状态为%s(%d) success=%v，应为%s(%d) success=%v", test.script,
+				details.Status, details.ExitCode, result.Success, test.status, test.code, test.success)
+		}
+		if result.Metrics["exec_status"] != float64(test.code) {
+			t.Errorf("%q: exec_status为%v", test.script, result.Metrics["exec_status"])
+		}
+	}
+}
+
+func TestResolvePlugin(t *testing.T) {
+	root := t.TempDir()
+	plugins := filepath.Join(root, "plugins")
+	outside := filepath.Join(root, "plugins-other")
+	for _, dir := range []string{plugins, outside} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	allowed := writePlugin(t, plugins, "check_ok", "exit 0")
+	escaped := writePlugin(t, outside, "check_evil", "exit 0")
+	symlink := func(target, name string) string {
+		path := filepath.Join(plugins, name)
+		if err := os.Symlink(target, path); err != nil {
+			t.Skipf("无法创建符号链接: %v", err)
+		}
+		return path
+	}
+	linkInside := symlink(allowed, "check_alias")
+	linkOutside := symlink(escaped, "check_link")
+	dirOutside := symlink(outside, "vendor")
+
+	// 允许的目录本身是符号链接时按实际路径比较
+	linkedPlugins := filepath.Join(root, "linked")
+	if err := os.Symlink(plugins, linkedPlugins); err != nil {
+		t.Fatal(err)
+	}
+
+	executor := NewExecutor(1, []string{linkedPlugins})
+	for _, command := range []string{allowed, linkInside, filepath.Join(linkedPlugins, "check_ok")} {
+		if path, err := executor.resolvePlugin(command); err != nil || path != allowed {
+			t.Errorf("%s 应解析为%s，得到%q %v", command, allowed, path, err)
+		}
+	}
+
+	rejected := []string{
+		escaped,
+		filepath.Join(plugins, "..", "plugins-other", "check_evil"),
+		filepath.Join(plugins, "..", "plugins"),
+		linkOutside,
+		filepath.Join(dirOutside, "check_evil"),
+		"/bin/sh",
+	}
+	for _, command := range rejected {
+		if path, err := executor.resolvePlugin(command); err == nil {
+			t.Errorf("%s 应被拒绝，得到%q", command, path)
+		}
+	}
+
+	if _, err := NewExecutor(1, nil).resolvePlugin(allowed); err == nil {
+		t.Error("未配置exec_plugin_dirs时应拒绝运行")
+	}
+}
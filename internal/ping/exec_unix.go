@@ -0,0 +1,16 @@
+//go:build !windows
+
+package ping
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// configureSandbox 在独立进程组中运行插件，超时时结束整个进程组
+func configureSandbox(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
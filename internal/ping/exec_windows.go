@@ -0,0 +1,8 @@
+//go:build windows
+
+package ping
+
+import "os/exec"
+
+// configureSandbox Windows下超时时仅结束插件进程本身
+func configureSandbox(cmd *exec.Cmd) {}
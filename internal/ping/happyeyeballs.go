@@ -0,0 +1,379 @@
+package ping
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"scallop/internal/models"
+)
+
+// RFC 8305 推荐的时间参数
+const (
+	resolutionDelay        = 50 * time.Millisecond  // A记录先返回时等待AAAA记录的时间
+	connectionAttemptDelay = 250 * time.Millisecond // 相邻两次连接尝试的间隔
+	raceTimeout            = 10 * time.Second
+)
+
+// RaceAttempt 竞速中的一次连接尝试
+type RaceAttempt struct {
+	Addr      string  `json:"addr"`
+	Family    string  `json:"family"`             // ipv6或ipv4
+	StartMs   float64 `json:"start_ms"`           // 相对于解析开始的发起时间
+	ConnectMs float64 `json:"connect_ms"`         // 本次尝试的建连耗时，失败时为0
+	Error     string  `json:"error,omitempty"`    // 失败原因
+	Raced     bool    `json:"raced"`              // 是否参与竞速，未参与的为竞速结束后的补充测量
+	Winner    bool    `json:"winner,omitempty"`   // 是否赢得竞速
+	Canceled  bool    `json:"canceled,omitempty"` // 竞速结束时尚未发起而被取消
+	Late      bool    `json:"late,omitempty"`     // AAAA记录在竞速开始后才返回，插入竞速的地址
+}
+
+// HappyEyeballsDetails 一次连接竞速的详情
+type HappyEyeballsDetails struct {
+	Winner    string        `json:"winner"`     // 胜出的地址族
+	Addr      string        `json:"addr"`       // 胜出的地址
+	Fallback  bool          `json:"fallback"`   // 是否回退到了非首选地址族
+	ResolveMs float64       `json:"resolve_ms"` // 解析耗时（至竞速开始）
+	RaceMs    float64       `json:"race_ms"`    // 从解析开始到连接建立的总耗时
+	IPv6Count int           `json:"ipv6_count"` // 解析到的IPv6地址数量
+	IPv4Count int           `json:"ipv4_count"` // 解析到的IPv4地址数量
+	Attempts  []RaceAttempt `json:"attempts"`
+}
+
+// attemptResult 单次连接尝试的结果
+type attemptResult struct {
+	index   int
+	elapsed time.Duration
+	err     error
+}
+
+// probeHappyEyeballs 按RFC 8305对双栈服务进行连接竞速，记录胜出的地址族、各地址族的建连耗时以及是否发生回退
+// addr为host:port或URL，URL未指定端口时按协议取80或443
+func (e *Executor) probeHappyEyeballs(ctx context.Context, target *models.Target) Result {
+	host, port, err := raceEndpoint(target.Addr)
+	if err != nil {
+		fmt.Printf("Happy Eyeballs地址无效 %s: %v\n", target.Addr, err)
+		return Result{}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, raceTimeout)
+	defer cancel()
+
+	start := time.Now()
+	ipv6, ipv4, late := resolveDualStack(ctx, host, target.DNSServer)
+	if len(ipv6) == 0 && len(ipv4) == 0 {
+		fmt.Printf("Happy Eyeballs解析失败 %s\n", host)
+		return Result{}
+	}
+
+	details := HappyEyeballsDetails{
+		ResolveMs: millis(time.Since(start)),
+		IPv6Count: len(ipv6),
+		IPv4Count: len(ipv4),
+	}
+	addrs := interleaveFamilies(ipv6, ipv4)
+	for _, ip := range addrs {
+		details.Attempts = append(details.Attempts, RaceAttempt{Addr: ip.String(), Family: family(ip), Raced: true})
+	}
+
+	winner, lateIPv6 := race(ctx, start, addrs, port, &details, late)
+	if len(lateIPv6) > 0 {
+		ipv6 = lateIPv6
+		details.IPv6Count = len(ipv6)
+	}
+	if winner < 0 {
+		fmt.Printf("Happy Eyeballs所有连接均失败 %s\n", target.Addr)
+		return Result{Metrics: map[string]float64{"he_ipv6_count": float64(len(ipv6)), "he_ipv4_count": float64(len(ipv4))}, Details: details}
+	}
+
+	won := &details.Attempts[winner]
+	won.Winner = true
+	details.Winner = won.Family
+	details.Addr = won.Addr
+	details.Fallback = won.Family == "ipv4" && len(ipv6) > 0
+
+	// 竞速中未完成建连的地址族单独补测一次，保证每个地址族都有建连耗时
+	connectMs := map[string]float64{}
+	for _, attempt := range details.Attempts {
+		if attempt.ConnectMs > 0 {
+			if _, ok := connectMs[attempt.Family]; !ok {
+				connectMs[attempt.Family] = attempt.ConnectMs
+			}
+		}
+	}
+	for _, ips := range [][]net.IP{ipv6, ipv4} {
+		if len(ips) == 0 {
+			continue
+		}
+		if _, ok := connectMs[family(ips[0])]; ok {
+			continue
+		}
+		attempt := measureConnect(ctx, ips[0], port)
+		details.Attempts = append(details.Attempts, attempt)
+		if attempt.Error == "" {
+			connectMs[attempt.Family] = attempt.ConnectMs
+		}
+	}
+
+	winnerIPv6, fallback := 0.0, 0.0
+	if details.Winner == "ipv6" {
+		winnerIPv6 = 1
+	}
+	if details.Fallback {
+		fallback = 1
+	}
+	metrics := map[string]float64{
+		"he_race_ms":     details.RaceMs,
+		"he_winner_ipv6": winnerIPv6,
+		"he_fallback":    fallback,
+		"he_ipv6_count":  float64(len(ipv6)),
+		"he_ipv4_count":  float64(len(ipv4)),
+	}
+	if ms, ok := connectMs["ipv6"]; ok {
+		metrics["he_ipv6_connect_ms"] = ms
+	}
+	if ms, ok := connectMs["ipv4"]; ok {
+		metrics["he_ipv4_connect_ms"] = ms
+	}
+
+	return Result{
+		Latency: details.RaceMs,
+		Success: true,
+		Addr:    details.Addr,
+		Metrics: metrics,
+		Details: details,
+	}
+}
+
+// race 按顺序每隔connectionAttemptDelay发起一次连接，上一次尝试失败时立即发起下一次，返回第一个成功的尝试序号
+// 胜出后不再发起新的尝试，已发起的尝试继续等待完成，以便记录另一地址族的建连耗时；
+// late不为nil时竞速期间仍等待AAAA记录，按RFC 8305第3节将之后返回的IPv6地址插入尚未发起的候选地址，
+// 同时返回这些地址
+func race(ctx context.Context, start time.Time, addrs []net.IP, port string, details *HappyEyeballsDetails, late <-chan []net.IP) (int, []net.IP) {
+	results := make(chan attemptResult, len(addrs))
+	next, pending, winner := 0, 0, -1
+	var lastLaunch time.Time
+
+	// 只需要建连耗时，连接建立后立即关闭
+	launch := func() {
+		index, ip := next, addrs[next]
+		lastLaunch = time.Now()
+		details.Attempts[index].StartMs = millis(lastLaunch.Sub(start))
+		next++
+		pending++
+		go func() {
+			attemptStart := time.Now()
+			var d net.Dialer
+			conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), port))
+			elapsed := time.Since(attemptStart)
+			if err == nil {
+				conn.Close()
+			}
+			// 插入AAAA记录后尝试次数可能超过缓冲区，竞速因超时结束时不再有人接收
+			select {
+			case results <- attemptResult{index: index, elapsed: elapsed, err: err}:
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	launch()
+	timer := time.NewTimer(connectionAttemptDelay)
+	defer timer.Stop()
+	timerActive := true
+	resetTimer := func(d time.Duration) {
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(d)
+		timerActive = true
+	}
+
+	var lateIPv6 []net.IP
+	for pending > 0 || (late != nil && winner < 0) {
+		select {
+		case <-timer.C:
+			timerActive = false
+			if winner < 0 && next < len(addrs) {
+				launch()
+				resetTimer(connectionAttemptDelay)
+			}
+		case ips := <-late:
+			late = nil
+			if len(ips) == 0 {
+				continue
+			}
+			lateIPv6 = ips
+			if winner >= 0 {
+				continue
+			}
+			// 尚未发起的候选地址都是IPv4地址，与新的IPv6地址交替排列
+			remaining := interleaveFamilies(ips, addrs[next:])
+			addrs = append(addrs[:next:next], remaining...)
+			details.Attempts = details.Attempts[:next]
+			for _, ip := range remaining {
+				details.Attempts = append(details.Attempts, RaceAttempt{Addr: ip.String(), Family: family(ip), Raced: true, Late: family(ip) == "ipv6"})
+			}
+			// 没有进行中的尝试时立即发起，否则与上一次尝试仍间隔connectionAttemptDelay
+			if pending == 0 {
+				launch()
+				resetTimer(connectionAttemptDelay)
+			} else if !timerActive {
+				resetTimer(max(0, connectionAttemptDelay-time.Since(lastLaunch)))
+			}
+		case result := <-results:
+			pending--
+			attempt := &details.Attempts[result.index]
+			if result.err != nil {
+				attempt.Error = result.err.Error()
+				// 失败后立即发起下一次尝试，并重新计算间隔
+				if winner < 0 && next < len(addrs) {
+					launch()
+					resetTimer(connectionAttemptDelay)
+				}
+				continue
+			}
+			attempt.ConnectMs = millis(result.elapsed)
+			if winner < 0 {
+				winner = result.index
+				details.RaceMs = millis(time.Since(start))
+			}
+		case <-ctx.Done():
+			pending = 0
+			late = nil
+		}
+	}
+
+	for i := next; i < len(addrs); i++ {
+		details.Attempts[i].Canceled = true
+	}
+	return winner, lateIPv6
+}
+
+// measureConnect 单独测量到某个地址的建连耗时
+func measureConnect(ctx context.Context, ip net.IP, port string) RaceAttempt {
+	attempt := RaceAttempt{Addr: ip.String(), Family: family(ip)}
+	var d net.Dialer
+	start := time.Now()
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), port))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	attempt.ConnectMs = millis(time.Since(start))
+	conn.Close()
+	return attempt
+}
+
+// resolveDualStack 并行查询AAAA和A记录；A记录先返回时最多再等待resolutionDelay，
+// 仍未返回时先以IPv4地址开始竞速，AAAA记录之后由late返回
+func resolveDualStack(ctx context.Context, host, dnsServer string) (ipv6, ipv4 []net.IP, late <-chan []net.IP) {
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.To4() != nil {
+			return nil, []net.IP{ip}, nil
+		}
+		return []net.IP{ip}, nil, nil
+	}
+
+	resolver := newDialer(dnsServer).Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	type answer struct {
+		network string
+		ips     []net.IP
+	}
+	answers := make(chan answer, 2)
+	for _, network := range []string{"ip6", "ip4"} {
+		go func(network string) {
+			ips, _ := resolver.LookupIP(ctx, network, host)
+			answers <- answer{network: network, ips: ips}
+		}(network)
+	}
+
+	// AAAA记录先返回时仍等待A记录，以便两个地址族交替排列
+	first := <-answers
+	if first.network == "ip6" {
+		ipv6 = first.ips
+		second := <-answers
+		ipv4 = second.ips
+		return ipv6, ipv4, nil
+	}
+
+	ipv4 = first.ips
+	if len(ipv4) == 0 {
+		// 没有IPv4地址时只能等待AAAA记录
+		ipv6 = (<-answers).ips
+		return ipv6, ipv4, nil
+	}
+	select {
+	case second := <-answers:
+		return second.ips, ipv4, nil
+	case <-time.After(resolutionDelay):
+	}
+
+	// 查询在ctx到期时结束，AAAA记录总会返回
+	lateAnswer := make(chan []net.IP, 1)
+	go func() {
+		lateAnswer <- (<-answers).ips
+	}()
+	return nil, ipv4, lateAnswer
+}
+
+// interleaveFamilies 交替排列两个地址族的地址，IPv6优先
+func interleaveFamilies(ipv6, ipv4 []net.IP) []net.IP {
+	addrs := make([]net.IP, 0, len(ipv6)+len(ipv4))
+	for i := 0; i < len(ipv6) || i < len(ipv4); i++ {
+		if i < len(ipv6) {
+			addrs = append(addrs, ipv6[i])
+		}
+		if i < len(ipv4) {
+			addrs = append(addrs, ipv4[i])
+		}
+	}
+	return addrs
+}
+
+// raceEndpoint 从host:port或URL中取出主机和端口
+func raceEndpoint(addr string) (string, string, error) {
+	if strings.Contains(addr, "://") {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return "", "", err
+		}
+		port := u.Port()
+		if port == "" {
+			port = "443"
+			if u.Scheme == "http" {
+				port = "80"
+			}
+		}
+		return u.Hostname(), port, nil
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return strings.Trim(addr, "[]"), "443", nil
+	}
+	return host, port, nil
+}
+
+// family 返回IP地址所属的地址族
+func family(ip net.IP) string {
+	if ip.To4() != nil {
+		return "ipv4"
+	}
+	return "ipv6"
+}
+
+// millis 将耗时转换为毫秒
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
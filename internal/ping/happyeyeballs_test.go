@@ -0,0 +1,77 @@
+package ping
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// raceDetails 为地址列表生成竞速详情
+func raceDetails(addrs []net.IP) *HappyEyeballsDetails {
+	details := &HappyEyeballsDetails{}
+	for _, ip := range addrs {
+		details.Attempts = append(details.Attempts, RaceAttempt{Addr: ip.String(), Family: family(ip), Raced: true})
+	}
+	return details
+}
+
+func TestRaceLateAAAA(t *testing.T) {
+	// 只在IPv6回环地址上监听，同一端口的IPv4回环地址拒绝连接
+	listener, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("不支持IPv6回环地址: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// IPv4尝试全部失败后仍等待AAAA记录，返回后立即发起IPv6连接
+	addrs := []net.IP{net.ParseIP("127.0.0.1")}
+	details := raceDetails(addrs)
+	late := make(chan []net.IP, 1)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		late <- []net.IP{net.ParseIP("::1")}
+	}()
+	winner, lateIPv6 := race(ctx, time.Now(), addrs, port, details, late)
+	if winner != 1 || len(lateIPv6) != 1 {
+		t.Fatalf("胜出的尝试为%d，晚到的地址为%v: %+v", winner, lateIPv6, details.Attempts)
+	}
+	if len(details.Attempts) != 2 || details.Attempts[0].Error == "" || !details.Attempts[1].Late || details.Attempts[1].Family != "ipv6" {
+		t.Fatalf("竞速详情不正确: %+v", details.Attempts)
+	}
+
+	// 晚到的AAAA记录插入尚未发起的IPv4地址之间，不会排在全部IPv4地址之后
+	addrs = []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("127.0.0.2"), net.ParseIP("127.0.0.3")}
+	details = raceDetails(addrs)
+	late = make(chan []net.IP, 1)
+	late <- []net.IP{net.ParseIP("::1")}
+	winner, _ = race(ctx, time.Now(), addrs, port, details, late)
+	if winner < 0 || details.Attempts[winner].Addr != "::1" || !details.Attempts[winner].Late {
+		t.Fatalf("IPv6地址应胜出: %+v", details.Attempts)
+	}
+	if len(details.Attempts) != 4 || details.Attempts[3].Addr != "127.0.0.3" || !details.Attempts[3].Canceled {
+		t.Fatalf("竞速详情不正确: %+v", details.Attempts)
+	}
+
+	// 没有AAAA记录时按原有地址结束竞速
+	addrs = []net.IP{net.ParseIP("127.0.0.1")}
+	details = raceDetails(addrs)
+	late = make(chan []net.IP, 1)
+	late <- nil
+	if winner, lateIPv6 := race(ctx, time.Now(), addrs, port, details, late); winner != -1 || lateIPv6 != nil {
+		t.Fatalf("应全部失败: winner=%d late=%v", winner, lateIPv6)
+	}
+}
@@ -0,0 +1,126 @@
+package ping
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+
+	"scallop/internal/models"
+)
+
+// probeHTTP 下载配置的对象（最多MaxBytes字节），记录首字节时间、总耗时和吞吐量
+// 延迟取首字节时间（TTFB），便于与ping延迟在同一图表中对比
+func (e *Executor) probeHTTP(ctx context.Context, target *models.Target) Result {
+	opts := models.HTTPOptions{MaxBytes: 1 << 20, Timeout: 30}
+	if target.Spec.HTTP != nil {
+		opts = *target.Spec.HTTP
+	}
+
+	transport := &http.Transport{
+		Proxy:             http.ProxyFromEnvironment,
+		DialContext:       newDialer(target.DNSServer).DialContext,
+		DisableKeepAlives: true, // 每次都重新建立连接，测量真实的首次访问性能
+	}
+	proxied := target.Spec.Proxy != nil
+	if proxied {
+		transport.Proxy = http.ProxyURL(proxyURL(target.Spec.Proxy))
+	}
+	client := &http.Client{
+		Timeout:   time.Duration(opts.Timeout) * time.Second,
+		Transport: transport,
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.Addr, nil)
+	if err != nil {
+		fmt.Printf("HTTP请求创建失败 %s: %v\n", target.Addr, err)
+		return Result{}
+	}
+
+	// 经由代理时建立的TCP连接即为到代理的连接，对端地址也是代理的地址，不作为目标地址记录
+	var firstByte, connectStart time.Time
+	var proxyConnect time.Duration
+	var remoteAddr string
+	trace := &httptrace.ClientTrace{
+		ConnectStart: func(network, addr string) { connectStart = time.Now() },
+		ConnectDone: func(network, addr string, err error) {
+			if err == nil && proxyConnect == 0 {
+				proxyConnect = time.Since(connectStart)
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			if !proxied {
+				remoteAddr = remoteIP(info.Conn)
+			}
+		},
+		GotFirstResponseByte: func() { firstByte = time.Now() },
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Printf("HTTP请求失败 %s: %v\n", target.Addr, err)
+		return Result{}
+	}
+	defer resp.Body.Close()
+
+	received, err := io.Copy(io.Discard, io.LimitReader(resp.Body, opts.MaxBytes))
+	total := time.Since(start)
+	if err != nil {
+		fmt.Printf("HTTP下载失败 %s: %v\n", target.Addr, err)
+		return Result{Addr: remoteAddr}
+	}
+	if firstByte.IsZero() {
+		firstByte = time.Now()
+	}
+
+	metrics := map[string]float64{
+		"http_status":   float64(resp.StatusCode),
+		"http_total_ms": float64(total.Microseconds()) / 1000,
+		"http_bytes":    float64(received),
+	}
+	if proxied {
+		metrics["http_proxy_connect_ms"] = float64(proxyConnect.Microseconds()) / 1000
+	}
+	if total > 0 {
+		metrics["http_throughput_mbps"] = float64(received) * 8 / total.Seconds() / 1e6
+	}
+
+	ttfb := float64(firstByte.Sub(start).Microseconds()) / 1000
+	if resp.StatusCode >= http.StatusBadRequest {
+		fmt.Printf("HTTP状态码异常 %s: %d\n", target.Addr, resp.StatusCode)
+		return Result{Latency: ttfb, Success: false, Addr: remoteAddr, Metrics: metrics}
+	}
+
+	return Result{Latency: ttfb, Success: true, Addr: remoteAddr, Metrics: metrics}
+}
+
+// remoteIP 返回连接对端的IP地址
+func remoteIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// newDialer 创建拨号器，指定DNS服务器时使用该服务器解析域名
+func newDialer(dnsServer string) *net.Dialer {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if dnsServer == "" {
+		return dialer
+	}
+
+	dialer.Resolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			d := net.Dialer{Timeout: 5 * time.Second}
+			return d.DialContext(ctx, network, net.JoinHostPort(dnsServer, "53"))
+		},
+	}
+	return dialer
+}
@@ -0,0 +1,137 @@
+package ping
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"scallop/internal/models"
+)
+
+// ntpEpochOffset NTP纪元（1900年）与Unix纪元（1970年）相差的秒数
+const ntpEpochOffset = 2208988800
+
+// NTPDetails 一次NTP查询的详情
+type NTPDetails struct {
+	OffsetMs  float64 `json:"offset_ms"`
+	DelayMs   float64 `json:"delay_ms"`
+	Stratum   int     `json:"stratum"`
+	Leap      int     `json:"leap"`
+	RefID     string  `json:"ref_id"`
+	Precision int     `json:"precision"`
+}
+
+// probeNTP 以SNTP客户端方式查询NTP服务器，记录往返延迟、时钟偏移、层级和闰秒状态
+func (e *Executor) probeNTP(ctx context.Context, target *models.Target) Result {
+	addr := target.Addr
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), "123")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	conn, err := newDialer(target.DNSServer).DialContext(ctx, "udp", addr)
+	if err != nil {
+		fmt.Printf("NTP连接失败 %s: %v\n", addr, err)
+		return Result{}
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	// LI=0, VN=4, Mode=3（客户端）
+	request := make([]byte, 48)
+	request[0] = 0<<6 | 4<<3 | 3
+
+	t1 := time.Now()
+	putNTPTime(request[40:], t1)
+	if _, err := conn.Write(request); err != nil {
+		fmt.Printf("NTP请求失败 %s: %v\n", addr, err)
+		return Result{}
+	}
+
+	// 回复的原始时间戳必须与请求的发送时间戳相同（RFC 5905），否则是伪造或过期的回复，丢弃后继续等待
+	response := make([]byte, 48)
+	var t4 time.Time
+	for {
+		n, err := conn.Read(response)
+		t4 = time.Now()
+		if err != nil {
+			fmt.Printf("NTP响应失败 %s: %v\n", addr, err)
+			return Result{Addr: remoteIP(conn)}
+		}
+		if n >= 48 && bytes.Equal(response[24:32], request[40:48]) {
+			break
+		}
+		fmt.Printf("NTP响应与请求不匹配，已忽略 %s\n", addr)
+	}
+
+	mode := response[0] & 0x07
+	if mode != 4 {
+		fmt.Printf("NTP响应模式异常 %s: %d\n", addr, mode)
+		return Result{Addr: remoteIP(conn)}
+	}
+
+	t2 := getNTPTime(response[32:])
+	t3 := getNTPTime(response[40:])
+	delay := t4.Sub(t1) - t3.Sub(t2)
+	offset := (t2.Sub(t1) + t3.Sub(t4)) / 2
+
+	details := NTPDetails{
+		OffsetMs:  float64(offset.Microseconds()) / 1000,
+		DelayMs:   float64(delay.Microseconds()) / 1000,
+		Stratum:   int(response[1]),
+		Leap:      int(response[0] >> 6),
+		RefID:     formatRefID(response[12:16], int(response[1])),
+		Precision: int(int8(response[3])),
+	}
+
+	// 层级0为Kiss-o'-Death，闰秒标志3表示服务器未同步
+	success := details.Stratum >= 1 && details.Stratum <= 15 && details.Leap != 3
+	if !success {
+		fmt.Printf("NTP服务器未同步 %s: stratum=%d leap=%d refid=%s\n", addr, details.Stratum, details.Leap, details.RefID)
+	}
+
+	return Result{
+		Latency: details.DelayMs,
+		Success: success,
+		Addr:    remoteIP(conn),
+		Metrics: map[string]float64{
+			"ntp_offset_ms": details.OffsetMs,
+			"ntp_delay_ms":  details.DelayMs,
+			"ntp_stratum":   float64(details.Stratum),
+			"ntp_leap":      float64(details.Leap),
+		},
+		Details: details,
+	}
+}
+
+// putNTPTime 以NTP 64位时间戳格式写入时间
+func putNTPTime(b []byte, t time.Time) {
+	seconds := uint64(t.Unix()) + ntpEpochOffset
+	fraction := uint64(t.Nanosecond()) << 32 / 1e9
+	binary.BigEndian.PutUint32(b[0:4], uint32(seconds))
+	binary.BigEndian.PutUint32(b[4:8], uint32(fraction))
+}
+
+// getNTPTime 解析NTP 64位时间戳
+func getNTPTime(b []byte) time.Time {
+	seconds := int64(binary.BigEndian.Uint32(b[0:4])) - ntpEpochOffset
+	fraction := int64(binary.BigEndian.Uint32(b[4:8]))
+	return time.Unix(seconds, fraction*1e9>>32)
+}
+
+// formatRefID 格式化参考标识：层级1为ASCII时钟源，其余为上游服务器IPv4地址
+func formatRefID(b []byte, stratum int) string {
+	if stratum <= 1 {
+		return strings.TrimRight(string(b), "\x00")
+	}
+	return net.IP(b).String()
+}
@@ -0,0 +1,97 @@
+package ping
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"scallop/internal/models"
+)
+
+// ntpServer 在本机UDP端口模拟NTP服务器，每个请求先发送spoofed个原始时间戳错误的回复，
+// correct为true时再发送正确的回复；服务器时钟比本机快offset
+func ntpServer(t *testing.T, spoofed int, correct bool, offset time.Duration) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		request := make([]byte, 48)
+		for {
+			n, addr, err := conn.ReadFrom(request)
+			if err != nil {
+				return
+			}
+			if n < 48 {
+				continue
+			}
+			received := time.Now().Add(offset)
+			reply := func(origin []byte) {
+				response := make([]byte, 48)
+				response[0] = 0<<6 | 4<<3 | 4 // LI=0, VN=4, Mode=4（服务器）
+				response[1] = 2
+				copy(response[12:16], net.IPv4(10, 0, 0, 1).To4())
+				copy(response[24:32], origin)
+				putNTPTime(response[32:], received)
+				putNTPTime(response[40:], time.Now().Add(offset))
+				conn.WriteTo(response, addr)
+			}
+			for i := 0; i < spoofed; i++ {
+				// 伪造的回复声称服务器时钟慢了一小时
+				forged := make([]byte, 8)
+				putNTPTime(forged, time.Now().Add(-time.Hour))
+				response := make([]byte, 48)
+				response[0] = 4<<3 | 4
+				response[1] = 1
+				copy(response[24:32], forged)
+				putNTPTime(response[32:], time.Now().Add(-time.Hour))
+				putNTPTime(response[40:], time.Now().Add(-time.Hour))
+				conn.WriteTo(response, addr)
+			}
+			if correct {
+				reply(request[40:48])
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func probeNTPAt(t *testing.T, addr string, timeout time.Duration) Result {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return (&Executor{}).probeNTP(ctx, &models.Target{Addr: addr})
+}
+
+func TestNTPProbe(t *testing.T) {
+	result := probeNTPAt(t, ntpServer(t, 0, true, 2*time.Second), 2*time.Second)
+	if !result.Success {
+		t.Fatalf("探测失败: %+v", result)
+	}
+	if offset := result.Metrics["ntp_offset_ms"]; offset < 1900 || offset > 2100 {
+		t.Fatalf("时钟偏移为%.1f毫秒，应约为2000毫秒", offset)
+	}
+	if details := result.Details.(NTPDetails); details.Stratum != 2 || details.RefID != "10.0.0.1" {
+		t.Fatalf("详情不正确: %+v", details)
+	}
+}
+
+func TestNTPProbeIgnoresMismatchedOrigin(t *testing.T) {
+	// 伪造的回复先到达，被忽略后仍使用正确的回复
+	result := probeNTPAt(t, ntpServer(t, 3, true, 0), 2*time.Second)
+	if !result.Success {
+		t.Fatalf("探测失败: %+v", result)
+	}
+	if offset := result.Metrics["ntp_offset_ms"]; offset < -100 || offset > 100 {
+		t.Fatalf("使用了伪造的回复，时钟偏移为%.1f毫秒", offset)
+	}
+
+	// 只有伪造的回复时等待到超时，探测失败
+	result = probeNTPAt(t, ntpServer(t, 1, false, 0), 300*time.Millisecond)
+	if result.Success || result.Metrics != nil {
+		t.Fatalf("只有伪造的回复时应失败: %+v", result)
+	}
+}
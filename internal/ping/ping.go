@@ -1,6 +1,7 @@
 package ping
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"os/exec"
@@ -15,27 +16,65 @@ import (
 
 // Executor Ping执行器
 type Executor struct {
-	pingCount int
+	pingCount  int
+	pluginDirs []string // 允许exec探测运行的插件目录
 }
 
 // NewExecutor 创建Ping执行器
-func NewExecutor(pingCount int) *Executor {
+func NewExecutor(pingCount int, pluginDirs []string) *Executor {
 	return &Executor{
-		pingCount: pingCount,
+		pingCount:  pingCount,
+		pluginDirs: pluginDirs,
+	}
+}
+
+// Result 一次探测的结果
+type Result struct {
+	Latency float64            // 延迟，毫秒
+	Success bool               // 是否成功
+	Addr    string             // 实际探测的IP地址（域名解析后）
+	Metrics map[string]float64 // 附加指标，随结果一并保存
+	Details interface{}        // 探测详情（如证书信息），保存为目标的最新状态
+	Events  []models.Event     // 当前状态对应的事件，由监控器在级别变化时记录
+}
+
+// Probe 按目标的探测类型执行探测，ctx取消时尽快中止并返回失败结果
+func (e *Executor) Probe(ctx context.Context, target *models.Target) Result {
+	switch target.Spec.ProbeType() {
+	case models.ProbeTCP:
+		return e.probeTCP(ctx, target)
+	case models.ProbeHTTP:
+		return e.probeHTTP(ctx, target)
+	case models.ProbeTLS:
+		return e.probeTLS(ctx, target)
+	case models.ProbeNTP:
+		return e.probeNTP(ctx, target)
+	case models.ProbeExec:
+		return e.probeExec(ctx, target)
+	case models.ProbeHappyEyeballs:
+		return e.probeHappyEyeballs(ctx, target)
+	default:
+		return e.probeICMP(ctx, target)
 	}
 }
 
 // Ping 执行ping操作
-func (e *Executor) Ping(target *models.Target) (float64, bool) {
+func (e *Executor) Ping(ctx context.Context, target *models.Target) (float64, bool) {
+	result := e.probeICMP(ctx, target)
+	return result.Latency, result.Success
+}
+
+// probeICMP 解析地址后执行多次ping，取成功结果的平均延迟
+func (e *Executor) probeICMP(ctx context.Context, target *models.Target) Result {
 	// 解析地址，支持IPv4、IPv6和域名
 	addr := target.Addr
 
 	// 如果是域名，先进行DNS解析
 	if !isIPAddress(addr) {
-		resolvedAddr, err := resolveAddress(addr, target.DNSServer)
+		resolvedAddr, err := resolveAddress(ctx, addr, target.DNSServer)
 		if err != nil {
 			fmt.Printf("DNS解析失败 %s: %v\n", addr, err)
-			return 0, false
+			return Result{}
 		}
 		addr = resolvedAddr
 	}
@@ -44,8 +83,8 @@ func (e *Executor) Ping(target *models.Target) (float64, bool) {
 	var latencies []float64
 	successCount := 0
 
-	for i := 0; i < e.pingCount; i++ {
-		latency, success := singlePing(addr)
+	for i := 0; i < e.pingCount && ctx.Err() == nil; i++ {
+		latency, success := singlePing(ctx, addr)
 		if success {
 			latencies = append(latencies, latency)
 			successCount++
@@ -54,7 +93,7 @@ func (e *Executor) Ping(target *models.Target) (float64, bool) {
 
 	// 如果所有ping都失败，返回失败
 	if successCount == 0 {
-		return 0, false
+		return Result{Addr: addr}
 	}
 
 	// 计算平均延迟
@@ -64,11 +103,11 @@ func (e *Executor) Ping(target *models.Target) (float64, bool) {
 	}
 	avgLatency := sum / float64(len(latencies))
 
-	return avgLatency, true
+	return Result{Latency: avgLatency, Success: true, Addr: addr}
 }
 
 // singlePing 执行单次ping
-func singlePing(addr string) (float64, bool) {
+func singlePing(ctx context.Context, addr string) (float64, bool) {
 	start := time.Now()
 
 	var cmd *exec.Cmd
@@ -76,19 +115,19 @@ func singlePing(addr string) (float64, bool) {
 		// Windows ping命令，自动检测IPv4/IPv6
 		if strings.Contains(addr, ":") {
 			// IPv6
-			cmd = exec.Command("ping", "-6", "-n", "1", "-w", "3000", addr)
+			cmd = exec.CommandContext(ctx, "ping", "-6", "-n", "1", "-w", "3000", addr)
 		} else {
 			// IPv4
-			cmd = exec.Command("ping", "-4", "-n", "1", "-w", "3000", addr)
+			cmd = exec.CommandContext(ctx, "ping", "-4", "-n", "1", "-w", "3000", addr)
 		}
 	} else {
 		// Linux/Mac ping命令
 		if strings.Contains(addr, ":") {
 			// IPv6
-			cmd = exec.Command("ping6", "-c", "1", "-W", "3", addr)
+			cmd = exec.CommandContext(ctx, "ping6", "-c", "1", "-W", "3", addr)
 		} else {
 			// IPv4
-			cmd = exec.Command("ping", "-c", "1", "-W", "3", addr)
+			cmd = exec.CommandContext(ctx, "ping", "-c", "1", "-W", "3", addr)
 		}
 	}
 
@@ -116,14 +155,14 @@ func isIPAddress(addr string) bool {
 }
 
 // resolveAddress 解析域名地址
-func resolveAddress(hostname, dnsServer string) (string, error) {
+func resolveAddress(ctx context.Context, hostname, dnsServer string) (string, error) {
 	// 如果指定了DNS服务器，使用nslookup或dig
 	if dnsServer != "" {
-		return resolveWithCustomDNS(hostname, dnsServer)
+		return resolveWithCustomDNS(ctx, hostname, dnsServer)
 	}
 
 	// 使用系统默认DNS
-	ips, err := net.LookupIP(hostname)
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", hostname)
 	if err != nil {
 		return "", err
 	}
@@ -144,18 +183,18 @@ func resolveAddress(hostname, dnsServer string) (string, error) {
 }
 
 // resolveWithCustomDNS 使用自定义DNS服务器解析域名
-func resolveWithCustomDNS(hostname, dnsServer string) (string, error) {
+func resolveWithCustomDNS(ctx context.Context, hostname, dnsServer string) (string, error) {
 	var cmd *exec.Cmd
 
 	if runtime.GOOS == "windows" {
 		// Windows使用nslookup
-		cmd = exec.Command("nslookup", hostname, dnsServer)
+		cmd = exec.CommandContext(ctx, "nslookup", hostname, dnsServer)
 	} else {
 		// Linux/Mac优先使用dig，如果没有则使用nslookup
 		if _, err := exec.LookPath("dig"); err == nil {
-			cmd = exec.Command("dig", "+short", "@"+dnsServer, hostname)
+			cmd = exec.CommandContext(ctx, "dig", "+short", "@"+dnsServer, hostname)
 		} else {
-			cmd = exec.Command("nslookup", hostname, dnsServer)
+			cmd = exec.CommandContext(ctx, "nslookup", hostname, dnsServer)
 		}
 	}
 
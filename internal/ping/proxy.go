@@ -0,0 +1,223 @@
+package ping
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"scallop/internal/models"
+)
+
+// 代理类型
+const (
+	ProxySOCKS5 = "socks5"
+	ProxyHTTP   = "http"
+)
+
+// dialTiming 建立连接各阶段的耗时
+type dialTiming struct {
+	ProxyConnect time.Duration // 到代理的TCP建连耗时，未使用代理时为0
+	Total        time.Duration // 到目标的端到端建连耗时（含代理隧道建立）
+}
+
+// dialTarget 建立到addr的TCP连接，目标配置了代理时经由代理建立隧道
+// 经由代理时目标域名由代理解析，dns_server只用于解析代理地址
+func dialTarget(ctx context.Context, target *models.Target, addr string) (net.Conn, dialTiming, error) {
+	var timing dialTiming
+	dialer := newDialer(target.DNSServer)
+
+	start := time.Now()
+	proxy := target.Spec.Proxy
+	if proxy == nil {
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		timing.Total = time.Since(start)
+		return conn, timing, err
+	}
+
+	proxyConn, err := dialer.DialContext(ctx, "tcp", proxy.Addr)
+	if err != nil {
+		return nil, timing, fmt.Errorf("连接代理失败: %v", err)
+	}
+	timing.ProxyConnect = time.Since(start)
+
+	if deadline, ok := ctx.Deadline(); ok {
+		proxyConn.SetDeadline(deadline)
+	}
+	conn := proxyConn
+	switch proxy.Type {
+	case ProxyHTTP:
+		conn, err = httpConnect(proxyConn, addr, proxy)
+	default:
+		err = socks5Connect(proxyConn, addr, proxy)
+	}
+	if err != nil {
+		proxyConn.Close()
+		return nil, timing, err
+	}
+	proxyConn.SetDeadline(time.Time{})
+
+	timing.Total = time.Since(start)
+	return conn, timing, nil
+}
+
+// proxyURL 将代理配置转换为http.Transport使用的代理URL
+func proxyURL(proxy *models.ProxyOptions) *url.URL {
+	scheme := ProxySOCKS5
+	if proxy.Type == ProxyHTTP {
+		scheme = ProxyHTTP
+	}
+	u := &url.URL{Scheme: scheme, Host: proxy.Addr}
+	if proxy.Username != "" {
+		u.User = url.UserPassword(proxy.Username, proxy.Password)
+	}
+	return u
+}
+
+// socks5Connect 在已连接的代理上完成SOCKS5握手（RFC 1928），需要时使用用户名密码认证（RFC 1929）
+func socks5Connect(conn net.Conn, addr string, proxy *models.ProxyOptions) error {
+	host, portText, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil {
+		return fmt.Errorf("端口无效: %s", portText)
+	}
+
+	// 协商认证方式：0x00无认证，0x02用户名密码
+	methods := []byte{0x00}
+	if proxy.Username != "" {
+		methods = []byte{0x02}
+	}
+	if _, err := conn.Write(append([]byte{0x05, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("SOCKS5握手失败: %v", err)
+	}
+	if reply[0] != 0x05 {
+		return fmt.Errorf("SOCKS5版本不匹配: %d", reply[0])
+	}
+
+	switch reply[1] {
+	case 0x00:
+	case 0x02:
+		if len(proxy.Username) > 255 || len(proxy.Password) > 255 {
+			return errors.New("SOCKS5用户名或密码过长")
+		}
+		auth := []byte{0x01, byte(len(proxy.Username))}
+		auth = append(auth, proxy.Username...)
+		auth = append(auth, byte(len(proxy.Password)))
+		auth = append(auth, proxy.Password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return fmt.Errorf("SOCKS5认证失败: %v", err)
+		}
+		if reply[1] != 0x00 {
+			return errors.New("SOCKS5认证被拒绝")
+		}
+	default:
+		return errors.New("SOCKS5代理不接受可用的认证方式")
+	}
+
+	// CONNECT请求，域名交由代理解析
+	request := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			request = append(append(request, 0x01), ip4...)
+		} else {
+			request = append(append(request, 0x04), ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return errors.New("域名过长")
+		}
+		request = append(append(request, 0x03, byte(len(host))), host...)
+	}
+	request = binary.BigEndian.AppendUint16(request, uint16(port))
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("SOCKS5连接请求失败: %v", err)
+	}
+	if header[1] != 0x00 {
+		return fmt.Errorf("SOCKS5代理连接目标失败: 错误码%d", header[1])
+	}
+
+	// 跳过代理返回的绑定地址和端口
+	var skip int
+	switch header[3] {
+	case 0x01:
+		skip = net.IPv4len + 2
+	case 0x04:
+		skip = net.IPv6len + 2
+	case 0x03:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		skip = int(length[0]) + 2
+	default:
+		return fmt.Errorf("SOCKS5地址类型未知: %d", header[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip))
+	return err
+}
+
+// httpConnect 通过HTTP CONNECT方法建立隧道，需要时使用Basic认证
+func httpConnect(conn net.Conn, addr string, proxy *models.ProxyOptions) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Host: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxy.Username != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxy.Username + ":" + proxy.Password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	// 隧道建立后响应体即为隧道数据，不读取也不关闭
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP代理响应无效: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP代理拒绝连接: %s", resp.Status)
+	}
+
+	// 代理可能已随响应发送了隧道中的数据，保留缓冲区中的内容
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn 先读取缓冲区中剩余数据的连接
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
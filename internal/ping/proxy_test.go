@@ -0,0 +1,273 @@
+package ping
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"scallop/internal/models"
+)
+
+// listen 在本机随机端口监听，测试结束时关闭，每个连接交给handle处理
+func listen(t *testing.T, handle func(conn net.Conn)) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// echoServer 原样返回收到的数据
+func echoServer(t *testing.T) string {
+	return listen(t, func(conn net.Conn) { io.Copy(conn, conn) })
+}
+
+// closedAddr 没有监听的本机地址
+func closedAddr(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	return addr
+}
+
+// tunnel 连接目标并在两个连接之间转发数据
+func tunnel(client net.Conn, target net.Conn) {
+	defer target.Close()
+	go io.Copy(target, client)
+	io.Copy(client, target)
+}
+
+// socks5Server 最小的SOCKS5代理，username非空时要求用户名密码认证，
+// 绑定地址以域名形式返回，以覆盖客户端跳过不同类型绑定地址的处理
+func socks5Server(t *testing.T, username, password string) string {
+	return listen(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return
+		}
+		methods := make([]byte, header[1])
+		if _, err := io.ReadFull(r, methods); err != nil {
+			return
+		}
+		if username == "" {
+			conn.Write([]byte{0x05, 0x00})
+		} else {
+			if !strings.Contains(string(methods), "\x02") {
+				conn.Write([]byte{0x05, 0xff})
+				return
+			}
+			conn.Write([]byte{0x05, 0x02})
+			version, _ := r.ReadByte()
+			ulen, _ := r.ReadByte()
+			user := make([]byte, ulen)
+			io.ReadFull(r, user)
+			plen, _ := r.ReadByte()
+			pass := make([]byte, plen)
+			io.ReadFull(r, pass)
+			if version != 0x01 || string(user) != username || string(pass) != password {
+				conn.Write([]byte{0x01, 0x01})
+				return
+			}
+			conn.Write([]byte{0x01, 0x00})
+		}
+
+		request := make([]byte, 4)
+		if _, err := io.ReadFull(r, request); err != nil {
+			return
+		}
+		var host string
+		switch request[3] {
+		case 0x01:
+			ip := make([]byte, net.IPv4len)
+			io.ReadFull(r, ip)
+			host = net.IP(ip).String()
+		case 0x04:
+			ip := make([]byte, net.IPv6len)
+			io.ReadFull(r, ip)
+			host = net.IP(ip).String()
+		case 0x03:
+			length, _ := r.ReadByte()
+			name := make([]byte, length)
+			io.ReadFull(r, name)
+			host = string(name)
+		}
+		port := make([]byte, 2)
+		io.ReadFull(r, port)
+		addr := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+
+		target, err := net.DialTimeout("tcp", addr, time.Second)
+		if err != nil {
+			// 0x05: Connection refused
+			conn.Write([]byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		reply := append([]byte{0x05, 0x00, 0x00, 0x03, byte(len("proxy.local"))}, "proxy.local"...)
+		conn.Write(append(reply, 0x04, 0x38))
+		tunnel(&bufferedConn{Conn: conn, reader: r}, target)
+	})
+}
+
+// connectServer 最小的HTTP CONNECT代理，auth非空时要求Proxy-Authorization与之相同；
+// greeting非空时随200响应一起发送，模拟代理提前发送隧道数据
+func connectServer(t *testing.T, auth, greeting string) string {
+	return listen(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		req, err := http.ReadRequest(r)
+		if err != nil || req.Method != http.MethodConnect {
+			conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+			return
+		}
+		if auth != "" && req.Header.Get("Proxy-Authorization") != auth {
+			conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\nContent-Length: 0\r\n\r\n"))
+			return
+		}
+		target, err := net.DialTimeout("tcp", req.Host, time.Second)
+		if err != nil {
+			conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\nContent-Length: 0\r\n\r\n"))
+			return
+		}
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n" + greeting))
+		tunnel(&bufferedConn{Conn: conn, reader: r}, target)
+	})
+}
+
+func proxyTarget(proxy *models.ProxyOptions) *models.Target {
+	return &models.Target{Spec: models.IPTarget{Proxy: proxy}}
+}
+
+// dialEcho 经由代理连接回显服务器并检查数据能否往返
+func dialEcho(t *testing.T, target *models.Target, addr, greeting string) error {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, timing, err := dialTarget(ctx, target, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if timing.Total <= 0 || timing.ProxyConnect <= 0 || timing.ProxyConnect > timing.Total {
+		t.Errorf("建连耗时不正确: %+v", timing)
+	}
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	want := greeting + "ping"
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Fatalf("经由代理收到%q，应为%q", got, want)
+	}
+	return nil
+}
+
+func TestSOCKS5Proxy(t *testing.T) {
+	echo := echoServer(t)
+	_, port, _ := net.SplitHostPort(echo)
+
+	open := socks5Server(t, "", "")
+	if err := dialEcho(t, proxyTarget(&models.ProxyOptions{Addr: open}), echo, ""); err != nil {
+		t.Fatalf("无认证: %v", err)
+	}
+	// 域名交由代理解析
+	if err := dialEcho(t, proxyTarget(&models.ProxyOptions{Addr: open}), net.JoinHostPort("localhost", port), ""); err != nil {
+		t.Fatalf("域名: %v", err)
+	}
+
+	secured := socks5Server(t, "user", "secret")
+	good := &models.ProxyOptions{Type: ProxySOCKS5, Addr: secured, Username: "user", Password: "secret"}
+	if err := dialEcho(t, proxyTarget(good), echo, ""); err != nil {
+		t.Fatalf("用户名密码认证: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		proxy   *models.ProxyOptions
+		addr    string
+		message string
+	}{
+		{"密码错误", &models.ProxyOptions{Addr: secured, Username: "user", Password: "wrong"}, echo, "SOCKS5认证被拒绝"},
+		{"未提供认证", &models.ProxyOptions{Addr: secured}, echo, "不接受可用的认证方式"},
+		{"目标无法连接", &models.ProxyOptions{Addr: open}, closedAddr(t), "错误码5"},
+		{"代理无法连接", &models.ProxyOptions{Addr: closedAddr(t)}, echo, "连接代理失败"},
+	}
+	for _, test := range tests {
+		err := dialEcho(t, proxyTarget(test.proxy), test.addr, "")
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("%s: 错误为%v，应包含%q", test.name, err, test.message)
+		}
+	}
+}
+
+func TestHTTPConnectProxy(t *testing.T) {
+	echo := echoServer(t)
+
+	// user:secret
+	secured := connectServer(t, "Basic dXNlcjpzZWNyZXQ=", "")
+	good := &models.ProxyOptions{Type: ProxyHTTP, Addr: secured, Username: "user", Password: "secret"}
+	if err := dialEcho(t, proxyTarget(good), echo, ""); err != nil {
+		t.Fatalf("Basic认证: %v", err)
+	}
+
+	// 随响应提前发送的隧道数据不会丢失
+	eager := connectServer(t, "", "hello ")
+	if err := dialEcho(t, proxyTarget(&models.ProxyOptions{Type: ProxyHTTP, Addr: eager}), echo, "hello "); err != nil {
+		t.Fatalf("提前发送数据: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		proxy   *models.ProxyOptions
+		addr    string
+		message string
+	}{
+		{"密码错误", &models.ProxyOptions{Type: ProxyHTTP, Addr: secured, Username: "user", Password: "wrong"}, echo, "407"},
+		{"未提供认证", &models.ProxyOptions{Type: ProxyHTTP, Addr: secured}, echo, "407"},
+		{"目标无法连接", &models.ProxyOptions{Type: ProxyHTTP, Addr: eager}, closedAddr(t), "502"},
+	}
+	for _, test := range tests {
+		err := dialEcho(t, proxyTarget(test.proxy), test.addr, "")
+		if err == nil || !strings.Contains(err.Error(), test.message) || !strings.Contains(err.Error(), "HTTP代理拒绝连接") {
+			t.Errorf("%s: 错误为%v，应为HTTP代理拒绝连接: %s", test.name, err, test.message)
+		}
+	}
+
+	// 代理返回的不是HTTP响应
+	garbage := listen(t, func(conn net.Conn) {
+		bufio.NewReader(conn).ReadString('\n')
+		conn.Write([]byte("SSH-2.0-OpenSSH\r\n"))
+	})
+	err := dialEcho(t, proxyTarget(&models.ProxyOptions{Type: ProxyHTTP, Addr: garbage}), echo, "")
+	if err == nil || !strings.Contains(err.Error(), "HTTP代理响应无效") {
+		t.Errorf("无效响应: 错误为%v", err)
+	}
+}
@@ -0,0 +1,38 @@
+package ping
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"scallop/internal/models"
+)
+
+// probeTCP 建立TCP连接并记录建连耗时
+// 配置了代理时延迟为经由代理的端到端建连耗时，到代理的建连耗时单独记录
+func (e *Executor) probeTCP(ctx context.Context, target *models.Target) Result {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	conn, timing, err := dialTarget(ctx, target, target.Addr)
+	if err != nil {
+		fmt.Printf("TCP连接失败 %s: %v\n", target.Addr, err)
+		return Result{}
+	}
+	defer conn.Close()
+
+	connectMs := float64(timing.Total.Microseconds()) / 1000
+	metrics := map[string]float64{"tcp_connect_ms": connectMs}
+	addr := remoteIP(conn)
+	if target.Spec.Proxy != nil {
+		metrics["tcp_proxy_connect_ms"] = float64(timing.ProxyConnect.Microseconds()) / 1000
+		addr = ""
+	}
+
+	return Result{
+		Latency: connectMs,
+		Success: true,
+		Addr:    addr,
+		Metrics: metrics,
+	}
+}
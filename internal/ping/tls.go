@@ -0,0 +1,178 @@
+package ping
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"scallop/internal/models"
+)
+
+// CertificateInfo 证书链中单个证书的摘要
+type CertificateInfo struct {
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	DNSNames  []string  `json:"dns_names,omitempty"`
+}
+
+// TLSDetails 一次TLS握手的详情
+type TLSDetails struct {
+	ServerName     string            `json:"server_name"`
+	Protocol       string            `json:"protocol"`
+	Cipher         string            `json:"cipher"`
+	ConnectMs      float64           `json:"connect_ms"`
+	ProxyConnectMs float64           `json:"proxy_connect_ms,omitempty"`
+	HandshakeMs    float64           `json:"handshake_ms"`
+	ChainValid     bool              `json:"chain_valid"`
+	VerifyError    string            `json:"verify_error,omitempty"`
+	DaysLeft       float64           `json:"days_left"`
+	Chain          []CertificateInfo `json:"chain"`
+}
+
+// probeTLS 执行TLS握手，记录握手耗时、协商的协议与密码套件、证书链有效性和剩余天数
+func (e *Executor) probeTLS(ctx context.Context, target *models.Target) Result {
+	opts := models.TLSOptions{WarnDays: 30, CriticalDays: 7}
+	if target.Spec.TLS != nil {
+		opts = *target.Spec.TLS
+	}
+
+	addr := target.Addr
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), "443")
+	}
+	host, _, _ := net.SplitHostPort(addr)
+
+	serverName := opts.ServerName
+	if serverName == "" && !isIPAddress(host) {
+		serverName = host
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	rawConn, timing, err := dialTarget(ctx, target, addr)
+	if err != nil {
+		fmt.Printf("TLS连接失败 %s: %v\n", addr, err)
+		return Result{}
+	}
+	defer rawConn.Close()
+	connectTime := timing.Total
+
+	// 经由代理时对端地址是代理的地址，不作为目标地址记录
+	remoteAddr := remoteIP(rawConn)
+	if target.Spec.Proxy != nil {
+		remoteAddr = ""
+	}
+
+	// 先跳过校验完成握手，再单独校验证书链，这样证书无效时仍能记录握手详情
+	rawConn.SetDeadline(time.Now().Add(10 * time.Second))
+	conn := tls.Client(rawConn, &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+	})
+	handshakeStart := time.Now()
+	if err := conn.HandshakeContext(ctx); err != nil {
+		fmt.Printf("TLS握手失败 %s: %v\n", addr, err)
+		return Result{Addr: remoteAddr}
+	}
+	handshakeTime := time.Since(handshakeStart)
+
+	state := conn.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		fmt.Printf("TLS未返回证书 %s\n", addr)
+		return Result{Addr: remoteAddr}
+	}
+
+	details := TLSDetails{
+		ServerName:     serverName,
+		Protocol:       tls.VersionName(state.Version),
+		Cipher:         tls.CipherSuiteName(state.CipherSuite),
+		ConnectMs:      float64(connectTime.Microseconds()) / 1000,
+		ProxyConnectMs: float64(timing.ProxyConnect.Microseconds()) / 1000,
+		HandshakeMs:    float64(handshakeTime.Microseconds()) / 1000,
+	}
+
+	leaf := state.PeerCertificates[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates {
+		details.Chain = append(details.Chain, CertificateInfo{
+			Subject:   cert.Subject.String(),
+			Issuer:    cert.Issuer.String(),
+			NotBefore: cert.NotBefore,
+			NotAfter:  cert.NotAfter,
+			DNSNames:  cert.DNSNames,
+		})
+		if cert != leaf {
+			intermediates.AddCert(cert)
+		}
+	}
+
+	verifyName := serverName
+	if verifyName == "" {
+		verifyName = host
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{DNSName: verifyName, Intermediates: intermediates}); err != nil {
+		details.VerifyError = err.Error()
+	} else {
+		details.ChainValid = true
+	}
+	details.DaysLeft = time.Until(leaf.NotAfter).Hours() / 24
+
+	chainValid := 0.0
+	if details.ChainValid {
+		chainValid = 1
+	}
+
+	metrics := map[string]float64{
+		"tls_connect_ms":   details.ConnectMs,
+		"tls_handshake_ms": details.HandshakeMs,
+		"tls_days_left":    details.DaysLeft,
+		"tls_chain_valid":  chainValid,
+	}
+	if target.Spec.Proxy != nil {
+		metrics["tls_proxy_connect_ms"] = details.ProxyConnectMs
+	}
+
+	return Result{
+		Latency: details.HandshakeMs,
+		Success: details.ChainValid,
+		Addr:    remoteAddr,
+		Metrics: metrics,
+		Details: details,
+		Events:  tlsEvents(target, details, opts),
+	}
+}
+
+// tlsEvents 根据证书状态生成事件，由监控器在级别变化时记录
+func tlsEvents(target *models.Target, details TLSDetails, opts models.TLSOptions) []models.Event {
+	now := time.Now()
+	days := int(details.DaysLeft)
+
+	expiry := models.Event{TargetID: target.ID, Kind: "tls_expiry", Level: models.EventInfo, Timestamp: now}
+	switch {
+	case details.DaysLeft < 0:
+		expiry.Level = models.EventCritical
+		expiry.Message = "证书已过期"
+	case days < opts.CriticalDays:
+		expiry.Level = models.EventCritical
+		expiry.Message = fmt.Sprintf("证书将在%d天后过期", days)
+	case days < opts.WarnDays:
+		expiry.Level = models.EventWarning
+		expiry.Message = fmt.Sprintf("证书将在%d天后过期", days)
+	default:
+		expiry.Message = fmt.Sprintf("证书有效，剩余%d天", days)
+	}
+
+	chain := models.Event{TargetID: target.ID, Kind: "tls_chain", Level: models.EventInfo, Message: "证书链校验通过", Timestamp: now}
+	if !details.ChainValid {
+		chain.Level = models.EventCritical
+		chain.Message = "证书链校验失败: " + details.VerifyError
+	}
+
+	return []models.Event{expiry, chain}
+}
@@ -0,0 +1,232 @@
+package registry
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"scallop/internal/models"
+)
+
+// 目标变化事件类型
+const (
+	EventAdded   = "added"
+	EventRemoved = "removed"
+	EventChanged = "changed"
+)
+
+// Event 一次目标变化，移除事件中的Target为移除前的目标
+type Event struct {
+	Type    string         `json:"type"`
+	Target  *models.Target `json:"target"`
+	Version uint64         `json:"version"` // 产生该变化的快照版本
+}
+
+// Snapshot 某一版本的目标集合，创建后不再修改，可以在任意goroutine中读取
+// 快照中的目标同样不得修改，需要变化时由Update或SetAddress整体替换
+type Snapshot struct {
+	Version uint64
+	targets map[string]*models.Target
+	order   []*models.Target // 按配置中的顺序排列
+}
+
+// Get 按ID获取目标
+func (s *Snapshot) Get(id string) (*models.Target, bool) {
+	target, ok := s.targets[id]
+	return target, ok
+}
+
+// List 按配置中的顺序返回所有目标
+func (s *Snapshot) List() []*models.Target {
+	return append([]*models.Target{}, s.order...)
+}
+
+// Len 目标数量
+func (s *Snapshot) Len() int {
+	return len(s.order)
+}
+
+// Registry 当前活跃目标的注册表
+// 读取方获取不可变的快照，更新时复制出新快照后整体替换（写时复制），读取无需加锁
+type Registry struct {
+	current     atomic.Pointer[Snapshot]
+	mutex       sync.Mutex // 串行化更新和订阅者的增删
+	subscribers map[*subscriber]struct{}
+}
+
+// New 创建空的注册表，初始版本为0
+func New() *Registry {
+	r := &Registry{subscribers: make(map[*subscriber]struct{})}
+	r.current.Store(&Snapshot{targets: make(map[string]*models.Target)})
+	return r
+}
+
+// Snapshot 获取当前快照
+func (r *Registry) Snapshot() *Snapshot {
+	return r.current.Load()
+}
+
+// Update 用targets替换当前目标集合，返回相对上一版本的变化并通知订阅者
+// 没有任何变化时不产生新版本
+func (r *Registry) Update(targets []*models.Target) []Event {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	old := r.current.Load()
+	next := &Snapshot{
+		Version: old.Version + 1,
+		targets: make(map[string]*models.Target, len(targets)),
+		order:   make([]*models.Target, 0, len(targets)),
+	}
+
+	var events []Event
+	for _, target := range targets {
+		if _, ok := next.targets[target.ID]; ok {
+			continue
+		}
+		previous, ok := old.targets[target.ID]
+		if ok && target.ResolvedIP == "" && previous.ResolvedIP != "" {
+			// 最近探测到的地址由SetAddress维护，配置重新加载后继续保留
+			carried := *target
+			carried.ResolvedIP, carried.Geo = previous.ResolvedIP, previous.Geo
+			target = &carried
+		}
+		next.targets[target.ID] = target
+		next.order = append(next.order, target)
+
+		switch {
+		case !ok:
+			events = append(events, Event{Type: EventAdded, Target: target, Version: next.Version})
+		case changed(previous, target):
+			events = append(events, Event{Type: EventChanged, Target: target, Version: next.Version})
+		}
+	}
+	for _, target := range old.order {
+		if _, ok := next.targets[target.ID]; !ok {
+			events = append(events, Event{Type: EventRemoved, Target: target, Version: next.Version})
+		}
+	}
+
+	// 顺序变化也需要新快照，但不产生事件
+	if len(events) == 0 && sameOrder(old.order, next.order) {
+		return nil
+	}
+	r.current.Store(next)
+
+	for sub := range r.subscribers {
+		sub.push(events)
+	}
+	return events
+}
+
+// SetAddress 更新目标最近探测到的IP地址及其归属，返回是否产生了新快照
+// 地址和归属不属于目标的配置，变化时只替换快照，不产生事件
+func (r *Registry) SetAddress(id, ip string, geo models.GeoInfo) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	old := r.current.Load()
+	target, ok := old.targets[id]
+	if !ok || (target.ResolvedIP == ip && target.Geo != nil && *target.Geo == geo) {
+		return false
+	}
+
+	updated := *target
+	updated.ResolvedIP, updated.Geo = ip, &geo
+	next := &Snapshot{
+		Version: old.Version + 1,
+		targets: make(map[string]*models.Target, len(old.targets)),
+		order:   make([]*models.Target, 0, len(old.order)),
+	}
+	for _, item := range old.order {
+		if item.ID == id {
+			item = &updated
+		}
+		next.targets[item.ID] = item
+		next.order = append(next.order, item)
+	}
+	r.current.Store(next)
+	return true
+}
+
+// Subscribe 订阅此后的目标变化，事件按版本顺序送达且不会丢失
+// ctx取消后停止订阅并关闭返回的通道
+func (r *Registry) Subscribe(ctx context.Context) <-chan Event {
+	sub := &subscriber{wake: make(chan struct{}, 1)}
+	r.mutex.Lock()
+	r.subscribers[sub] = struct{}{}
+	r.mutex.Unlock()
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		defer func() {
+			r.mutex.Lock()
+			delete(r.subscribers, sub)
+			r.mutex.Unlock()
+		}()
+
+		for {
+			for _, event := range sub.drain() {
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-sub.wake:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events
+}
+
+// subscriber 订阅者的待送达事件队列，Update不会因订阅者处理缓慢而阻塞
+type subscriber struct {
+	mutex   sync.Mutex
+	pending []Event
+	wake    chan struct{}
+}
+
+// push 追加事件并唤醒订阅者
+func (s *subscriber) push(events []Event) {
+	s.mutex.Lock()
+	s.pending = append(s.pending, events...)
+	s.mutex.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// drain 取出所有待送达的事件
+func (s *subscriber) drain() []Event {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	events := s.pending
+	s.pending = nil
+	return events
+}
+
+// changed 同一目标的配置项或心跳令牌是否变化
+func changed(old, new *models.Target) bool {
+	return old.HeartbeatToken != new.HeartbeatToken || !reflect.DeepEqual(old.Spec, new.Spec)
+}
+
+// sameOrder 两个目标列表的ID顺序是否一致
+func sameOrder(a, b []*models.Target) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].ID != b[i].ID {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,196 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"scallop/internal/models"
+)
+
+func target(id, addr string) *models.Target {
+	return &models.Target{ID: id, Addr: addr, Spec: models.IPTarget{Addr: addr}}
+}
+
+// eventList 事件的类型和目标ID，便于比较
+func eventList(events []Event) []string {
+	var list []string
+	for _, event := range events {
+		list = append(list, fmt.Sprintf("%s %s v%d", event.Type, event.Target.ID, event.Version))
+	}
+	return list
+}
+
+func equalList(a, b []string) bool {
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+func TestUpdate(t *testing.T) {
+	r := New()
+	if r.Snapshot().Version != 0 || r.Snapshot().Len() != 0 {
+		t.Fatalf("初始快照不正确: %+v", r.Snapshot())
+	}
+
+	events := r.Update([]*models.Target{target("a", "10.0.0.1"), target("b", "10.0.0.2")})
+	if want := []string{"added a v1", "added b v1"}; !equalList(eventList(events), want) {
+		t.Fatalf("事件为%v，应为%v", eventList(events), want)
+	}
+
+	// 内容相同的新对象不算变化，不产生新版本
+	if events := r.Update([]*models.Target{target("a", "10.0.0.1"), target("b", "10.0.0.2")}); events != nil {
+		t.Fatalf("没有变化时产生了事件: %v", eventList(events))
+	}
+	if version := r.Snapshot().Version; version != 1 {
+		t.Fatalf("没有变化时版本变为%d", version)
+	}
+
+	// 新增和修改按新列表的顺序排列，移除排在最后
+	events = r.Update([]*models.Target{target("c", "10.0.0.3"), target("a", "10.0.0.9")})
+	if want := []string{"added c v2", "changed a v2", "removed b v2"}; !equalList(eventList(events), want) {
+		t.Fatalf("事件为%v，应为%v", eventList(events), want)
+	}
+	if removed := events[2].Target; removed.Addr != "10.0.0.2" {
+		t.Fatalf("移除事件应携带移除前的目标: %+v", removed)
+	}
+
+	// 只有顺序变化时产生新快照但没有事件
+	before := r.Snapshot()
+	if events := r.Update([]*models.Target{target("a", "10.0.0.9"), target("c", "10.0.0.3")}); len(events) != 0 {
+		t.Fatalf("顺序变化产生了事件: %v", eventList(events))
+	}
+	after := r.Snapshot()
+	if after.Version != 3 || after.List()[0].ID != "a" {
+		t.Fatalf("顺序变化后的快照不正确: version=%d", after.Version)
+	}
+	// 旧快照不受影响
+	if before.Version != 2 || before.List()[0].ID != "c" {
+		t.Fatalf("旧快照被修改: version=%d", before.Version)
+	}
+
+	// 重复的ID只保留第一个
+	r.Update([]*models.Target{target("a", "10.0.0.9"), target("a", "10.0.0.8")})
+	if got, _ := r.Snapshot().Get("a"); r.Snapshot().Len() != 1 || got.Addr != "10.0.0.9" {
+		t.Fatalf("重复ID处理不正确: %+v", r.Snapshot().List())
+	}
+}
+
+func TestSubscribeSlowSubscriber(t *testing.T) {
+	r := New()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := r.Subscribe(ctx)
+
+	// 订阅者不读取时Update也不阻塞
+	const updates = 200
+	done := make(chan struct{})
+	go func() {
+		for i := 1; i <= updates; i++ {
+			r.Update([]*models.Target{target("a", fmt.Sprintf("10.0.%d.%d", i/256, i%256))})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("订阅者未读取时Update被阻塞")
+	}
+
+	// 事件按版本顺序全部送达
+	for version := uint64(1); version <= updates; version++ {
+		select {
+		case event := <-events:
+			want := EventChanged
+			if version == 1 {
+				want = EventAdded
+			}
+			if event.Version != version || event.Type != want {
+				t.Fatalf("第%d个事件为%s v%d", version, event.Type, event.Version)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("只收到%d个事件", version-1)
+		}
+	}
+
+	// 取消后关闭通道
+	cancel()
+	for range events {
+	}
+}
+
+func TestConcurrentSnapshot(t *testing.T) {
+	r := New()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				snapshot := r.Snapshot()
+				for _, item := range snapshot.List() {
+					if got, ok := snapshot.Get(item.ID); !ok || got != item {
+						t.Errorf("快照v%d不一致", snapshot.Version)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range r.Subscribe(ctx) {
+		}
+	}()
+
+	for i := 0; i < 500; i++ {
+		targets := []*models.Target{target("a", fmt.Sprint(i))}
+		if i%2 == 0 {
+			targets = append(targets, target("b", "10.0.0.2"))
+		}
+		r.Update(targets)
+	}
+	cancel()
+	wg.Wait()
+}
+
+func TestSetAddress(t *testing.T) {
+	r := New()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := r.Subscribe(ctx)
+	r.Update([]*models.Target{target("a", "example.com"), target("b", "10.0.0.2")})
+	<-events
+	<-events
+
+	geo := models.GeoInfo{ASN: 64500, Org: "Example", Country: "CN"}
+	if !r.SetAddress("a", "192.0.2.1", geo) {
+		t.Fatal("地址变化时应产生新快照")
+	}
+	if r.SetAddress("a", "192.0.2.1", geo) || r.SetAddress("missing", "192.0.2.1", geo) {
+		t.Fatal("地址未变化或目标不存在时不应产生新快照")
+	}
+	snapshot := r.Snapshot()
+	got, _ := snapshot.Get("a")
+	if snapshot.Version != 2 || got.ResolvedIP != "192.0.2.1" || *got.Geo != geo || snapshot.List()[0] != got {
+		t.Fatalf("快照不正确: version=%d target=%+v", snapshot.Version, got)
+	}
+
+	// 配置重新加载产生的新目标对象保留已探测到的地址，地址变化不产生事件
+	r.Update([]*models.Target{target("a", "example.com"), target("b", "10.0.0.3")})
+	if got, _ := r.Snapshot().Get("a"); got.ResolvedIP != "192.0.2.1" || got.Geo == nil {
+		t.Fatalf("重新加载后地址丢失: %+v", got)
+	}
+	if event := <-events; event.Type != EventChanged || event.Target.ID != "b" {
+		t.Fatalf("事件为%s %s，应只有b的变化", event.Type, event.Target.ID)
+	}
+	select {
+	case event := <-events:
+		t.Fatalf("多余的事件: %s %s", event.Type, event.Target.ID)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
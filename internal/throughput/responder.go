@@ -0,0 +1,191 @@
+package throughput
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxConnections 响应端同时服务的最大连接数，超出时返回BUSY
+const maxConnections = MaxStreams * 2
+
+// Responder 带宽测试响应端
+type Responder struct {
+	listenAddr string
+	secret     string
+	allowed    []netip.Prefix
+	slots      chan struct{}
+
+	mutex  sync.Mutex
+	nonces map[string]time.Time // 有效期内已使用的握手随机数，防止截获的握手被重放
+}
+
+// NewResponder 创建带宽测试响应端，secret非空时只接受携带相同密钥签名的测试，
+// allowed非空时只接受来自其中地址或网段的连接
+func NewResponder(listenAddr, secret string, allowed []string) (*Responder, error) {
+	prefixes, err := ParseSources(allowed)
+	if err != nil {
+		return nil, err
+	}
+	return &Responder{
+		listenAddr: listenAddr,
+		secret:     secret,
+		allowed:    prefixes,
+		slots:      make(chan struct{}, maxConnections),
+		nonces:     make(map[string]time.Time),
+	}, nil
+}
+
+// ListenAndServe 监听并处理带宽测试请求，ctx取消后停止监听并返回nil
+func (r *Responder) ListenAndServe(ctx context.Context) error {
+	listener, err := net.Listen("tcp", r.listenAddr)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	stop := context.AfterFunc(ctx, func() { listener.Close() })
+	defer stop()
+
+	fmt.Printf("带宽测试响应端启动在 %s\n", r.listenAddr)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go r.handle(conn)
+	}
+}
+
+// handle 处理单个测试流
+func (r *Responder) handle(conn net.Conn) {
+	defer conn.Close()
+	if !r.allow(conn.RemoteAddr()) {
+		fmt.Printf("拒绝不在throughput_allow中的带宽测试来源: %s\n", conn.RemoteAddr())
+		fmt.Fprintf(conn, "ERR forbidden\n")
+		return
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return
+	}
+
+	fields := strings.Fields(line)
+	if (len(fields) != 3 && len(fields) != 6) || fields[0] != protocolVersion {
+		fmt.Fprintf(conn, "ERR bad request\n")
+		return
+	}
+	direction := fields[1]
+	seconds, err := strconv.Atoi(fields[2])
+	if err != nil || seconds <= 0 || (direction != "UP" && direction != "DOWN") {
+		fmt.Fprintf(conn, "ERR bad request\n")
+		return
+	}
+	if !r.authorize(fields, direction, seconds) {
+		fmt.Printf("带宽测试握手认证失败: %s\n", conn.RemoteAddr())
+		fmt.Fprintf(conn, "ERR unauthorized\n")
+		return
+	}
+	if seconds > MaxDuration {
+		seconds = MaxDuration
+	}
+
+	// 限制并发，避免被过多测试占满带宽
+	select {
+	case r.slots <- struct{}{}:
+		defer func() { <-r.slots }()
+	default:
+		fmt.Fprintf(conn, "BUSY\n")
+		return
+	}
+
+	if _, err := fmt.Fprintf(conn, "OK\n"); err != nil {
+		return
+	}
+
+	duration := time.Duration(seconds) * time.Second
+	conn.SetDeadline(time.Now().Add(duration + 10*time.Second))
+
+	if direction == "UP" {
+		n, _ := io.Copy(io.Discard, reader)
+		fmt.Fprintf(conn, "%d\n", n)
+		return
+	}
+
+	buf := make([]byte, bufferSize)
+	deadline := time.Now().Add(duration)
+	for time.Now().Before(deadline) {
+		if _, err := conn.Write(buf); err != nil {
+			return
+		}
+	}
+}
+
+// allow 判断连接来源是否在允许列表中，列表为空时不限制
+func (r *Responder) allow(remote net.Addr) bool {
+	if len(r.allowed) == 0 {
+		return true
+	}
+	addrPort, err := netip.ParseAddrPort(remote.String())
+	if err != nil {
+		return false
+	}
+	addr := addrPort.Addr().Unmap()
+	for _, prefix := range r.allowed {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize 校验握手签名：时间戳须在允许的时钟偏差内，随机数在有效期内不能重复使用。
+// 未设置密钥时不校验，签名字段（如有）被忽略
+func (r *Responder) authorize(fields []string, direction string, seconds int) bool {
+	if r.secret == "" {
+		return true
+	}
+	if len(fields) != 6 {
+		return false
+	}
+	timestamp, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return false
+	}
+	now := time.Now()
+	issued := time.Unix(timestamp, 0)
+	if issued.Before(now.Add(-maxClockSkew)) || issued.After(now.Add(maxClockSkew)) {
+		return false
+	}
+	nonce := fields[4]
+	expected := sign(r.secret, direction, seconds, timestamp, nonce)
+	if !hmac.Equal([]byte(expected), []byte(fields[5])) {
+		return false
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for seen, expires := range r.nonces {
+		if now.After(expires) {
+			delete(r.nonces, seen)
+		}
+	}
+	if _, ok := r.nonces[nonce]; ok {
+		return false
+	}
+	r.nonces[nonce] = issued.Add(maxClockSkew)
+	return true
+}
@@ -0,0 +1,106 @@
+package throughput
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serve 在本机随机端口运行响应端，测试结束时关闭
+func serve(t *testing.T, secret string, allowed []string) string {
+	t.Helper()
+	responder, err := NewResponder("", secret, allowed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go responder.handle(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// exchange 发送一行握手并返回响应端的第一行回复
+func exchange(t *testing.T, addr, line string) string {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := fmt.Fprint(conn, line); err != nil {
+		t.Fatal(err)
+	}
+	reply, _ := bufio.NewReader(conn).ReadString('\n')
+	return strings.TrimSpace(reply)
+}
+
+func stream(addr, secret string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	n, err := runStream(ctx, addr, secret, "DOWN", time.Second)
+	if err == nil && n == 0 {
+		err = fmt.Errorf("没有收到数据")
+	}
+	return err
+}
+
+func TestResponderSecret(t *testing.T) {
+	addr := serve(t, "secret", nil)
+	if err := stream(addr, "secret"); err != nil {
+		t.Fatalf("密钥正确时测试失败: %v", err)
+	}
+	for _, secret := range []string{"", "wrong"} {
+		if err := stream(addr, secret); err == nil || !strings.Contains(err.Error(), "ERR unauthorized") {
+			t.Errorf("密钥为%q时错误为%v，应被拒绝", secret, err)
+		}
+	}
+
+	// 截获的握手不能重放
+	line := handshake("secret", "UP", 1)
+	if reply := exchange(t, addr, line); reply != "OK" {
+		t.Fatalf("首次握手回复%q", reply)
+	}
+	if reply := exchange(t, addr, line); reply != "ERR unauthorized" {
+		t.Fatalf("重放的握手回复%q，应被拒绝", reply)
+	}
+
+	// 时间戳超出允许的时钟偏差
+	issued := time.Now().Add(-2 * maxClockSkew).Unix()
+	stale := fmt.Sprintf("%s DOWN 1 %d nonce %s\n", protocolVersion, issued, sign("secret", "DOWN", 1, issued, "nonce"))
+	if reply := exchange(t, addr, stale); reply != "ERR unauthorized" {
+		t.Fatalf("过期的握手回复%q，应被拒绝", reply)
+	}
+
+	// 未设置密钥的响应端忽略签名字段，兼容设置了密钥的客户端
+	if err := stream(serve(t, "", nil), "secret"); err != nil {
+		t.Fatalf("响应端未设置密钥时测试失败: %v", err)
+	}
+}
+
+func TestResponderAllow(t *testing.T) {
+	if err := stream(serve(t, "", []string{"10.0.0.0/8", "::1"}), ""); err == nil || !strings.Contains(err.Error(), "ERR forbidden") {
+		t.Errorf("来源不在允许列表中时错误为%v，应被拒绝", err)
+	}
+	if err := stream(serve(t, "", []string{"127.0.0.1"}), ""); err != nil {
+		t.Errorf("来源在允许列表中时测试失败: %v", err)
+	}
+	if _, err := NewResponder("", "", []string{"10.0.0.0/33"}); err == nil {
+		t.Error("无效网段应返回错误")
+	}
+}
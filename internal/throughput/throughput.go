@@ -0,0 +1,210 @@
+package throughput
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"scallop/internal/models"
+)
+
+const (
+	// DefaultPort 响应端默认端口
+	DefaultPort = 5201
+	// DefaultDuration 默认单方向测试时长（秒）
+	DefaultDuration = 5
+	// MaxDuration 单方向测试时长上限（秒），响应端也会按此截断
+	MaxDuration = 30
+	// MaxStreams 并行流数量上限
+	MaxStreams = 8
+	// MinInterval 两次测试之间的最小间隔（秒），避免长期占用链路
+	MinInterval = 600
+
+	// protocolVersion 协议握手标识
+	protocolVersion = "SCALLOP-TP/1"
+	// bufferSize 读写缓冲区大小
+	bufferSize = 128 * 1024
+	// maxClockSkew 携带密钥的握手中时间戳与响应端时钟的最大偏差
+	maxClockSkew = 5 * time.Minute
+)
+
+// Result 一次带宽测试的结果
+type Result struct {
+	UploadMbps   float64
+	DownloadMbps float64
+}
+
+// Normalize 填充默认值并限制测试参数范围
+func Normalize(opts *models.ThroughputOptions) {
+	if opts.Port <= 0 || opts.Port > 65535 {
+		opts.Port = DefaultPort
+	}
+	if opts.Duration <= 0 {
+		opts.Duration = DefaultDuration
+	}
+	if opts.Duration > MaxDuration {
+		opts.Duration = MaxDuration
+	}
+	if opts.Streams <= 0 {
+		opts.Streams = 1
+	}
+	if opts.Streams > MaxStreams {
+		opts.Streams = MaxStreams
+	}
+	if opts.Interval < MinInterval {
+		opts.Interval = MinInterval
+	}
+}
+
+// ParseSources 解析允许的来源列表，每项为IP地址或CIDR网段
+func ParseSources(sources []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(sources))
+	for _, source := range sources {
+		prefix, err := netip.ParsePrefix(source)
+		if err != nil {
+			addr, err := netip.ParseAddr(source)
+			if err != nil {
+				return nil, fmt.Errorf("地址无效: %s", source)
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// sign 计算握手签名：以共享密钥对方向、时长、时间戳和随机数做HMAC-SHA256，密钥本身不在网络上传输
+func sign(secret, direction string, seconds int, timestamp int64, nonce string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s %s %d %d %s", protocolVersion, direction, seconds, timestamp, nonce)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// handshake 生成握手行，secret非空时附加时间戳、随机数和签名
+func handshake(secret, direction string, seconds int) string {
+	if secret == "" {
+		return fmt.Sprintf("%s %s %d\n", protocolVersion, direction, seconds)
+	}
+	random := make([]byte, 16)
+	rand.Read(random)
+	nonce := hex.EncodeToString(random)
+	timestamp := time.Now().Unix()
+	return fmt.Sprintf("%s %s %d %d %s %s\n", protocolVersion, direction, seconds, timestamp, nonce,
+		sign(secret, direction, seconds, timestamp, nonce))
+}
+
+// Run 对指定主机执行上行和下行带宽测试，ctx取消时立即中止
+func Run(ctx context.Context, host string, opts models.ThroughputOptions) (Result, error) {
+	Normalize(&opts)
+	addr := net.JoinHostPort(host, strconv.Itoa(opts.Port))
+	duration := time.Duration(opts.Duration) * time.Second
+
+	upload, err := runParallel(ctx, addr, opts.Secret, "UP", duration, opts.Streams)
+	if err != nil {
+		return Result{}, fmt.Errorf("上行测试失败: %v", err)
+	}
+
+	download, err := runParallel(ctx, addr, opts.Secret, "DOWN", duration, opts.Streams)
+	if err != nil {
+		return Result{}, fmt.Errorf("下行测试失败: %v", err)
+	}
+
+	return Result{UploadMbps: upload, DownloadMbps: download}, nil
+}
+
+// runParallel 并行运行多个流并汇总速率
+func runParallel(ctx context.Context, addr, secret, direction string, duration time.Duration, streams int) (float64, error) {
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var totalBytes int64
+	var firstErr error
+
+	start := time.Now()
+	for i := 0; i < streams; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n, err := runStream(ctx, addr, secret, direction, duration)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			totalBytes += n
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return 0, firstErr
+	}
+
+	elapsed := time.Since(start).Seconds()
+	if elapsed <= 0 {
+		return 0, fmt.Errorf("测试耗时异常")
+	}
+	return float64(totalBytes) * 8 / elapsed / 1e6, nil
+}
+
+// runStream 运行单个测试流，返回传输的字节数
+func runStream(ctx context.Context, addr, secret, direction string, duration time.Duration) (int64, error) {
+	dialer := net.Dialer{Timeout: 5 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	// 整个流的最长耗时：测试时长加上握手和收尾的余量
+	conn.SetDeadline(time.Now().Add(duration + 10*time.Second))
+
+	seconds := int(duration / time.Second)
+	if _, err := io.WriteString(conn, handshake(secret, direction, seconds)); err != nil {
+		return 0, err
+	}
+
+	reader := bufio.NewReader(conn)
+	reply, err := reader.ReadString('\n')
+	if err != nil {
+		return 0, err
+	}
+	if reply = strings.TrimSpace(reply); reply != "OK" {
+		return 0, fmt.Errorf("响应端拒绝测试: %s", reply)
+	}
+
+	if direction == "DOWN" {
+		return io.Copy(io.Discard, reader)
+	}
+
+	// 上行：持续写入直到测试时长结束，然后关闭写方向等待对端回报接收字节数
+	buf := make([]byte, bufferSize)
+	deadline := time.Now().Add(duration)
+	for time.Now().Before(deadline) {
+		if _, err := conn.Write(buf); err != nil {
+			return 0, err
+		}
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.CloseWrite()
+	}
+
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(line), 10, 64)
+}
@@ -1,17 +1,31 @@
 package web
 
 import (
+	"context"
+	"crypto/subtle"
 	"database/sql"
 	"embed"
+	"errors"
 	"fmt"
 	"html/template"
+	"io"
 	"io/fs"
+	"math"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"scallop/internal/config"
 	"scallop/internal/database"
+	"scallop/internal/geoip"
+	"scallop/internal/models"
+	"scallop/internal/registry"
 
 	"github.com/gin-gonic/gin"
 )
@@ -22,25 +36,65 @@ var TemplatesFS embed.FS
 //go:embed static/*
 var StaticFS embed.FS
 
+// secondaryMetrics 各探测类型在趋势图中作为次坐标轴展示的附加指标
+var secondaryMetrics = map[string]string{
+	models.ProbeNTP:           "ntp_offset_ms",
+	models.ProbeHappyEyeballs: "he_ipv6_share",
+}
+
+// 访客延迟（RUM）上报限制
+const (
+	rumMaxRTTs          = 20               // 单次上报最多包含的往返次数
+	rumMaxLatency       = 60000            // 单次往返的最大有效值，毫秒
+	rumSubmitInterval   = 30 * time.Second // 同一客户端网段两次上报的最小间隔
+	rumMaxSubmits       = 200              // 每个上报间隔内全部客户端最多接受的上报次数
+	rumMaxBuckets       = 120              // 聚合查询默认划分的时间段数量
+	rumMinBucketSeconds = 60
+)
+
 // Server Web服务器
 type Server struct {
-	db            *database.DB
+	db            database.Store
 	configManager *config.Manager
+	targets       *registry.Registry
+	geo           *geoip.Resolver
+	rumSubmits    map[string]time.Time // 各客户端网段上次上报RUM样本的时间
+	rumWindow     time.Time            // 当前上报间隔的开始时间
+	rumAccepted   int                  // 当前上报间隔内接受的上报次数
+	rumMutex      sync.Mutex
+
+	// 当前监听的HTTP服务器，web_port变化时替换
+	srv      *http.Server
+	port     int // 实际监听的端口，新端口无法监听时与配置不同
+	serveErr chan error
+	closed   bool
+	srvMutex sync.Mutex
 }
 
-// NewServer 创建Web服务器
-func NewServer(db *database.DB, configManager *config.Manager) *Server {
+// NewServer 创建Web服务器，geo用于查询访客的ASN与地理位置
+func NewServer(db database.Store, configManager *config.Manager, targets *registry.Registry, geo *geoip.Resolver) *Server {
 	return &Server{
 		db:            db,
 		configManager: configManager,
+		targets:       targets,
+		geo:           geo,
+		rumSubmits:    make(map[string]time.Time),
+		serveErr:      make(chan error, 1),
 	}
 }
 
-// Start 启动Web服务器
-func (s *Server) Start() error {
+// Start 启动Web服务器，阻塞直到ctx取消或服务器异常退出，之后由Shutdown完成优雅关闭
+func (s *Server) Start(ctx context.Context) error {
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.Default()
 
+	// 只采用可信代理转发的X-Forwarded-For，未配置时客户端地址即连接的对端地址，
+	// 避免伪造请求头绕过访客延迟上报的频率限制
+	config := s.configManager.Get()
+	if err := r.SetTrustedProxies(config.TrustedProxies); err != nil {
+		return fmt.Errorf("trusted_proxies无效: %v", err)
+	}
+
 	// 设置嵌入的静态文件系统
 	staticSubFS, err := fs.Sub(StaticFS, "static")
 	if err != nil {
@@ -61,9 +115,86 @@ func (s *Server) Start() error {
 	// 注册路由
 	s.registerRoutes(r)
 
-	config := s.configManager.Get()
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", config.WebPort))
+	if err != nil {
+		return err
+	}
+	s.serve(listener, r)
+	s.port = config.WebPort
 	fmt.Printf("Web服务器启动在 http://localhost:%d\n", config.WebPort)
-	return r.Run(fmt.Sprintf(":%d", config.WebPort))
+
+	// web_port变化时重新监听
+	s.configManager.OnChange(s.applyConfig)
+
+	select {
+	case err := <-s.serveErr:
+		return err
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// Shutdown 停止接受新连接，等待进行中的请求（如心跳上报）完成，最多等到ctx到期
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.srvMutex.Lock()
+	s.closed = true
+	srv := s.srv
+	s.srvMutex.Unlock()
+	if srv == nil {
+		return nil
+	}
+	return srv.Shutdown(ctx)
+}
+
+// serve 在listener上提供服务，替换当前的HTTP服务器
+func (s *Server) serve(listener net.Listener, handler http.Handler) {
+	// 关闭时取消请求的context，以结束目标变化推送等长连接
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := &http.Server{
+		Handler:     handler,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	srv.RegisterOnShutdown(cancel)
+	s.srv = srv
+	go func() {
+		if err := srv.Serve(listener); err != http.ErrServerClosed {
+			s.serveErr <- err
+		}
+	}()
+}
+
+// shutdown 切换端口后优雅关闭旧的HTTP服务器，最多等待shutdown_timeout秒
+func (s *Server) shutdown(srv *http.Server) error {
+	timeout := time.Duration(s.configManager.Get().ShutdownTimeout) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return srv.Shutdown(ctx)
+}
+
+// applyConfig web_port变化时先监听新端口，成功后再优雅关闭旧端口上的服务器
+// 标题、介绍等其余配置每次请求时读取，无需处理
+func (s *Server) applyConfig(old, new models.Config) error {
+	s.srvMutex.Lock()
+	defer s.srvMutex.Unlock()
+	if s.closed || s.port == new.WebPort {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", new.WebPort))
+	if err != nil {
+		return fmt.Errorf("Web服务器无法监听端口%d，继续使用端口%d: %v", new.WebPort, s.port, err)
+	}
+	oldSrv, oldPort := s.srv, s.port
+	s.serve(listener, oldSrv.Handler)
+	s.port = new.WebPort
+	fmt.Printf("Web服务器已切换到 http://localhost:%d\n", new.WebPort)
+
+	go func() {
+		if err := s.shutdown(oldSrv); err != nil {
+			fmt.Printf("关闭端口%d上的Web服务器失败: %v\n", oldPort, err)
+		}
+	}()
+	return nil
 }
 
 // registerRoutes 注册路由
@@ -71,6 +202,9 @@ func (s *Server) registerRoutes(r *gin.Engine) {
 	// 主页
 	r.GET("/", s.handleIndex)
 
+	// 访客延迟页面
+	r.GET("/rum", s.handleRUMPage)
+
 	// PWA manifest
 	r.GET("/manifest.json", s.handleManifest)
 
@@ -79,17 +213,35 @@ func (s *Server) registerRoutes(r *gin.Engine) {
 	{
 		api.GET("/ping-data", s.handlePingData)
 		api.GET("/targets", s.handleTargets)
+		api.GET("/targets/events", s.handleTargetEvents)
 		api.GET("/config", s.handleConfig)
+		api.GET("/config/reloads", s.handleConfigReloads)
 		api.GET("/status", s.handleStatus)
+		api.GET("/metrics", s.handleMetrics)
+		api.GET("/events", s.handleEvents)
+		api.GET("/targets/:id/certificate", s.handleCertificate)
+		api.GET("/targets/:id/anycast", s.handleAnycast)
+		api.GET("/targets/:id/happy-eyeballs", s.handleHappyEyeballs)
+		api.GET("/targets/:id/addresses", s.handleAddresses)
+		api.GET("/heartbeat/:token", s.handleHeartbeat)
+		api.POST("/heartbeat/:token", s.handleHeartbeat)
+		api.GET("/rum/echo", s.handleRUMEcho)
+		api.POST("/rum", s.handleRUMSubmit)
+		api.GET("/rum", s.handleRUMSummary)
+		api.GET("/database", s.handleDatabaseStats)
+		api.GET("/retired-targets", s.handleRetiredTargets)
+		api.GET("/retired-targets/:id/history", s.handleRetiredHistory)
+		api.POST("/retired-targets/:id/reactivate", s.requireAdmin, s.handleReactivateTarget)
+		api.DELETE("/retired-targets/:id", s.requireAdmin, s.handlePurgeTarget)
+		api.POST("/backup", s.requireAdmin, s.handleBackup)
 	}
 }
 
 // handleIndex 主页处理
 func (s *Server) handleIndex(c *gin.Context) {
-	targets := s.db.GetTargets()
 	var displayTargets []map[string]interface{}
 
-	for _, target := range targets {
+	for _, target := range s.targets.Snapshot().List() {
 		displayAddr := target.Addr
 		if target.HideAddr {
 			displayAddr = ""
@@ -112,97 +264,664 @@ func (s *Server) handleIndex(c *gin.Context) {
 func (s *Server) handlePingData(c *gin.Context) {
 	targetID := c.Query("target_id")
 	addr := c.Query("addr") // 兼容旧API
-	hours := c.Query("hours")
-	startTime := c.Query("start_time")
-	endTime := c.Query("end_time")
 
-	var since, until time.Time
+	since, until, ok := parseTimeRange(c, 1)
+	if !ok {
+		return
+	}
+
+	// 时间范围较长时返回汇总结果，避免一次返回过多原始结果
+	if targetID != "" {
+		step, ok := pingDataStep(c, since, until)
+		if !ok {
+			return
+		}
+		if database.RollupTier(step) > 0 {
+			s.handleRollups(c, targetID, since, until, step)
+			return
+		}
+		c.Header("X-Resolution", "0")
+	}
+
+	var results []models.PingResult
 	var err error
+	if targetID != "" {
+		results, err = s.db.GetPingResults(targetID, since, until)
+	} else if addr != "" {
+		results, err = s.addrPingResults(addr, since, until)
+	} else {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "需要提供target_id或addr参数"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	data, err := s.pingResultsData(results)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, data)
+}
+
+// addrPingResults 查询地址为addr的所有目标在时间范围内的Ping结果，按时间排序
+func (s *Server) addrPingResults(addr string, since, until time.Time) ([]models.PingResult, error) {
+	targets, err := s.db.LoadTargets()
+	if err != nil {
+		return nil, err
+	}
+
+	var results []models.PingResult
+	for id, target := range targets {
+		if target.Addr != addr {
+			continue
+		}
+		items, err := s.db.GetPingResults(id, since, until)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, items...)
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Timestamp.Before(results[j].Timestamp)
+	})
+	return results, nil
+}
+
+// pingDataPoints 未指定resolution时每个目标最多返回的数据点数
+const pingDataPoints = 500
+
+// pingDataStep 请求的时间分辨率：resolution为秒数或raw（原始结果），未指定时按时间范围自动选择
+// 参数错误时已写入响应并返回false
+func pingDataStep(c *gin.Context, since, until time.Time) (time.Duration, bool) {
+	switch resolution := c.Query("resolution"); resolution {
+	case "":
+		return until.Sub(since) / pingDataPoints, true
+	case "raw":
+		return 0, true
+	default:
+		seconds, err := strconv.Atoi(resolution)
+		if err != nil || seconds < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "resolution应为秒数或raw"})
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+}
+
+// handleRollups 返回目标按step汇总的结果，latency为时间段内的平均延迟，响应头X-Resolution为实际的时间段长度（秒）
+func (s *Server) handleRollups(c *gin.Context, targetID string, since, until time.Time, step time.Duration) {
+	target, err := s.db.GetTarget(targetID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusOK, []interface{}{})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	addr, description := target.Addr, target.Description
+	if target.HideAddr {
+		addr = ""
+	}
+
+	rollups, err := s.db.GetRollups(targetID, since, until, step)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	results := make([]map[string]interface{}, 0, len(rollups))
+	for _, rollup := range rollups {
+		results = append(results, map[string]interface{}{
+			"target_id":   targetID,
+			"addr":        addr,
+			"description": description,
+			"latency":     rollup.Avg,
+			"success":     rollup.Success > 0,
+			"timestamp":   rollup.Timestamp,
+			"count":       rollup.Count,
+			"loss":        rollup.Loss,
+			"min":         rollup.Min,
+			"max":         rollup.Max,
+			"p50":         rollup.P50,
+			"p95":         rollup.P95,
+			"p99":         rollup.P99,
+		})
+	}
+	c.Header("X-Resolution", strconv.Itoa(database.RollupWidth(step)))
+	c.JSON(http.StatusOK, results)
+}
+
+// parseTimeRange 解析查询的时间范围
+// 优先使用start_time/end_time自定义范围，否则使用hours（默认defaultHours）；参数错误时已写入响应并返回false
+func parseTimeRange(c *gin.Context, defaultHours int) (time.Time, time.Time, bool) {
+	startTime := c.Query("start_time")
+	endTime := c.Query("end_time")
 
-	// 优先使用自定义时间范围
 	if startTime != "" && endTime != "" {
-		since, err = time.Parse(time.RFC3339, startTime)
+		since, err := time.Parse(time.RFC3339, startTime)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "开始时间格式错误"})
-			return
+			return time.Time{}, time.Time{}, false
 		}
-		until, err = time.Parse(time.RFC3339, endTime)
+		until, err := time.Parse(time.RFC3339, endTime)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "结束时间格式错误"})
-			return
+			return time.Time{}, time.Time{}, false
 		}
 		if since.After(until) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "开始时间不能晚于结束时间"})
-			return
+			return time.Time{}, time.Time{}, false
 		}
-	} else {
-		// 使用小时数
-		h := 1
-		if hours != "" {
-			h, _ = strconv.Atoi(hours)
+		return since, until, true
+	}
+
+	// 使用小时数
+	h := defaultHours
+	if hours := c.Query("hours"); hours != "" {
+		h, _ = strconv.Atoi(hours)
+	}
+	until := time.Now()
+	return until.Add(-time.Duration(h) * time.Hour), until, true
+}
+
+// handleMetrics 获取附加指标（如带宽测试结果）
+func (s *Server) handleMetrics(c *gin.Context) {
+	targetID := c.Query("target_id")
+	if targetID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "需要提供target_id参数"})
+		return
+	}
+
+	since, until, ok := parseTimeRange(c, 24)
+	if !ok {
+		return
+	}
+
+	metrics, err := s.db.GetMetrics(targetID, c.Query("name"), since, until)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, metrics)
+}
+
+// handleEvents 获取事件列表
+func (s *Server) handleEvents(c *gin.Context) {
+	since, until, ok := parseTimeRange(c, 24*7)
+	if !ok {
+		return
+	}
+
+	events, err := s.db.GetEvents(c.Query("target_id"), since, until)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, events)
+}
+
+// handleCertificate 获取TLS目标最近一次检查到的证书详情
+func (s *Server) handleCertificate(c *gin.Context) {
+	targetID := c.Param("id")
+	if !s.checkAddrVisible(c, targetID) {
+		return
+	}
+
+	details, updatedAt, err := s.db.GetTargetInfo(targetID, models.ProbeTLS)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "没有该目标的证书信息"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"target_id":   targetID,
+		"certificate": details,
+		"updated_at":  updatedAt,
+	})
+}
+
+// handleHeartbeat 接收被动心跳目标的上报
+// 可选参数duration_ms记录为延迟（如任务耗时），status=fail表示客户端主动上报失败
+func (s *Server) handleHeartbeat(c *gin.Context) {
+	token := c.Param("token")
+
+	var target *models.Target
+	for _, t := range s.targets.Snapshot().List() {
+		if t.HeartbeatToken != "" && subtle.ConstantTimeCompare([]byte(t.HeartbeatToken), []byte(token)) == 1 {
+			target = t
+			break
 		}
-		since = time.Now().Add(-time.Duration(h) * time.Hour)
-		until = time.Now()
+	}
+	if target == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "未知的心跳令牌"})
+		return
 	}
 
-	var query string
-	var args []interface{}
+	var latency float64
+	if duration := c.Query("duration_ms"); duration != "" {
+		latency, _ = strconv.ParseFloat(duration, 64)
+	}
 
-	if targetID != "" {
-		query = `SELECT pr.target_id, t.addr, t.description, t.hide_addr, pr.latency, pr.success, pr.timestamp 
-				 FROM ping_results pr 
-				 JOIN targets t ON pr.target_id = t.id 
-				 WHERE pr.target_id = ? AND pr.timestamp >= ? AND pr.timestamp <= ? 
-				 ORDER BY pr.timestamp ASC`
-		args = []interface{}{targetID, since, until}
-	} else if addr != "" {
-		query = `SELECT pr.target_id, t.addr, t.description, t.hide_addr, pr.latency, pr.success, pr.timestamp 
-				 FROM ping_results pr 
-				 JOIN targets t ON pr.target_id = t.id 
-				 WHERE t.addr = ? AND pr.timestamp >= ? AND pr.timestamp <= ? 
-				 ORDER BY pr.timestamp ASC`
-		args = []interface{}{addr, since, until}
-	} else {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "需要提供target_id或addr参数"})
+	result := models.PingResult{
+		TargetID:  target.ID,
+		Latency:   latency,
+		Success:   c.Query("status") != "fail",
+		Timestamp: time.Now(),
+	}
+	if err := s.db.SavePingResult(result); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"target_id": target.ID,
+		"success":   result.Success,
+		"timestamp": result.Timestamp,
+	})
+}
+
+// handleRUMPage 访客延迟页面
+func (s *Server) handleRUMPage(c *gin.Context) {
+	c.HTML(http.StatusOK, "rum.html", gin.H{
+		"title": s.configManager.Get().Title,
+	})
+}
+
+// handleRUMEcho 供浏览器计时的最小往返响应，禁止缓存以保证每次都访问服务器
+func (s *Server) handleRUMEcho(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusNoContent)
+}
+
+// handleRUMSubmit 接收浏览器测得的往返延迟，按客户端网段和ASN保存为RUM样本
+func (s *Server) handleRUMSubmit(c *gin.Context) {
+	var req struct {
+		RTTs []float64 `json:"rtts"` // 各次往返耗时，毫秒
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求格式错误"})
+		return
+	}
+
+	var rtts []float64
+	for _, rtt := range req.RTTs {
+		if rtt > 0 && rtt <= rumMaxLatency && !math.IsNaN(rtt) {
+			rtts = append(rtts, rtt)
+		}
+	}
+	if len(rtts) == 0 || len(req.RTTs) > rumMaxRTTs {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("需要提供1到%d个有效的往返耗时", rumMaxRTTs)})
+		return
+	}
+
+	clientIP := c.ClientIP()
+	prefix := clientPrefix(clientIP)
+	if prefix == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无法识别客户端地址"})
 		return
 	}
 
-	rows, err := s.db.GetConn().Query(query, args...)
+	// 按网段限制频率，同一网段内轮换地址（如IPv6）不能绕过限制；另限制全部客户端的上报总数
+	now := time.Now()
+	s.rumMutex.Lock()
+	if now.Sub(s.rumWindow) >= rumSubmitInterval {
+		s.rumWindow, s.rumAccepted = now, 0
+	}
+	if s.rumAccepted >= rumMaxSubmits {
+		s.rumMutex.Unlock()
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "服务器当前接收的上报过多，请稍后再试"})
+		return
+	}
+	if last, ok := s.rumSubmits[prefix]; ok && now.Sub(last) < rumSubmitInterval {
+		s.rumMutex.Unlock()
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "上报过于频繁"})
+		return
+	}
+	s.rumSubmits[prefix] = now
+	s.rumAccepted++
+	for key, last := range s.rumSubmits {
+		if now.Sub(last) >= rumSubmitInterval {
+			delete(s.rumSubmits, key)
+		}
+	}
+	s.rumMutex.Unlock()
+
+	sample := models.RUMSample{
+		Prefix:    prefix,
+		Geo:       s.geo.Lookup(clientIP),
+		Latency:   percentile(rtts, 0.5),
+		Samples:   len(rtts),
+		Timestamp: now,
+	}
+	if err := s.db.SaveRUMSample(sample); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, sample)
+}
+
+// handleRUMSummary 按时间段聚合访客延迟
+// group_by可选prefix、asn（默认）、org、country、city；bucket为时间段长度，单位：秒，默认按查询范围自动选择
+func (s *Server) handleRUMSummary(c *gin.Context) {
+	since, until, ok := parseTimeRange(c, 24)
+	if !ok {
+		return
+	}
+
+	groupBy := c.DefaultQuery("group_by", "asn")
+	bucket := int(until.Sub(since).Seconds()) / rumMaxBuckets
+	if value := c.Query("bucket"); value != "" {
+		bucket, _ = strconv.Atoi(value)
+	}
+	if bucket < rumMinBucketSeconds {
+		bucket = rumMinBucketSeconds
+	}
+
+	samples, err := s.db.GetRUMSamples(since, until)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer rows.Close()
 
-	results := s.scanPingResults(rows)
-	c.JSON(http.StatusOK, results)
+	type point struct {
+		Timestamp time.Time `json:"timestamp"`
+		Count     int       `json:"count"`
+		Avg       float64   `json:"avg"`
+		P50       float64   `json:"p50"`
+		P95       float64   `json:"p95"`
+		Min       float64   `json:"min"`
+		Max       float64   `json:"max"`
+	}
+	type series struct {
+		Key    string  `json:"key"`
+		Label  string  `json:"label"`
+		Count  int     `json:"count"`
+		P50    float64 `json:"p50"`
+		Points []point `json:"points"`
+	}
+
+	// 按分组和时间段收集样本，样本已按时间排序
+	size := time.Duration(bucket) * time.Second
+	grouped := make(map[string]map[time.Time][]float64)
+	labels := make(map[string]string)
+	all := make(map[string][]float64)
+	var keys []string
+	for _, sample := range samples {
+		key := sample.Prefix
+		label := sample.Prefix
+		if groupBy != "prefix" {
+			key = groupKey(groupBy, sample.Geo)
+			label = key
+		}
+		if sample.Geo.Org != "" && (groupBy == "asn" || groupBy == "prefix") {
+			label += " " + sample.Geo.Org
+		}
+		if _, ok := grouped[key]; !ok {
+			grouped[key] = make(map[time.Time][]float64)
+			labels[key] = label
+			keys = append(keys, key)
+		}
+		start := sample.Timestamp.Truncate(size)
+		grouped[key][start] = append(grouped[key][start], sample.Latency)
+		all[key] = append(all[key], sample.Latency)
+	}
+
+	result := []series{}
+	for _, key := range keys {
+		item := series{Key: key, Label: labels[key], Count: len(all[key]), P50: percentile(all[key], 0.5)}
+		var starts []time.Time
+		for start := range grouped[key] {
+			starts = append(starts, start)
+		}
+		sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+		for _, start := range starts {
+			values := grouped[key][start]
+			sum, min, max := 0.0, values[0], values[0]
+			for _, v := range values {
+				sum += v
+				min = math.Min(min, v)
+				max = math.Max(max, v)
+			}
+			item.Points = append(item.Points, point{
+				Timestamp: start,
+				Count:     len(values),
+				Avg:       sum / float64(len(values)),
+				P50:       percentile(values, 0.5),
+				P95:       percentile(values, 0.95),
+				Min:       min,
+				Max:       max,
+			})
+		}
+		result = append(result, item)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Count > result[j].Count })
+
+	c.JSON(http.StatusOK, gin.H{
+		"group_by": groupBy,
+		"bucket":   bucket,
+		"series":   result,
+	})
+}
+
+// clientPrefix 返回客户端所在网段，IPv4取/24，IPv6取/48
+func clientPrefix(addr string) string {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+}
+
+// percentile 计算分位数（最近秩法），不修改传入的切片
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	idx := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return sorted[idx]
+}
+
+// handleAnycast 获取DNS目标最近一次识别到的任播节点
+func (s *Server) handleAnycast(c *gin.Context) {
+	targetID := c.Param("id")
+	node, updatedAt, err := s.db.GetTargetInfo(targetID, "anycast")
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "没有该目标的任播节点信息"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"target_id":  targetID,
+		"node":       node,
+		"updated_at": updatedAt,
+	})
+}
+
+// checkAddrVisible 检查目标地址是否允许展示，隐藏地址时返回403；
+// 已从配置中移除的目标按数据库中保存的设置判断
+func (s *Server) checkAddrVisible(c *gin.Context, targetID string) bool {
+	target, ok := s.targets.Snapshot().Get(targetID)
+	if !ok {
+		var err error
+		target, err = s.db.GetTarget(targetID)
+		if err == sql.ErrNoRows {
+			return true
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return false
+		}
+	}
+	if target.HideAddr {
+		c.JSON(http.StatusForbidden, gin.H{"error": "该目标地址已隐藏"})
+		return false
+	}
+	return true
+}
+
+// handleHappyEyeballs 获取双栈目标最近一次连接竞速的详情
+func (s *Server) handleHappyEyeballs(c *gin.Context) {
+	targetID := c.Param("id")
+	if !s.checkAddrVisible(c, targetID) {
+		return
+	}
+
+	race, updatedAt, err := s.db.GetTargetInfo(targetID, models.ProbeHappyEyeballs)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "没有该目标的连接竞速信息"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"target_id":  targetID,
+		"race":       race,
+		"updated_at": updatedAt,
+	})
+}
+
+// handleAddresses 获取目标解析地址及其ASN、地理位置的变化记录
+func (s *Server) handleAddresses(c *gin.Context) {
+	targetID := c.Param("id")
+	if !s.checkAddrVisible(c, targetID) {
+		return
+	}
+
+	since, until, ok := parseTimeRange(c, 24*7)
+	if !ok {
+		return
+	}
+
+	addresses, err := s.db.GetAddressHistory(targetID, since, until)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, addresses)
 }
 
 // handleTargets 获取所有目标
 func (s *Server) handleTargets(c *gin.Context) {
-	targets := s.db.GetTargets()
+	snapshot := s.targets.Snapshot()
 	var displayTargets []map[string]interface{}
 
-	for _, target := range targets {
-		displayAddr := target.Addr
-		if target.HideAddr {
-			displayAddr = ""
+	groupBy := c.Query("group_by")
+	groups := make(map[string][]map[string]interface{})
+
+	for _, target := range snapshot.List() {
+		displayTarget := targetView(target)
+		if groupBy != "" {
+			var geo models.GeoInfo
+			if target.Geo != nil {
+				geo = *target.Geo
+			}
+			key := groupKey(groupBy, geo)
+			groups[key] = append(groups[key], displayTarget)
+			continue
 		}
+		displayTargets = append(displayTargets, displayTarget)
+	}
 
-		displayTargets = append(displayTargets, map[string]interface{}{
-			"id":          target.ID,
-			"addr":        displayAddr,
-			"description": target.Description,
-			"hide_addr":   target.HideAddr,
-		})
+	c.Header("X-Targets-Version", strconv.FormatUint(snapshot.Version, 10))
+	if groupBy != "" {
+		c.JSON(http.StatusOK, groups)
+		return
 	}
 	c.JSON(http.StatusOK, displayTargets)
 }
 
+// targetView 目标对外展示的字段，隐藏地址的目标不返回地址
+func targetView(target *models.Target) map[string]interface{} {
+	displayAddr := target.Addr
+	if target.HideAddr {
+		displayAddr = ""
+	}
+
+	view := map[string]interface{}{
+		"id":               target.ID,
+		"addr":             displayAddr,
+		"description":      target.Description,
+		"hide_addr":        target.HideAddr,
+		"type":             target.Spec.ProbeType(),
+		"secondary_metric": secondaryMetrics[target.Spec.ProbeType()],
+	}
+
+	// 最近探测到的地址及其ASN、地理位置，隐藏地址的目标不返回IP
+	if target.ResolvedIP != "" && !target.HideAddr {
+		view["resolved_ip"] = target.ResolvedIP
+	}
+	if target.Geo != nil {
+		view["geo"] = target.Geo
+	}
+	return view
+}
+
+// handleTargetEvents 以Server-Sent Events推送目标的新增（added）、移除（removed）和变化（changed）
+func (s *Server) handleTargetEvents(c *gin.Context) {
+	events := s.targets.Subscribe(c.Request.Context())
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Accel-Buffering", "no")
+
+	c.Stream(func(w io.Writer) bool {
+		event, ok := <-events
+		if !ok {
+			return false
+		}
+		c.SSEvent(event.Type, gin.H{
+			"version": event.Version,
+			"target":  targetView(event.Target),
+		})
+		return true
+	})
+}
+
+// groupKey 按ASN、组织、国家或城市分组时使用的键，缺少信息时归入"未知"
+func groupKey(groupBy string, geo models.GeoInfo) string {
+	var key string
+	switch groupBy {
+	case "asn":
+		if geo.ASN != 0 {
+			key = fmt.Sprintf("AS%d", geo.ASN)
+		}
+	case "org":
+		key = geo.Org
+	case "country":
+		key = geo.Country
+	case "city":
+		key = geo.City
+	}
+	if key == "" {
+		return "未知"
+	}
+	return key
+}
+
 // handleConfig 获取配置信息
 func (s *Server) handleConfig(c *gin.Context) {
 	config := s.configManager.Get()
-	targets := s.db.GetTargets()
 
 	c.JSON(http.StatusOK, gin.H{
 		"title":         config.Title,
@@ -211,30 +930,201 @@ func (s *Server) handleConfig(c *gin.Context) {
 		"ping_count":    config.PingCount,
 		"web_port":      config.WebPort,
 		"default_dns":   config.DefaultDNS,
-		"targets_count": len(targets),
+		"show_retired":  config.ShowRetired,
+		"targets_count": s.targets.Snapshot().Len(),
+	})
+}
+
+// requireAdmin 校验管理接口的访问令牌（Authorization: Bearer <admin_token>），未配置令牌时拒绝所有请求
+func (s *Server) requireAdmin(c *gin.Context) {
+	token := s.configManager.Get().AdminToken
+	if token == "" {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "未配置admin_token，管理接口已禁用"})
+		return
+	}
+
+	provided := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "访问令牌无效"})
+		return
+	}
+}
+
+// activeTargetIDs 当前配置中的目标ID
+func (s *Server) activeTargetIDs() map[string]bool {
+	active := make(map[string]bool)
+	for _, target := range s.targets.Snapshot().List() {
+		active[target.ID] = true
+	}
+	return active
+}
+
+// retiredView 已移除目标对外展示的字段
+func retiredView(target models.RetiredTarget) map[string]interface{} {
+	view := targetView(&target.Target)
+	view["created_at"] = target.CreatedAt
+	view["result_count"] = target.ResultCount
+	if target.ResultCount > 0 {
+		view["first_result"] = target.FirstResult
+		view["last_result"] = target.LastResult
+	}
+	return view
+}
+
+// handleRetiredTargets 获取已从配置中移除、仍保留历史数据的目标
+func (s *Server) handleRetiredTargets(c *gin.Context) {
+	retired, err := s.db.GetRetiredTargets(s.activeTargetIDs())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	views := make([]map[string]interface{}, 0, len(retired))
+	for _, target := range retired {
+		views = append(views, retiredView(target))
+	}
+	c.JSON(http.StatusOK, views)
+}
+
+// handleRetiredHistory 获取已移除目标的归档结果，默认返回最后一条结果之前7天的数据
+// 也可以用start_time/end_time指定时间范围
+func (s *Server) handleRetiredHistory(c *gin.Context) {
+	target, err := s.db.GetRetiredTarget(c.Param("id"), s.activeTargetIDs())
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "没有该已移除目标"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 结果时间以文本保存且带有单调时钟读数，上界放宽1秒以包含最后一条结果
+	until := target.LastResult.Add(time.Second)
+	if target.ResultCount == 0 {
+		until = target.UpdatedAt
+	}
+	hours := 24 * 7
+	if h, err := strconv.Atoi(c.Query("hours")); err == nil && h > 0 {
+		hours = h
+	}
+	since := until.Add(-time.Duration(hours) * time.Hour)
+	if c.Query("start_time") != "" && c.Query("end_time") != "" {
+		var ok bool
+		if since, until, ok = parseTimeRange(c, hours); !ok {
+			return
+		}
+	}
+
+	results, err := s.db.GetPingResults(target.ID, since, until)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"target":  retiredView(target),
+		"results": results,
 	})
 }
 
+// handleReactivateTarget 将已移除的目标重新写入配置文件，配置重新加载后恢复探测并沿用原有历史数据
+func (s *Server) handleReactivateTarget(c *gin.Context) {
+	target, err := s.db.GetRetiredTarget(c.Param("id"), s.activeTargetIDs())
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "没有该已移除目标"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.configManager.AddTarget(database.ReactivationSpec(target.Target)); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("写入配置失败: %v", err)})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"target_id": target.ID, "message": "已写入配置，重新加载后生效"})
+}
+
+// handlePurgeTarget 删除已移除的目标及其全部历史数据
+func (s *Server) handlePurgeTarget(c *gin.Context) {
+	id := c.Param("id")
+	if s.activeTargetIDs()[id] {
+		c.JSON(http.StatusConflict, gin.H{"error": "目标仍在配置中，请先从配置中移除"})
+		return
+	}
+
+	err := s.db.PurgeTarget(id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "没有该已移除目标"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"target_id": id})
+}
+
+// handleDatabaseStats 获取数据库的空间占用和最近一次过期数据清理的结果
+func (s *Server) handleDatabaseStats(c *gin.Context) {
+	stats, err := s.db.Stats()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// handleBackup 在服务运行时生成数据库的一致快照并作为附件下载
+func (s *Server) handleBackup(c *gin.Context) {
+	backuper, ok := s.db.(database.Backuper)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": database.ErrBackupUnsupported.Error()})
+		return
+	}
+
+	// 备份先写入临时目录，下载完成后删除
+	dir, err := os.MkdirTemp("", "scallop-backup-")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	name := "scallop-" + time.Now().Format("20060102-150405") + ".db"
+	path := filepath.Join(dir, name)
+	err = backuper.Backup(c.Request.Context(), path)
+	if errors.Is(err, database.ErrBackupUnsupported) {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.FileAttachment(path, name)
+}
+
+// handleConfigReloads 获取最近的配置重新加载记录，最新的在前
+func (s *Server) handleConfigReloads(c *gin.Context) {
+	c.JSON(http.StatusOK, s.configManager.ReloadHistory())
+}
+
 // handleStatus 获取最新状态
 func (s *Server) handleStatus(c *gin.Context) {
-	query := `SELECT pr.target_id, t.addr, t.description, t.hide_addr, pr.latency, pr.success, pr.timestamp 
-			  FROM ping_results pr 
-			  JOIN targets t ON pr.target_id = t.id 
-			  WHERE (pr.target_id, pr.timestamp) IN (
-				  SELECT target_id, MAX(timestamp) 
-				  FROM ping_results 
-				  GROUP BY target_id
-			  )`
-
-	rows, err := s.db.GetConn().Query(query)
+	results, err := s.db.GetLatestResults()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer rows.Close()
 
-	results := s.scanPingResults(rows)
-	c.JSON(http.StatusOK, results)
+	data, err := s.pingResultsData(results)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, data)
 }
 
 // handleManifest 处理 PWA manifest.json
@@ -281,37 +1171,36 @@ func (s *Server) handleManifest(c *gin.Context) {
 	c.JSON(http.StatusOK, manifest)
 }
 
-// scanPingResults 扫描ping结果
-func (s *Server) scanPingResults(rows *sql.Rows) []map[string]interface{} {
-	var results []map[string]interface{}
-
-	for rows.Next() {
-		var targetID, addr, description string
-		var hideAddr bool
-		var latency float64
-		var success bool
-		var timestamp time.Time
+// pingResultsData 补充目标的地址和描述，转换为ping数据接口的响应
+func (s *Server) pingResultsData(items []models.PingResult) ([]map[string]interface{}, error) {
+	targets, err := s.db.LoadTargets()
+	if err != nil {
+		return nil, err
+	}
 
-		err := rows.Scan(&targetID, &addr, &description, &hideAddr, &latency, &success, &timestamp)
-		if err != nil {
+	var results []map[string]interface{}
+	for _, item := range items {
+		// 忽略已删除目标的结果
+		target, ok := targets[item.TargetID]
+		if !ok {
 			continue
 		}
 
-		displayAddr := addr
-		if hideAddr {
+		displayAddr := target.Addr
+		if target.HideAddr {
 			displayAddr = ""
 		}
 
 		results = append(results, map[string]interface{}{
-			"target_id":   targetID,
+			"target_id":   item.TargetID,
 			"addr":        displayAddr,
-			"description": description,
-			"latency":     latency,
-			"success":     success,
-			"timestamp":   timestamp,
-			"hide_addr":   hideAddr,
+			"description": target.Description,
+			"latency":     item.Latency,
+			"success":     item.Success,
+			"timestamp":   item.Timestamp,
+			"hide_addr":   target.HideAddr,
 		})
 	}
 
-	return results
+	return results, nil
 }
@@ -5,6 +5,7 @@ let selectedTargets = new Set();
 let currentHours = 1;
 let config = {};
 let customTimeRange = null; // 存储自定义时间范围 {start: Date, end: Date}
+let retiredChart = null;
 
 // 预定义的颜色数组
 const chartColors = [
@@ -55,6 +56,7 @@ const ThemeManager = {
         chart.options.scales.y.ticks.color = textColor;
         chart.options.scales.x.grid.color = gridColor;
         chart.options.scales.y.grid.color = gridColor;
+        chart.options.scales.y1.ticks.color = textColor;
         chart.options.plugins.legend.labels.color = textColor;
         chart.update('none');
     },
@@ -85,10 +87,43 @@ document.addEventListener('DOMContentLoaded', async function() {
     initChart();
     setupEventListeners();
     
-    // 定时刷新状态
+    // 定时刷新状态，配置（标题、介绍）重新加载后同样会更新
     setInterval(loadStatus, 10000);
+    setInterval(loadConfig, 60000);
+
+    // 配置重新加载导致目标变化时更新目标列表
+    watchTargets();
+
+    // 测量访客到服务器的延迟并上报
+    setTimeout(measureRUM, 3000);
+    setInterval(measureRUM, 5 * 60 * 1000);
 });
 
+// 访客延迟测量：多次请求最小响应并计时，丢弃首次（含建连开销）后上报
+async function measureRUM(rounds = 6) {
+    if (document.hidden) return null;
+    try {
+        const rtts = [];
+        for (let i = 0; i < rounds; i++) {
+            const start = performance.now();
+            const response = await fetch(`/api/rum/echo?_=${Date.now()}`, { cache: 'no-store' });
+            const elapsed = performance.now() - start;
+            if (!response.ok) return null;
+            if (i > 0) rtts.push(Math.round(elapsed * 100) / 100);
+        }
+
+        const response = await fetch('/api/rum', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ rtts })
+        });
+        return response.ok ? await response.json() : null;
+    } catch (error) {
+        console.error('访客延迟测量失败:', error);
+        return null;
+    }
+}
+
 // 加载配置
 async function loadConfig() {
     try {
@@ -108,13 +143,109 @@ async function loadConfig() {
         } else {
             descElement.style.display = 'none';
         }
+
+        // 已移除目标归档
+        document.getElementById('retired-card').style.display = config.show_retired ? 'block' : 'none';
+        if (config.show_retired) {
+            loadRetiredTargets();
+        }
     } catch (error) {
         console.error('加载配置失败:', error);
     }
 }
 
+// 加载已移除目标列表，点击一行查看其归档的延迟数据
+async function loadRetiredTargets() {
+    try {
+        const response = await fetch('/api/retired-targets');
+        const retired = await response.json() || [];
+
+        document.getElementById('retired-count').textContent = retired.length;
+        const tbody = document.getElementById('retired-targets');
+        tbody.innerHTML = '';
+        retired.forEach(target => {
+            const row = document.createElement('tr');
+            row.style.cursor = 'pointer';
+            const displayAddr = target.hide_addr ? '***' : target.addr;
+            const period = target.result_count > 0
+                ? `${new Date(target.first_result).toLocaleDateString('zh-CN')} - ${new Date(target.last_result).toLocaleDateString('zh-CN')}`
+                : '--';
+            row.innerHTML = `
+                <td>${target.description}</td>
+                <td class="text-muted">${displayAddr}</td>
+                <td>${target.result_count}</td>
+                <td class="text-muted">${period}</td>
+            `;
+            row.addEventListener('click', () => loadRetiredHistory(target.id));
+            tbody.appendChild(row);
+        });
+    } catch (error) {
+        console.error('加载已移除目标失败:', error);
+    }
+}
+
+// 加载已移除目标最后7天的归档数据
+async function loadRetiredHistory(targetId) {
+    try {
+        const response = await fetch(`/api/retired-targets/${encodeURIComponent(targetId)}/history`);
+        if (!response.ok) {
+            showNotification('该目标已不在归档中', 'warning');
+            loadRetiredTargets();
+            return;
+        }
+        const { target, results } = await response.json();
+        const data = results || [];
+
+        document.getElementById('retired-chart-wrapper').style.display = 'block';
+        const labels = data.map(item => new Date(item.timestamp).toLocaleString('zh-CN', {
+            month: '2-digit', day: '2-digit', hour: '2-digit', minute: '2-digit'
+        }));
+        const points = data.map(item => item.success ? item.latency : null);
+
+        if (!retiredChart) {
+            retiredChart = new Chart(document.getElementById('retired-chart').getContext('2d'), {
+                type: 'line',
+                data: { labels: [], datasets: [] },
+                options: {
+                    responsive: true,
+                    maintainAspectRatio: false,
+                    interaction: { intersect: false, mode: 'index' },
+                    elements: {
+                        line: { tension: 0.4, borderWidth: 2 },
+                        point: { radius: 0, hitRadius: 10, hoverRadius: 5 }
+                    },
+                    scales: {
+                        y: {
+                            beginAtZero: true,
+                            ticks: { callback: value => value + 'ms' }
+                        }
+                    }
+                }
+            });
+        }
+        retiredChart.data.labels = labels;
+        retiredChart.data.datasets = [{
+            label: target.description,
+            data: points,
+            borderColor: '#6b7280',
+            backgroundColor: '#6b728020',
+            spanGaps: true,
+            fill: false
+        }];
+        retiredChart.update();
+    } catch (error) {
+        console.error('加载归档数据失败:', error);
+    }
+}
+
 // 设置事件监听器
 function setupEventListeners() {
+    // 目标标签分组方式
+    document.getElementById('target-group-by').addEventListener('change', () => {
+        generateTargetTags();
+        updateTargetTagsUI();
+    });
+    
     // 时间范围选择
     document.querySelectorAll('.time-range-btn').forEach(btn => {
         btn.addEventListener('click', function() {
@@ -268,33 +399,115 @@ async function loadTargets() {
     }
 }
 
-// 生成目标标签
+// 订阅目标变化，同一次配置重新加载产生的多个事件合并为一次刷新
+function watchTargets() {
+    if (!window.EventSource) return;
+
+    let timer = null;
+    const source = new EventSource('/api/targets/events');
+    ['added', 'removed', 'changed'].forEach(type => {
+        source.addEventListener(type, () => {
+            clearTimeout(timer);
+            timer = setTimeout(refreshTargets, 500);
+        });
+    });
+}
+
+// 重新加载目标列表，保留仍然存在的已选目标
+async function refreshTargets() {
+    try {
+        const response = await fetch('/api/targets');
+        targets = await response.json() || [];
+
+        const ids = new Set(targets.map(target => target.id));
+        selectedTargets.forEach(id => {
+            if (!ids.has(id)) selectedTargets.delete(id);
+        });
+
+        generateTargetTags();
+        updateTargetTagsUI();
+        loadChartData();
+        loadStatus();
+        if (config.show_retired) {
+            loadRetiredTargets();
+        }
+    } catch (error) {
+        console.error('刷新目标失败:', error);
+    }
+}
+
+// 目标按ASN、组织、国家或城市分组时的键，与/api/targets的group_by一致
+function targetGroupKey(target, groupBy) {
+    const geo = target.geo || {};
+    let key = '';
+    switch (groupBy) {
+        case 'asn':
+            key = geo.asn ? `AS${geo.asn}` : '';
+            break;
+        case 'org':
+            key = geo.org;
+            break;
+        case 'country':
+            key = geo.country_name || geo.country;
+            break;
+        case 'city':
+            key = geo.city;
+            break;
+    }
+    return key || '未知';
+}
+
+// 生成目标标签，选择了分组方式时按组排列并显示组名
 function generateTargetTags() {
     const container = document.getElementById('target-tags');
     container.innerHTML = '';
     
+    const groupBy = document.getElementById('target-group-by').value;
+    const groups = new Map();
     targets.forEach((target, index) => {
-        const tag = document.createElement('div');
-        tag.className = 'target-tag';
-        tag.dataset.targetId = target.id;
-        
-        const color = chartColors[index % chartColors.length];
-        const displayAddr = target.hide_addr ? '***' : target.addr;
-        
-        tag.innerHTML = `
-            <div class="color-dot" style="background-color: ${color}"></div>
-            <span>${target.description}</span>
-            <small class="text-muted ${target.hide_addr ? 'hidden-addr' : ''}">${displayAddr}</small>
-        `;
-        
-        tag.style.color = color;
-        
-        tag.addEventListener('click', function() {
-            toggleTarget(target.id);
-        });
-        
-        container.appendChild(tag);
+        const key = groupBy ? targetGroupKey(target, groupBy) : '';
+        if (!groups.has(key)) groups.set(key, []);
+        groups.get(key).push({ target, index });
+    });
+    
+    const keys = Array.from(groups.keys());
+    if (groupBy) {
+        keys.sort((a, b) => (a === '未知') - (b === '未知') || a.localeCompare(b, 'zh-CN'));
+    }
+    
+    keys.forEach(key => {
+        if (groupBy) {
+            const label = document.createElement('span');
+            label.className = 'target-group-label';
+            label.textContent = key;
+            container.appendChild(label);
+        }
+        groups.get(key).forEach(({ target, index }) => appendTargetTag(container, target, index));
+    });
+}
+
+// 添加一个目标标签，颜色与图表中该目标的颜色一致
+function appendTargetTag(container, target, index) {
+    const tag = document.createElement('div');
+    tag.className = 'target-tag';
+    tag.dataset.targetId = target.id;
+    
+    const color = chartColors[index % chartColors.length];
+    const displayAddr = target.hide_addr ? '***' : target.addr;
+    
+    tag.innerHTML = `
+        <div class="color-dot" style="background-color: ${color}"></div>
+        <span>${target.description}</span>
+        <small class="text-muted ${target.hide_addr ? 'hidden-addr' : ''}">${displayAddr}</small>
+    `;
+    
+    tag.style.color = color;
+    
+    tag.addEventListener('click', function() {
+        toggleTarget(target.id);
     });
+    
+    container.appendChild(tag);
 }
 
 // 切换目标选择
@@ -457,6 +670,41 @@ function copyAddress(addr, button) {
     });
 }
 
+// 事件标注插件：在趋势图上用竖线标出事件（如任播节点切换、证书告警）
+const eventMarkerPlugin = {
+    id: 'eventMarkers',
+    afterDatasetsDraw(chart, args, options) {
+        const markers = options.markers || [];
+        if (markers.length === 0) {
+            return;
+        }
+        
+        const { ctx, chartArea, scales } = chart;
+        ctx.save();
+        ctx.setLineDash([4, 4]);
+        ctx.lineWidth = 1;
+        ctx.font = '11px sans-serif';
+        markers.forEach(marker => {
+            const x = scales.x.getPixelForValue(marker.index);
+            ctx.strokeStyle = marker.color;
+            ctx.fillStyle = marker.color;
+            ctx.beginPath();
+            ctx.moveTo(x, chartArea.top);
+            ctx.lineTo(x, chartArea.bottom);
+            ctx.stroke();
+            ctx.fillText(marker.label, x + 4, chartArea.top + 12);
+        });
+        ctx.restore();
+    }
+};
+
+// 事件级别对应的标注颜色
+const eventColors = {
+    'info': '#6366f1',
+    'warning': '#f59e0b',
+    'critical': '#ef4444'
+};
+
 // 初始化图表
 function initChart() {
     const ctx = document.getElementById('ping-chart').getContext('2d');
@@ -467,6 +715,7 @@ function initChart() {
     
     chart = new Chart(ctx, {
         type: 'line',
+        plugins: [eventMarkerPlugin],
         data: {
             labels: [],
             datasets: []
@@ -479,6 +728,9 @@ function initChart() {
                 mode: 'index'
             },
             plugins: {
+                eventMarkers: {
+                    markers: []
+                },
                 legend: {
                     display: true,
                     position: 'top',
@@ -538,6 +790,20 @@ function initChart() {
                             return value + 'ms';
                         }
                     }
+                },
+                // 次坐标轴，用于展示附加指标（如NTP时钟偏移）
+                y1: {
+                    display: false,
+                    position: 'right',
+                    grid: {
+                        drawOnChartArea: false
+                    },
+                    ticks: {
+                        color: textColor,
+                        callback: function(value) {
+                            return value + 'ms';
+                        }
+                    }
                 }
             },
             elements: {
@@ -555,6 +821,64 @@ function initChart() {
     });
 }
 
+// 二分查找最接近的时间点下标，列表为空时返回-1
+function nearestIndex(sortedTimes, time) {
+    if (sortedTimes.length === 0) {
+        return -1;
+    }
+    let low = 0;
+    let high = sortedTimes.length - 1;
+    while (low < high) {
+        const mid = Math.floor((low + high) / 2);
+        if (sortedTimes[mid] < time) {
+            low = mid + 1;
+        } else {
+            high = mid;
+        }
+    }
+    if (low > 0 && time - sortedTimes[low - 1] < sortedTimes[low] - time) {
+        return low - 1;
+    }
+    return low;
+}
+
+// 将附加指标对齐到图表的时间点：汇总结果（resolution为时间段长度，毫秒）取落在时间段内的指标平均值，
+// 原始结果取时间戳相同的指标
+function alignMetrics(sortedTimes, points, resolution) {
+    const sums = new Array(sortedTimes.length).fill(0);
+    const counts = new Array(sortedTimes.length).fill(0);
+    points.forEach(point => {
+        const time = new Date(point.timestamp).getTime();
+        let index = nearestIndex(sortedTimes, time);
+        if (index < 0) {
+            return;
+        }
+        if (resolution > 0) {
+            if (sortedTimes[index] > time) {
+                index--;
+            }
+            if (index < 0 || time >= sortedTimes[index] + resolution) {
+                return;
+            }
+        } else if (sortedTimes[index] !== time) {
+            return;
+        }
+        sums[index] += point.value;
+        counts[index]++;
+    });
+    return sums.map((sum, index) => counts[index] > 0 ? sum / counts[index] : null);
+}
+
+// 当前时间范围对应的查询参数
+function timeRangeQuery() {
+    if (customTimeRange) {
+        const startTime = customTimeRange.start.toISOString();
+        const endTime = customTimeRange.end.toISOString();
+        return `start_time=${encodeURIComponent(startTime)}&end_time=${encodeURIComponent(endTime)}`;
+    }
+    return `hours=${currentHours}`;
+}
+
 // 加载图表数据
 async function loadChartData() {
     if (selectedTargets.size === 0) {
@@ -566,21 +890,25 @@ async function loadChartData() {
     
     try {
         const dataPromises = Array.from(selectedTargets).map(async (targetId) => {
-            let url = `/api/ping-data?target_id=${encodeURIComponent(targetId)}`;
-            
-            // 使用自定义时间范围或小时数
-            if (customTimeRange) {
-                const startTime = customTimeRange.start.toISOString();
-                const endTime = customTimeRange.end.toISOString();
-                url += `&start_time=${encodeURIComponent(startTime)}&end_time=${encodeURIComponent(endTime)}`;
-            } else {
-                url += `&hours=${currentHours}`;
-            }
-            
+            const url = `/api/ping-data?target_id=${encodeURIComponent(targetId)}&${timeRangeQuery()}`;
             const response = await fetch(url);
-            const data = await response.json();
+            const data = await response.json() || [];
+            // 汇总结果的时间戳为时间段的开始时间
+            const resolution = (parseInt(response.headers.get('X-Resolution'), 10) || 0) * 1000;
             const target = targets.find(t => t.id === targetId);
-            return { target, data };
+            
+            // 加载次坐标轴指标
+            let secondary = [];
+            if (target.secondary_metric) {
+                const metricUrl = `/api/metrics?target_id=${encodeURIComponent(targetId)}` +
+                    `&name=${encodeURIComponent(target.secondary_metric)}&${timeRangeQuery()}`;
+                const metricResponse = await fetch(metricUrl);
+                secondary = await metricResponse.json() || [];
+            }
+            // 加载事件用于标注
+            const eventResponse = await fetch(`/api/events?target_id=${encodeURIComponent(targetId)}&${timeRangeQuery()}`);
+            const events = await eventResponse.json() || [];
+            return { target, data, resolution, secondary, events };
         });
         
         const allData = await Promise.all(dataPromises);
@@ -608,7 +936,9 @@ async function loadChartData() {
         });
         
         // 创建数据集
-        const datasets = allData.map(({ target, data }, index) => {
+        const sortedTimes = sortedTimestamps.map(timestamp => new Date(timestamp).getTime());
+        const datasets = [];
+        allData.forEach(({ target, data, resolution, secondary }) => {
             const targetIndex = targets.findIndex(t => t.id === target.id);
             const color = chartColors[targetIndex % chartColors.length];
             
@@ -619,18 +949,50 @@ async function loadChartData() {
             
             const displayAddr = target.addr && !target.hide_addr ? ` (${target.addr})` : '';
             
-            return {
+            datasets.push({
                 label: `${target.description}${displayAddr}`,
                 data: dataPoints,
                 borderColor: color,
                 backgroundColor: color + '20',
                 spanGaps: true,
                 fill: false
-            };
+            });
+            
+            if (secondary.length > 0) {
+                const secondaryPoints = alignMetrics(sortedTimes, secondary, resolution);
+                
+                datasets.push({
+                    label: `${target.description} ${target.secondary_metric}`,
+                    data: secondaryPoints,
+                    borderColor: color,
+                    backgroundColor: color + '20',
+                    borderDash: [6, 4],
+                    yAxisID: 'y1',
+                    spanGaps: true,
+                    fill: false
+                });
+            }
+        });
+        
+        // 事件标注放在最接近事件时间的数据点上
+        const markers = [];
+        allData.forEach(({ target, events }) => {
+            events.forEach(event => {
+                const index = nearestIndex(sortedTimes, new Date(event.timestamp).getTime());
+                if (index >= 0) {
+                    markers.push({
+                        index,
+                        color: eventColors[event.level] || eventColors.info,
+                        label: `${target.description}: ${event.message}`
+                    });
+                }
+            });
         });
         
         chart.data.labels = labels;
         chart.data.datasets = datasets;
+        chart.options.plugins.eventMarkers.markers = markers;
+        chart.options.scales.y1.display = datasets.some(dataset => dataset.yAxisID === 'y1');
         chart.update();
         
     } catch (error) {
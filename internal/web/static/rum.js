@@ -0,0 +1,147 @@
+// 访客延迟页面
+let rumChart = null;
+
+const rumColors = [
+    '#3b82f6', '#ef4444', '#10b981', '#f59e0b', '#8b5cf6',
+    '#ec4899', '#14b8a6', '#f97316', '#6366f1', '#84cc16'
+];
+
+// 图表最多展示的分组数量，其余仅在汇总表中列出
+const maxChartSeries = 10;
+
+document.addEventListener('DOMContentLoaded', function() {
+    const theme = localStorage.getItem('theme') || 'auto';
+    const dark = theme === 'dark' || (theme === 'auto' && window.matchMedia('(prefers-color-scheme: dark)').matches);
+    document.documentElement.setAttribute('data-bs-theme', dark ? 'dark' : 'light');
+
+    document.getElementById('group-by').addEventListener('change', loadSummary);
+    document.getElementById('hours').addEventListener('change', loadSummary);
+    document.getElementById('measure-btn').addEventListener('click', measure);
+
+    measure();
+    loadSummary();
+    setInterval(loadSummary, 60000);
+});
+
+// measure 测量本机到服务器的延迟，丢弃首次（含建连开销）后上报
+async function measure() {
+    const button = document.getElementById('measure-btn');
+    button.disabled = true;
+    try {
+        const rtts = [];
+        for (let i = 0; i < 6; i++) {
+            const start = performance.now();
+            await fetch(`/api/rum/echo?_=${Date.now()}`, { cache: 'no-store' });
+            const elapsed = performance.now() - start;
+            if (i > 0) rtts.push(Math.round(elapsed * 100) / 100);
+        }
+        rtts.sort((a, b) => a - b);
+        document.getElementById('my-latency').textContent = `${rtts[Math.ceil(rtts.length / 2) - 1].toFixed(1)}ms`;
+
+        const response = await fetch('/api/rum', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ rtts })
+        });
+        if (response.ok) {
+            const sample = await response.json();
+            const network = [sample.prefix];
+            if (sample.geo && sample.geo.asn) network.push(`AS${sample.geo.asn} ${sample.geo.org || ''}`);
+            if (sample.geo && sample.geo.city) network.push(sample.geo.city);
+            document.getElementById('my-network').textContent = network.join(' · ');
+            loadSummary();
+        }
+    } catch (error) {
+        console.error('测量失败:', error);
+    } finally {
+        button.disabled = false;
+    }
+}
+
+// loadSummary 加载聚合数据并更新图表和汇总表
+async function loadSummary() {
+    const groupBy = document.getElementById('group-by').value;
+    const hours = document.getElementById('hours').value;
+
+    try {
+        const response = await fetch(`/api/rum?group_by=${groupBy}&hours=${hours}`);
+        const summary = await response.json();
+        renderChart(summary.series.slice(0, maxChartSeries));
+        renderTable(summary.series);
+    } catch (error) {
+        console.error('加载访客延迟失败:', error);
+    }
+}
+
+function renderChart(series) {
+    const datasets = series.map((item, index) => ({
+        label: item.label,
+        data: item.points.map(point => ({ x: new Date(point.timestamp).getTime(), y: point.p50 })),
+        borderColor: rumColors[index % rumColors.length],
+        backgroundColor: rumColors[index % rumColors.length] + '20',
+        borderWidth: 2,
+        pointRadius: 2,
+        tension: 0.3
+    }));
+
+    if (rumChart) {
+        rumChart.data.datasets = datasets;
+        rumChart.update('none');
+        return;
+    }
+
+    rumChart = new Chart(document.getElementById('rum-chart').getContext('2d'), {
+        type: 'line',
+        data: { datasets },
+        options: {
+            responsive: true,
+            maintainAspectRatio: false,
+            interaction: { mode: 'nearest', intersect: false },
+            scales: {
+                x: {
+                    type: 'linear',
+                    ticks: {
+                        callback: value => new Date(value).toLocaleString('zh-CN', {
+                            month: '2-digit', day: '2-digit', hour: '2-digit', minute: '2-digit'
+                        })
+                    }
+                },
+                y: {
+                    beginAtZero: true,
+                    title: { display: true, text: '延迟 (ms)' }
+                }
+            },
+            plugins: {
+                tooltip: {
+                    callbacks: {
+                        title: items => new Date(items[0].parsed.x).toLocaleString('zh-CN'),
+                        label: item => `${item.dataset.label}: ${item.parsed.y.toFixed(1)}ms`
+                    }
+                }
+            }
+        }
+    });
+}
+
+function renderTable(series) {
+    const tbody = document.getElementById('rum-table');
+    tbody.innerHTML = '';
+    if (series.length === 0) {
+        tbody.innerHTML = '<tr><td colspan="3" class="text-muted text-center">暂无数据</td></tr>';
+        return;
+    }
+
+    series.forEach(item => {
+        const row = document.createElement('tr');
+        const label = document.createElement('td');
+        label.textContent = item.label;
+        const count = document.createElement('td');
+        count.className = 'text-end';
+        count.textContent = item.count;
+        const p50 = document.createElement('td');
+        p50.className = 'text-end';
+        p50.textContent = `${item.p50.toFixed(1)}ms`;
+        row.append(label, count, p50);
+        tbody.appendChild(row);
+    });
+}
@@ -1,4 +1,4 @@
-const CACHE_NAME = 'scallop-v1';
+const CACHE_NAME = 'scallop-v4';
 const urlsToCache = [
   '/',
   '/static/app.js',
@@ -37,6 +37,32 @@ self.addEventListener('activate', event => {
 
 // 拦截请求
 self.addEventListener('fetch', event => {
+  const url = new URL(event.request.url);
+
+  // 访客延迟测量和目标变化推送必须直达服务器
+  if (url.pathname === '/api/rum/echo' || url.pathname === '/api/targets/events') {
+    return;
+  }
+
+  // 页面、API和manifest会随配置重新加载而变化，优先从网络获取，离线时才使用缓存
+  if (url.origin === self.location.origin && !url.pathname.startsWith('/static/')) {
+    event.respondWith(
+      fetch(event.request)
+        .then(response => {
+          if (event.request.method === 'GET' && response.status === 200) {
+            const responseToCache = response.clone();
+            caches.open(CACHE_NAME)
+              .then(cache => {
+                cache.put(event.request, responseToCache);
+              });
+          }
+          return response;
+        })
+        .catch(() => caches.match(event.request))
+    );
+    return;
+  }
+
   event.respondWith(
     caches.match(event.request)
       .then(response => {
@@ -229,6 +229,7 @@ User=${SERVICE_USER}
 Group=${SERVICE_USER}
 WorkingDirectory=${DATA_DIR}
 ExecStart=${BINARY_PATH} -config ${CONFIG_DIR}/config.json -data ${DATA_DIR}
+ExecReload=/bin/kill -HUP \$MAINPID
 Restart=on-failure
 RestartSec=5s
 
@@ -238,7 +239,8 @@ RestartSec=5s
 PrivateTmp=true
 ProtectSystem=strict
 ProtectHome=true
-ReadWritePaths=${DATA_DIR}
+# 重新启用已移除的目标时需要写入配置文件
+ReadWritePaths=${DATA_DIR} ${CONFIG_DIR}
 
 # 资源限制
 LimitNOFILE=65536